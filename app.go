@@ -2,7 +2,7 @@ package main
 
 import (
 	"FactFinder/emulator"
-	// linuxmem "FactFinder/emulator/linux"
+	linuxmem "FactFinder/emulator/linux"
 	"FactFinder/emulator/nwa"
 	"FactFinder/emulator/qusb2snes"
 	"FactFinder/emulator/retroarch"
@@ -40,38 +40,58 @@ type App struct {
 
 	Ready            bool
 	factFinderFolder string
+	permissions      *repo.Permissions
 	state            [][]string
 	osConnectionCh   chan bool
 
+	// set by SetReadPlan from the UI and the emulator worker, guarded by m
 	readPlan     *emulator.ReadPlan
+	variant      *emulator.ReadPlan
+	providerPath string
+
+	game         *emulator.GameInfo
+	gameChecked  time.Time
 	memoryReader emulator.MemoryReader
 	values       []emulator.Value
+	valueRows    [][]string
+
+	// when variant was last detected, see activeReadPlan
+	versionChecked time.Time
+
+	// compiled for compiledPlan on compiledReader, see compiledReadPlan
+	compiled       *emulator.CompiledReadPlan
+	compiledPlan   *emulator.ReadPlan
+	compiledReader emulator.MemoryReader
+	// generation of compiled its unsupported watches were reported for
+	compiledGeneration int
 
 	processingEngine *processing.Engine
 
-	retroarch *retroarch.Client
-	nwa       *nwa.Client
-	qusb2snes *qusb2snes.Client
-	// linuxProcessClient *linuxmem.Client
+	retroarch          *retroarch.Client
+	nwa                *nwa.Client
+	qusb2snes          *qusb2snes.Client
+	linuxProcessClient *linuxmem.Client
 }
 
 // NewApp creates a new App application struct
 func NewApp(
 	factFinderFolder string,
+	permissions *repo.Permissions,
 	retroarchClient *retroarch.Client,
 	nwaClient *nwa.Client,
 	qusb2snesClient *qusb2snes.Client,
-	// linuxProcessClient *linuxmem.Client,
+	linuxProcessClient *linuxmem.Client,
 	processingEngine *processing.Engine,
 	osConnectionCh chan bool,
 ) *App {
 
 	return &App{
-		factFinderFolder: factFinderFolder,
-		retroarch:        retroarchClient,
-		nwa:              nwaClient,
-		qusb2snes:        qusb2snesClient,
-		// linuxProcessClient: linuxProcessClient,
+		factFinderFolder:   factFinderFolder,
+		permissions:        permissions,
+		retroarch:          retroarchClient,
+		nwa:                nwaClient,
+		qusb2snes:          qusb2snesClient,
+		linuxProcessClient: linuxProcessClient,
 
 		// default client
 		memoryReader: retroarchClient,
@@ -109,8 +129,8 @@ func (a *App) SetEmulatorClient(client string) error {
 	case "qusb2snes":
 		a.memoryReader = a.qusb2snes
 
-	// case "linuxmem":
-	// a.memoryReader = a.linuxProcessClient
+	case "linuxmem":
+		a.memoryReader = a.linuxProcessClient
 
 	default:
 		a.m.Unlock()
@@ -193,29 +213,32 @@ func (a *App) OpenFactProviderFolder() error {
 }
 
 func (a *App) SetReadPlan(path string) error {
-	f, err := os.Open(filepath.Join(path, "readplan.yml"))
-	if err != nil {
-		return err
-	}
-	defer func(f *os.File) {
-		_ = f.Close()
-	}(f)
-
 	log.Info("loaded read plan from %s", path)
 
-	a.readPlan, err = emulator.NewReadPlan(f)
+	// rooted at the providers folder so plans can extend a shared base
+	fsys, name := os.DirFS(filepath.Dir(path)), filepath.Base(path)+"/readplan.yml"
+
+	// warnings are shown when the plan loads all the same. Sent when there
+	// are none too, clearing those of the last plan.
+	readPlan, diags, err := emulator.LoadReadPlan(fsys, name)
+	if err == nil || len(diags) > 0 {
+		runtime.EventsEmit(a.ctx, "readplan:diagnostics", diags)
+	}
 	if err != nil {
 		return err
 	}
 
-	// rp := a.readPlan
+	a.m.Lock()
+	a.readPlan = readPlan
+	a.variant = nil
+	a.providerPath = path
+	a.m.Unlock()
 
-	// if linux, ok := a.memoryReader.(*linuxmem.Client); ok {
-	// linux.SetReadPlan(rp)
-	// }
+	// kept up to date whichever client is active, it attaches by ProcessName
+	a.linuxProcessClient.SetReadPlan(readPlan)
 
 	luaFile := filepath.Join(path, "factbuilder.lua")
-	err = a.processingEngine.LoadFile(luaFile, a.readPlan)
+	err = a.processingEngine.LoadFile(luaFile, readPlan)
 
 	if err != nil {
 		log.Error("failed to load lua file: %v", err)
@@ -224,38 +247,269 @@ func (a *App) SetReadPlan(path string) error {
 
 	log.Info("loaded lua factbuilder: %s", luaFile)
 
+	a.processingEngine.AllowWrites(a.permissions.WritesAllowed(path))
+
 	return nil
 }
 
+// MemoryWritesAllowed reports whether the provider at path may write game
+// memory from its factbuilder.
+func (a *App) MemoryWritesAllowed(path string) bool {
+	return a.permissions.WritesAllowed(path)
+}
+
+// AllowMemoryWrites grants or revokes memory writes for the provider at
+// path, taking effect at once when it is the active provider.
+func (a *App) AllowMemoryWrites(path string, allowed bool) error {
+	if err := a.permissions.AllowWrites(path, allowed); err != nil {
+		return err
+	}
+
+	a.m.RLock()
+	active := path == a.providerPath
+	a.m.RUnlock()
+
+	if active {
+		a.processingEngine.AllowWrites(allowed)
+	}
+
+	return nil
+}
+
+// ValidateFactProvider checks a provider's readplan.yml without activating it.
+func (a *App) ValidateFactProvider(path string) ([]emulator.Diagnostic, error) {
+	if _, err := os.Stat(filepath.Join(path, "readplan.yml")); err != nil {
+		return nil, err
+	}
+
+	return emulator.ValidateReadPlanFS(
+		os.DirFS(filepath.Dir(path)),
+		filepath.Base(path)+"/readplan.yml",
+	), nil
+}
+
+const gameCheckInterval = time.Second
+
+// selectProviderForGame loads the provider whose Match rules fit the game
+// the emulator reports, when the backend can tell and the game changed.
+func (a *App) selectProviderForGame(reader emulator.MemoryReader) {
+	identifier, ok := reader.(emulator.GameIdentifier)
+	if !ok || time.Since(a.gameChecked) < gameCheckInterval {
+		return
+	}
+	a.gameChecked = time.Now()
+
+	game, err := identifier.GameInfo()
+	if err != nil {
+		if !errors.Is(err, emulator.ErrGameNotLoaded) {
+			log.Debug("game info unavailable: %v", err)
+		}
+		a.game = nil
+		a.resetVariant()
+		return
+	}
+
+	if a.game != nil && *a.game == *game {
+		return
+	}
+	a.game = game
+	// another ROM of the same provider may be another version
+	a.resetVariant()
+
+	log.Info("emulator reports game name=%q file=%q crc=%08X", game.Name, game.File, game.CRC)
+
+	providers, err := repo.ScanReadPlans(a.factFinderFolder)
+	if err != nil {
+		log.Error("failed to scan providers: %v", err)
+		return
+	}
+
+	for _, provider := range providers {
+		if !emulator.MatchGame(provider.Match, game) {
+			continue
+		}
+
+		a.m.RLock()
+		active := provider.FilePath == a.providerPath
+		a.m.RUnlock()
+
+		if active {
+			return
+		}
+
+		if err := a.SetReadPlan(provider.FilePath); err != nil {
+			log.Error("failed to load provider %s: %v", provider.Name, err)
+			return
+		}
+
+		log.Info("selected provider %s for %s", provider.Name, game.Name)
+		runtime.EventsEmit(a.ctx, "provider:selected", provider)
+		return
+	}
+
+	log.Warn("no provider matches %s", game.Name)
+	runtime.EventsEmit(a.ctx, "provider:unmatched", game)
+}
+
+// currentReadPlan returns the loaded plan and the variant last detected for
+// it, nil until a provider is selected.
+func (a *App) currentReadPlan() (plan, variant *emulator.ReadPlan) {
+	a.m.RLock()
+	defer a.m.RUnlock()
+
+	return a.readPlan, a.variant
+}
+
+// setVariant records variant as detected for readPlan, dropped when another
+// plan was loaded meanwhile. A nil variant detects the version again.
+func (a *App) setVariant(readPlan, variant *emulator.ReadPlan) {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	if a.readPlan == readPlan {
+		a.variant = variant
+	}
+}
+
+// resetVariant detects the version of the loaded plan again.
+func (a *App) resetVariant() {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	a.variant = nil
+}
+
+// compiledReadPlan returns plan compiled for reader, compiling only when
+// either changed since the last tick. Its unsupported watches are reported
+// when compiled, and again when the reader recompiled it in place.
+func (a *App) compiledReadPlan(
+	reader emulator.MemoryReader,
+	plan *emulator.ReadPlan,
+) *emulator.CompiledReadPlan {
+	if a.compiled == nil || a.compiledPlan != plan || a.compiledReader != reader {
+		log.Debug("compiling read plan %s", plan.Name)
+
+		a.compiled = reader.CompileReadPlan(plan)
+		a.compiledPlan = plan
+		a.compiledReader = reader
+		a.compiledGeneration = a.compiled.Generation()
+
+		if len(a.compiled.Unsupported) > 0 {
+			a.sendUnsupported()
+		}
+	}
+
+	if a.compiled.Generation() != a.compiledGeneration {
+		log.Debug("read plan %s was recompiled by the reader", plan.Name)
+		a.compiledGeneration = a.compiled.Generation()
+		// sent even when empty, the last list may no longer hold
+		a.sendUnsupported()
+	}
+
+	return a.compiled
+}
+
+// sendUnsupported reports the watches the compiled plan skipped.
+func (a *App) sendUnsupported() {
+	skipped := make([]string, len(a.compiled.Unsupported))
+	for i, u := range a.compiled.Unsupported {
+		skipped[i] = u.String()
+	}
+	runtime.EventsEmit(a.ctx, "readplan:unsupported", skipped)
+}
+
+// versionCheckInterval is how often the ROM version is detected again on
+// backends that cannot report the game changing.
+const versionCheckInterval = 5 * time.Second
+
+// activeReadPlan returns the plan to read with, the variant matching the
+// loaded ROM when the plan declares Versions. Detection runs once per game,
+// or every versionCheckInterval when the reader cannot identify the game.
+// A ROM whose version cannot be detected is read with the plan itself.
+func (a *App) activeReadPlan(reader emulator.MemoryReader) (*emulator.ReadPlan, error) {
+	readPlan, current := a.currentReadPlan()
+	if len(readPlan.Versions) == 0 {
+		return readPlan, nil
+	}
+
+	_, identifies := reader.(emulator.GameIdentifier)
+	if current != nil && (identifies || time.Since(a.versionChecked) < versionCheckInterval) {
+		return current, nil
+	}
+
+	version, err := emulator.DetectRomVersion(reader, readPlan)
+	if errors.Is(err, emulator.ErrRomUnreadable) {
+		// the base watches until detection succeeds, warned once
+		if current != readPlan {
+			log.Warn("%v, reading %s without version overrides", err, readPlan.Name)
+			runtime.EventsEmit(a.ctx, "readplan:version", "cannot detect, using base watches")
+		}
+		a.setVariant(readPlan, readPlan)
+		a.versionChecked = time.Now()
+		return readPlan, nil
+	}
+	if err != nil {
+		if errors.Is(err, emulator.ErrUnknownRomVersion) {
+			a.setVariant(readPlan, nil)
+		}
+		return nil, err
+	}
+	a.versionChecked = time.Now()
+
+	variant := readPlan.Variant(version)
+	if variant == current {
+		return current, nil
+	}
+
+	a.setVariant(readPlan, variant)
+	log.Info("using %s version of %s", version.Name, readPlan.Name)
+
+	runtime.EventsEmit(a.ctx, "readplan:version", version.Name)
+
+	return variant, nil
+}
+
 func (a *App) sendState() {
 	runtime.EventsEmit(a.ctx, "emulator:state", a.state)
 }
 
 func (a *App) sendValues() {
-	out := make([][]string, len(a.values))
+	// rows are reused, the event is serialized before EventsEmit returns
+	if cap(a.valueRows) < len(a.values) {
+		a.valueRows = make([][]string, len(a.values))
+	}
+	out := a.valueRows[:len(a.values)]
+
 	for i, v := range a.values {
 		stringKey := v.Name
 		stringVal := ""
 		switch v.Type {
-		case emulator.U8, emulator.U16, emulator.U32, emulator.U64:
-			stringVal = strconv.FormatUint(v.Unsigned, 16)
-		case emulator.I8, emulator.I16, emulator.I32, emulator.I64:
+		case emulator.U8, emulator.U16, emulator.U24, emulator.U32, emulator.U64:
 			stringVal = strconv.FormatUint(v.Unsigned, 16)
+		case emulator.I8, emulator.I16, emulator.I24, emulator.I32, emulator.I64:
+			stringVal = strconv.FormatInt(v.Signed, 10)
+		case emulator.BCD:
+			stringVal = strconv.FormatUint(v.Unsigned, 10)
+		case emulator.Fixed, emulator.UFixed:
+			stringVal = strconv.FormatFloat(v.Float64, 'f', -1, 64)
+		case emulator.Bytes:
+			stringVal = fmt.Sprintf("% X", v.Bytes)
 		case emulator.F32:
 			stringVal = strconv.FormatFloat(float64(v.Float32), 'f', -1, 32)
 		case emulator.F64:
 			stringVal = strconv.FormatFloat(float64(v.Float64), 'f', -1, 64)
-		case emulator.String:
+		case emulator.String, emulator.UTF16LE:
 			stringVal = v.String
 		case emulator.Bool:
 			stringVal = strconv.FormatBool(v.Bool)
 		case emulator.FlagCount:
 			stringVal = strconv.FormatInt(int64(v.FlagCount), 10)
 		}
-		out[i] = []string{
-			stringKey,
-			stringVal,
+		if len(out[i]) != 2 {
+			out[i] = make([]string, 2)
 		}
+		out[i][0] = stringKey
+		out[i][1] = stringVal
 	}
 
 	runtime.EventsEmit(a.ctx, "emulator:values", out)
@@ -309,12 +563,18 @@ func (a *App) StartEmulatorClient() error {
 	log.Info("emulator connected")
 
 	// Wait for readplan
-	for a.readPlan == nil {
+	readPlan, _ := a.currentReadPlan()
+	for readPlan == nil {
 		select {
 		case <-ctx.Done():
 			return nil
 
 		case <-time.After(250 * time.Millisecond):
+			a.selectProviderForGame(reader)
+			if readPlan, _ = a.currentReadPlan(); readPlan != nil {
+				continue
+			}
+
 			connectionStatus.ConnectionStatus = emulator.WaitingForGame
 			connectionStatus.Message = "Select a Fact Provider"
 
@@ -326,7 +586,11 @@ func (a *App) StartEmulatorClient() error {
 		}
 	}
 
-	interval := time.Duration(a.readPlan.ReadInterval) * time.Millisecond
+	if readPlan.ReadInterval <= 0 {
+		return fmt.Errorf("invalid read interval %dms", readPlan.ReadInterval)
+	}
+
+	interval := time.Duration(readPlan.ReadInterval) * time.Millisecond
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
@@ -355,13 +619,39 @@ func (a *App) StartEmulatorClient() error {
 				}
 
 				log.Info("reconnected to emulator")
+				a.resetVariant()
 			}
 
-			compiledReadPlan := reader.CompileReadPlan(a.readPlan)
+			a.selectProviderForGame(reader)
+			readPlan, _ = a.currentReadPlan()
+			if next := time.Duration(readPlan.ReadInterval) * time.Millisecond; next != interval && next > 0 {
+				interval = next
+				ticker.Reset(interval)
+			}
+
+			plan, err := a.activeReadPlan(reader)
+			if errors.Is(err, emulator.ErrUnknownRomVersion) {
+				connectionStatus.ConnectionStatus = emulator.WaitingForGame
+				connectionStatus.Message = "Unknown ROM version"
+
+				runtime.EventsEmit(
+					a.ctx,
+					"emulator:connection",
+					connectionStatus,
+				)
+
+				log.Warn("%v", err)
+				continue
+			}
 
-			values, err := reader.GetValues(compiledReadPlan)
+			var values []emulator.Value
+			if err == nil {
+				values, err = reader.GetValues(a.compiledReadPlan(reader, plan))
+			}
 			if err != nil {
 				if errors.Is(err, emulator.ErrGameNotLoaded) {
+					a.resetVariant()
+
 					connectionStatus.ConnectionStatus = emulator.WaitingForGame
 					connectionStatus.Message = "Game not loaded"
 
@@ -387,6 +677,10 @@ func (a *App) StartEmulatorClient() error {
 				connectionStatus,
 			)
 
+			// nil when the backend cannot write
+			writer, _ := reader.(emulator.MemoryWriter)
+			a.processingEngine.SetWriter(writer, plan)
+
 			if err := a.processingEngine.ProcessValues(values); err != nil {
 				log.Error("processing engine error: %v", err)
 				continue
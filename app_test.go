@@ -0,0 +1,142 @@
+package main
+
+import (
+	"FactFinder/emulator"
+	"FactFinder/emulator/emulatortest"
+	linuxmem "FactFinder/emulator/linux"
+	"FactFinder/emulator/nwa"
+	"FactFinder/emulator/qusb2snes"
+	"FactFinder/emulator/retroarch"
+	"fmt"
+	"testing"
+)
+
+// BenchmarkCompiledReadPlan compares a tick that reuses the compiled plan
+// with one that compiles it again.
+func BenchmarkCompiledReadPlan(b *testing.B) {
+	reader := nwa.NewClient("127.0.0.1", "48879")
+	plan := emulatortest.ReadPlan()
+
+	b.Run("cached", func(b *testing.B) {
+		a := NewApp("", nil, nil, reader, nil, nil, nil, nil)
+		a.compiledReadPlan(reader, plan)
+
+		b.ReportAllocs()
+		for b.Loop() {
+			a.compiledReadPlan(reader, plan)
+		}
+	})
+
+	b.Run("recompile", func(b *testing.B) {
+		a := NewApp("", nil, nil, reader, nil, nil, nil, nil)
+
+		b.ReportAllocs()
+		for b.Loop() {
+			a.compiled = nil
+			a.compiledReadPlan(reader, plan)
+		}
+	})
+}
+
+func TestCompiledReadPlanCached(t *testing.T) {
+	reader := nwa.NewClient("127.0.0.1", "48879")
+	plan := emulatortest.ReadPlan()
+	a := NewApp("", nil, nil, reader, nil, nil, nil, nil)
+
+	first := a.compiledReadPlan(reader, plan)
+	if a.compiledReadPlan(reader, plan) != first {
+		t.Errorf("the same plan and reader compiled again")
+	}
+
+	other := emulatortest.ReadPlan()
+	if a.compiledReadPlan(reader, other) == first {
+		t.Errorf("another plan reused the compiled plan")
+	}
+}
+
+// readCost is what reading regions of sizes costs a backend: the bytes,
+// each region's framing and a round trip per region, or one for them all
+// when reads are batched.
+func readCost(caps emulator.Capabilities, sizes []int) int {
+	cost := 0
+	for _, size := range sizes {
+		cost += size + caps.RegionCost
+		if !caps.BatchedReads {
+			cost += caps.LatencyCost
+		}
+	}
+	if caps.BatchedReads {
+		cost += caps.LatencyCost
+	}
+	return cost
+}
+
+// TestRegionsByBackendCost compiles the same watches with each backend's
+// capabilities and checks no other way of grouping them is cheaper to
+// read for that backend.
+func TestRegionsByBackendCost(t *testing.T) {
+	plan := &emulator.ReadPlan{Name: "gaps", Platform: "SNES"}
+	// gaps of 39, 99, 199 and 359 bytes, wider than every PreferredGap so
+	// cost alone decides
+	addrs := []int{0, 40, 140, 340, 700}
+	for i, addr := range addrs {
+		plan.Watches = append(plan.Watches, emulator.ReadSpec{
+			Name:    fmt.Sprintf("w%d", i),
+			Type:    emulator.U8,
+			Bank:    emulator.WRAM,
+			Address: emulator.HexInt(addr),
+		})
+	}
+
+	tests := []struct {
+		name   string
+		reader emulator.MemoryReader
+	}{
+		{"qusb2snes", qusb2snes.NewClient("127.0.0.1", "23074")},
+		{"linux", linuxmem.NewClient()},
+		{"retroarch", retroarch.NewClient("127.0.0.1", "55355")},
+		{"nwa", nwa.NewClient("127.0.0.1", "48879")},
+	}
+
+	counts := make(map[int]bool)
+	for _, tt := range tests {
+		caps := tt.reader.Capabilities()
+		// every backend reads the watches' bank here, only cost differs
+		caps.Banks = nil
+
+		compiled := emulator.CompileReadPlan(plan, caps, emulator.NWA)
+		var sizes []int
+		for _, region := range compiled.Regions {
+			sizes = append(sizes, region.Size)
+		}
+		got := readCost(caps, sizes)
+
+		// every way of reading through or splitting at each gap
+		gaps := len(addrs) - 1
+		cheapest := -1
+		for through := range 1 << gaps {
+			sizes := []int{1}
+			for i := range gaps {
+				if through&(1<<i) != 0 {
+					sizes[len(sizes)-1] += addrs[i+1] - addrs[i]
+				} else {
+					sizes = append(sizes, 1)
+				}
+			}
+			if cost := readCost(caps, sizes); cheapest < 0 || cost < cheapest {
+				cheapest = cost
+			}
+		}
+
+		if got != cheapest {
+			t.Errorf("%s (merge gap %d): %d regions cost %d, want %d",
+				tt.name, caps.MergeGap(), len(compiled.Regions), got, cheapest)
+		}
+		counts[len(compiled.Regions)] = true
+	}
+
+	// the backends' costs differ enough to group the watches differently
+	if len(counts) != len(tests) {
+		t.Errorf("region counts %v, want one per backend", counts)
+	}
+}
@@ -0,0 +1,79 @@
+// Command readplan checks fact provider read plans without starting the app.
+//
+//	readplan validate <provider folder or readplan.yml>...
+//	readplan schema > readplan.schema.json
+package main
+
+import (
+	"FactFinder/emulator"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	switch os.Args[1] {
+	case "validate":
+		if len(os.Args) < 3 {
+			usage()
+		}
+		os.Exit(validate(os.Args[2:]))
+
+	case "schema":
+		schema, err := emulator.ReadPlanSchema()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "schema: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(schema))
+
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: readplan validate <provider folder or readplan.yml>...")
+	fmt.Fprintln(os.Stderr, "       readplan schema")
+	os.Exit(2)
+}
+
+// validate prints diagnostics as path:line:column: message and returns the
+// process exit code, 1 when a plan has errors, warnings alone pass. Plans
+// are resolved from the folder above their provider folder so Extends and
+// Include can reach shared base plans.
+func validate(paths []string) int {
+	code := 0
+
+	for _, path := range paths {
+		if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+			path = filepath.Join(path, "readplan.yml")
+		}
+
+		path, err := filepath.Abs(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			code = 1
+			continue
+		}
+
+		root := filepath.Dir(filepath.Dir(path))
+		name, _ := filepath.Rel(root, path)
+
+		diags := emulator.ValidateReadPlanFS(os.DirFS(root), filepath.ToSlash(name))
+		for _, d := range diags {
+			d.File = filepath.Join(root, filepath.FromSlash(d.File))
+			fmt.Println(d)
+		}
+
+		if emulator.HasErrors(diags) {
+			code = 1
+		}
+	}
+
+	return code
+}
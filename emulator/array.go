@@ -0,0 +1,102 @@
+package emulator
+
+import (
+	"slices"
+	"strconv"
+)
+
+// IsArray reports whether a watch describes a table of elements.
+func (r ReadSpec) IsArray() bool {
+	return r.Count > 0
+}
+
+// ElementStride returns the distance between two elements, defaulting to
+// the size of one element when stride is not set.
+func (r ReadSpec) ElementStride() int {
+	if r.Stride > 0 {
+		return int(r.Stride)
+	}
+
+	if len(r.Fields) == 0 {
+		return r.Size()
+	}
+
+	stride := 0
+	for _, field := range r.Fields {
+		stride = max(stride, int(field.Address)+field.Size())
+	}
+
+	return stride
+}
+
+// Expand flattens an array watch into one watch per element, or per field
+// of every element. Field addresses are relative to the start of their
+// element, behind a pointer chain they are added to the last offset.
+// Watches without a count are returned as is.
+func (r ReadSpec) Expand() []ReadSpec {
+	if !r.IsArray() {
+		return []ReadSpec{r}
+	}
+
+	stride := r.ElementStride()
+
+	fields := r.Fields
+	if len(fields) == 0 {
+		fields = []ReadSpec{{
+			Type:         r.Type,
+			SizeOverride: r.SizeOverride,
+			StringLength: r.StringLength,
+			Mask:         r.Mask,
+			Shift:        r.Shift,
+			Bit:          r.Bit,
+			Endian:       r.Endian,
+			Digits:       r.Digits,
+			FracBits:     r.FracBits,
+		}}
+	}
+
+	out := make([]ReadSpec, 0, r.Count*len(fields))
+
+	for i := range r.Count {
+		base := int(r.Address) + i*stride
+		elem := r.Name + "[" + strconv.Itoa(i+1) + "]"
+
+		for _, field := range fields {
+			spec := field
+			spec.Address = HexInt(base + int(field.Address))
+
+			if len(r.Offsets) > 0 {
+				last := len(r.Offsets) - 1
+				spec.Address = r.Address
+				spec.Offsets = slices.Clone(r.Offsets)
+				spec.Offsets[last] += HexInt(i*stride) + field.Address
+				spec.PointerSize = r.PointerSize
+			}
+
+			spec.Array = r.Name
+			spec.Index = i
+			spec.Field = field.Name
+			spec.Count = 0
+			spec.Fields = nil
+			spec.Signals = nil
+
+			spec.Name = elem
+			if field.Name != "" {
+				spec.Name += "." + field.Name
+			}
+			if spec.Bank == "" {
+				spec.Bank = r.Bank
+			}
+			if spec.Module == "" {
+				spec.Module = r.Module
+			}
+			if spec.Endian == "" {
+				spec.Endian = r.Endian
+			}
+
+			out = append(out, spec)
+		}
+	}
+
+	return out
+}
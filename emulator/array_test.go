@@ -0,0 +1,76 @@
+package emulator
+
+import "testing"
+
+func TestExpandModuleArray(t *testing.T) {
+	spec := ReadSpec{
+		Name:    "slots",
+		Type:    U32,
+		Bank:    ProcessMemory,
+		Module:  "game.dll",
+		Address: 0x1000,
+		Count:   4,
+	}
+
+	elems := spec.Expand()
+	if len(elems) != 4 {
+		t.Fatalf("expanded to %d elements, want 4", len(elems))
+	}
+	for _, elem := range elems {
+		if elem.Module != "game.dll" {
+			t.Errorf("%s has module %q, want game.dll", elem.Name, elem.Module)
+		}
+	}
+}
+
+func TestRebaseModuleArray(t *testing.T) {
+	plan := &ReadPlan{
+		ProcessName: "game.exe",
+		Watches: []ReadSpec{
+			{Name: "slots", Type: U32, Bank: ProcessMemory, Module: "game.dll", Address: 0x1000, Count: 4},
+			{Name: "chained", Type: U32, Bank: ProcessMemory, Module: "game.dll", Address: 0x2000, Offsets: []HexInt{0x10}, Count: 2},
+			{Name: "absolute", Type: U32, Bank: ProcessMemory, Address: 0x9000},
+		},
+	}
+
+	compiled := CompileReadPlan(plan, Capabilities{Banks: []Bank{ProcessMemory}}, LinuxMem)
+
+	if got := compiled.Modules(); len(got) != 1 || got[0] != "game.dll" {
+		t.Fatalf("modules %v, want [game.dll]", got)
+	}
+
+	compiled.Rebase("game.dll", 0x400000)
+
+	var sawModule, sawAbsolute bool
+	for _, region := range compiled.Regions {
+		switch region.Module {
+		case "game.dll":
+			sawModule = true
+			if region.Start != 0x401000 {
+				t.Errorf("module region starts at 0x%x, want 0x401000", region.Start)
+			}
+			for _, w := range region.Watches {
+				if w.Addr < 0x401000 || w.Addr >= 0x401010 {
+					t.Errorf("%s at 0x%x, outside the rebased array", w.Spec.Name, w.Addr)
+				}
+			}
+		case "":
+			sawAbsolute = true
+			if region.Start != 0x9000 {
+				t.Errorf("absolute region moved to 0x%x", region.Start)
+			}
+		}
+	}
+	if !sawModule || !sawAbsolute {
+		t.Fatalf("regions %+v, want a module and an absolute region", compiled.Regions)
+	}
+
+	if len(compiled.PointerChains) != 2 {
+		t.Fatalf("%d pointer chains, want 2", len(compiled.PointerChains))
+	}
+	for _, chain := range compiled.PointerChains {
+		if chain.Base != 0x402000 {
+			t.Errorf("%s chain base 0x%x, want 0x402000", chain.Spec.Name, chain.Base)
+		}
+	}
+}
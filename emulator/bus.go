@@ -0,0 +1,281 @@
+package emulator
+
+// BusTarget converts a system bus or virtual address into the bank and bank
+// address it reaches, following mirrors.
+func (p *PlatformMap) BusTarget(plan *ReadPlan, addr uint64) (Bank, int, bool) {
+	if p.Bus == nil {
+		return "", 0, false
+	}
+	return p.Bus(plan, addr)
+}
+
+// NormalizeAddress converts a watch address copied from a debugger into a
+// bank address. Addresses already inside bank, or the default bank when bank
+// is empty, are left alone. Without a bank the bank is inferred, except ROM
+// which has to be asked for, see bankRelative for old GB WRAM addresses.
+// ok is false when nothing changed.
+func NormalizeAddress(plan *ReadPlan, bank Bank, addr int) (Bank, int, bool) {
+	p, ok := LookupPlatform(plan.Platform)
+	if !ok || addr < 0 {
+		return "", 0, false
+	}
+
+	want := bank
+	if want == "" {
+		want = p.DefaultBank
+	}
+	if m, ok := LookupBank(plan.Platform, want); ok && m.Contains(addr, 1) {
+		return "", 0, false
+	}
+
+	if at, ok := bankRelative(plan, want, addr); ok {
+		return want, at, true
+	}
+
+	target, offset, ok := p.BusTarget(plan, uint64(addr))
+	if !ok {
+		return "", 0, false
+	}
+
+	if (bank != "" && target != bank) || (bank == "" && target == ROM) {
+		return "", 0, false
+	}
+
+	return target, offset, true
+}
+
+// bankRelative converts a GB WRAM address written relative to the bank,
+// $0000-$1FFF, to the $C000-$DFFF the bank is addressed by. Providers
+// written before the memory map read WRAM that way through NWA's WRAM
+// domain. ok is false for any other address.
+func bankRelative(plan *ReadPlan, bank Bank, addr int) (int, bool) {
+	if bank != WRAM || (plan.Platform != "GB" && plan.Platform != "GBC") {
+		return 0, false
+	}
+
+	m, ok := LookupBank(plan.Platform, bank)
+	if !ok || addr < 0 || addr >= m.Size {
+		return 0, false
+	}
+	return m.Start + addr, true
+}
+
+// snesBus maps 24-bit SNES addresses.
+// WRAM $7E-$7F, first 8KB mirrored in $00-$3F/$80-$BF:0000-1FFF
+// SA-1 I-RAM $00-$3F/$80-$BF:3000-37FF, BW-RAM $40-$43 (SA-1 plans only)
+// SRAM and ROM as laid out by snesSRAMBus and snesROMBus
+func snesBus(plan *ReadPlan, addr uint64) (Bank, int, bool) {
+	if addr > 0xFFFFFF {
+		return "", 0, false
+	}
+
+	bank := int(addr >> 16)
+	low := int(addr & 0xFFFF)
+
+	switch {
+	case bank == 0x7E || bank == 0x7F:
+		return WRAM, int(addr & 0x1FFFF), true
+	case bank&0x7F < 0x40 && low < 0x2000:
+		return WRAM, low, true
+	}
+
+	if plan.SA1 {
+		switch {
+		case bank&0x7F < 0x40 && low >= 0x3000 && low < 0x3800:
+			return IRAM, low - 0x3000, true
+		case bank >= 0x40 && bank < 0x44:
+			return BWRAM, int(addr - 0x400000), true
+		}
+	}
+
+	if plan.HiROM {
+		switch {
+		case bank&0x7F >= 0x30 && bank&0x7F < 0x40 && low >= 0x6000 && low < 0x8000:
+			return SRAM, (bank&0x0F)*0x2000 + low - 0x6000, true
+		case bank >= 0xC0:
+			return ROM, int(addr - 0xC00000), true
+		case bank >= 0x40 && bank < 0x7E:
+			return ROM, int(addr - 0x400000), true
+		case bank&0x7F < 0x40 && low >= 0x8000:
+			return ROM, (bank&0x3F)<<16 | low, true
+		}
+		return "", 0, false
+	}
+
+	switch {
+	case bank&0x7F >= 0x70 && bank&0x7F < 0x7E && low < 0x8000:
+		return SRAM, (bank&0x7F-0x70)*0x8000 + low, true
+	case bank != 0x7E && bank != 0x7F && low >= 0x8000:
+		return ROM, (bank&0x7F)*0x8000 + low - 0x8000, true
+	}
+	return "", 0, false
+}
+
+// gbBus maps GB/GBC addresses, echo RAM $E000-$FDFF mirrors WRAM.
+func gbBus(plan *ReadPlan, addr uint64) (Bank, int, bool) {
+	switch {
+	case addr < 0x8000:
+		return ROM, int(addr), true
+	case addr < 0xA000:
+		return VRAM, int(addr), true
+	case addr < 0xC000:
+		return CartRAM, int(addr), true
+	case addr < 0xE000:
+		return WRAM, int(addr), true
+	case addr < 0xFE00:
+		return WRAM, int(addr - 0x2000), true
+	case addr < 0xFEA0:
+		return OAM, int(addr), true
+	case addr >= 0xFF80 && addr < 0xFFFF:
+		return HRAM, int(addr), true
+	}
+	return "", 0, false
+}
+
+// nesBus maps NES addresses, RAM $0000-$07FF is mirrored up to $1FFF.
+// PRG-RAM $6000-$7FFF
+func nesBus(plan *ReadPlan, addr uint64) (Bank, int, bool) {
+	switch {
+	case addr < 0x2000:
+		return RAM, int(addr & 0x7FF), true
+	case addr >= 0x6000 && addr < 0x8000:
+		return PRGRAM, int(addr), true
+	}
+	return "", 0, false
+}
+
+// genesisBus maps 68000 addresses, the top byte is ignored.
+// RAM $FF0000-$FFFFFF, mirrored from $E00000
+func genesisBus(plan *ReadPlan, addr uint64) (Bank, int, bool) {
+	addr &= 0xFFFFFF
+
+	switch {
+	case addr >= 0xE00000:
+		return RAM, 0xFF0000 | int(addr&0xFFFF), true
+	case addr < 0x400000:
+		return ROM, int(addr), true
+	}
+	return "", 0, false
+}
+
+// gbaBus maps GBA addresses, each region is mirrored across its 16MB.
+// EWRAM 0x02000000, IWRAM 0x03000000, VRAM 0x06000000, OAM 0x07000000,
+// ROM 0x08000000-0x0DFFFFFF
+func gbaBus(plan *ReadPlan, addr uint64) (Bank, int, bool) {
+	switch addr >> 24 {
+	case 0x02:
+		return EWRAM, int(addr & 0x3FFFF), true
+	case 0x03:
+		return IWRAM, int(addr & 0x7FFF), true
+	case 0x06:
+		// 96KB mirrored in 128KB steps, the last 32KB repeat the 32KB before
+		vram := int(addr & 0x1FFFF)
+		if vram >= 0x18000 {
+			vram -= 0x8000
+		}
+		return VRAM, vram, true
+	case 0x07:
+		return OAM, int(addr & 0x3FF), true
+	case 0x08, 0x09, 0x0A, 0x0B, 0x0C, 0x0D:
+		return ROM, int(addr & 0x1FFFFFF), true
+	}
+	return "", 0, false
+}
+
+// psxBus maps PSX addresses. KUSEG, KSEG0 and KSEG1 all reach the same
+// 2MB, which is mirrored four times.
+func psxBus(plan *ReadPlan, addr uint64) (Bank, int, bool) {
+	if addr <= 0xFFFFFFFF && addr&0x1FFFFFFF < 0x800000 {
+		return RAM, int(addr & 0x1FFFFF), true
+	}
+	return "", 0, false
+}
+
+// n64Bus maps N64 addresses, KSEG0 0x80000000 and KSEG1 0xA0000000 reach
+// RDRAM and the cartridge at 0x10000000.
+func n64Bus(plan *ReadPlan, addr uint64) (Bank, int, bool) {
+	if addr > 0xFFFFFFFF {
+		return "", 0, false
+	}
+
+	phys := addr & 0x1FFFFFFF
+	switch {
+	case phys < 0x800000:
+		return RDRAM, int(phys), true
+	case phys >= 0x10000000 && phys < 0x14000000:
+		return ROM, int(phys - 0x10000000), true
+	}
+	return "", 0, false
+}
+
+// dsBus maps ARM9 addresses, main memory 0x02000000 is mirrored across 16MB.
+func dsBus(plan *ReadPlan, addr uint64) (Bank, int, bool) {
+	if addr>>24 == 0x02 {
+		return PSRAM, int(addr & 0x3FFFFF), true
+	}
+	return "", 0, false
+}
+
+// ctrBus maps 3DS physical addresses, FCRAM 0x20000000-0x27FFFFFF.
+func ctrBus(plan *ReadPlan, addr uint64) (Bank, int, bool) {
+	if addr >= 0x20000000 && addr < 0x28000000 {
+		return FCRAM, int(addr - 0x20000000), true
+	}
+	return "", 0, false
+}
+
+// smsBus maps SMS and Game Gear addresses, RAM $C000-$DFFF is mirrored at
+// $E000-$FFFF.
+func smsBus(plan *ReadPlan, addr uint64) (Bank, int, bool) {
+	if addr >= 0xC000 && addr <= 0xFFFF {
+		return RAM, 0xC000 | int(addr&0x1FFF), true
+	}
+	return "", 0, false
+}
+
+// pceBus maps HuC6280 physical addresses, RAM $1F0000 mirrored up to
+// $1F7FFF, and the logical $2000-$3FFF games map it at.
+func pceBus(plan *ReadPlan, addr uint64) (Bank, int, bool) {
+	switch {
+	case addr >= 0x2000 && addr < 0x4000:
+		return RAM, int(addr - 0x2000), true
+	case addr >= 0x1F0000 && addr < 0x1F8000:
+		return RAM, int(addr & 0x1FFF), true
+	}
+	return "", 0, false
+}
+
+// saturnBus maps SH-2 addresses, the top three bits select the cache mode.
+// LWRAM 0x00200000, HWRAM 0x06000000 mirrored up to 0x07FFFFFF
+func saturnBus(plan *ReadPlan, addr uint64) (Bank, int, bool) {
+	if addr > 0xFFFFFFFF {
+		return "", 0, false
+	}
+
+	phys := addr & 0x07FFFFFF
+	switch {
+	case phys >= 0x00200000 && phys < 0x00300000:
+		return LWRAM, int(phys & 0xFFFFF), true
+	case phys >= 0x06000000:
+		return HWRAM, int(phys & 0xFFFFF), true
+	}
+	return "", 0, false
+}
+
+// ngpBus maps TLCS-900H addresses, work RAM $4000-$7FFF.
+func ngpBus(plan *ReadPlan, addr uint64) (Bank, int, bool) {
+	if addr >= 0x4000 && addr < 0x8000 {
+		return RAM, int(addr), true
+	}
+	return "", 0, false
+}
+
+// a2600Bus maps 6507 addresses. The RIOT RAM answers whenever A12 and A9
+// are low and A7 is high, so $80-$FF repeats across the 8KB space.
+func a2600Bus(plan *ReadPlan, addr uint64) (Bank, int, bool) {
+	a := addr & 0x1FFF
+	if a&0x1000 == 0 && a&0x0200 == 0 && a&0x0080 != 0 {
+		return RAM, 0x80 | int(a&0x7F), true
+	}
+	return "", 0, false
+}
@@ -0,0 +1,62 @@
+package emulator
+
+import "slices"
+
+// Capabilities describe what a backend can read and what a read costs,
+// CompileReadPlan merges regions with them.
+type Capabilities struct {
+	// largest single read in bytes
+	MaxReadSize int
+	// gap the backend always reads through rather than split a region
+	PreferredGap int
+	// banks the backend can read, nil for every bank
+	Banks []Bank
+	// several regions go out in one request
+	BatchedReads bool
+	// cost of one round trip, in bytes that could be transferred instead
+	LatencyCost int
+	// cost of one more region besides its round trip, the framing of its
+	// request and reply, in bytes
+	RegionCost int
+	// byte order the backend returns a watch in, nil for the watch's own
+	NativeEndian func(plan *ReadPlan, spec ReadSpec) Endian
+}
+
+// DefaultCapabilities are the limits used before backends described
+// themselves.
+var DefaultCapabilities = Capabilities{
+	MaxReadSize:  MaxReadSize,
+	PreferredGap: MaxGap,
+}
+
+func (c Capabilities) SupportsBank(bank Bank) bool {
+	return c.Banks == nil || slices.Contains(c.Banks, bank)
+}
+
+// ByteOrder returns the byte order spec comes back from the backend in.
+func (c Capabilities) ByteOrder(plan *ReadPlan, spec ReadSpec) Endian {
+	if c.NativeEndian != nil {
+		return c.NativeEndian(plan, spec)
+	}
+	return WatchEndian(plan, spec)
+}
+
+// MergeGap returns the widest gap worth reading through. Reading the gap
+// costs its bytes, starting a new region costs its framing and a round
+// trip. Batched reads pay the round trip once for every region.
+func (c Capabilities) MergeGap() int {
+	split := c.RegionCost
+	if !c.BatchedReads {
+		split += c.LatencyCost
+	}
+
+	return max(c.PreferredGap, split)
+}
+
+// ReadSize returns the largest read to issue, MaxReadSize when unset.
+func (c Capabilities) ReadSize() int {
+	if c.MaxReadSize <= 0 {
+		return MaxReadSize
+	}
+	return c.MaxReadSize
+}
@@ -0,0 +1,25 @@
+package emulator
+
+import "testing"
+
+func TestMergeGap(t *testing.T) {
+	tests := []struct {
+		name string
+		caps Capabilities
+		want int
+	}{
+		{"preferred gap without a cost", Capabilities{PreferredGap: 16}, 16},
+		{"round trip", Capabilities{PreferredGap: 16, LatencyCost: 128}, 128},
+		{"round trip and framing", Capabilities{PreferredGap: 16, LatencyCost: 128, RegionCost: 16}, 144},
+		{"preferred gap above the cost", Capabilities{PreferredGap: 64, LatencyCost: 32}, 64},
+		// the round trip is paid once whatever the regions
+		{"batched", Capabilities{PreferredGap: 32, LatencyCost: 1024, RegionCost: 16, BatchedReads: true}, 32},
+		{"batched framing", Capabilities{PreferredGap: 4, LatencyCost: 1024, RegionCost: 16, BatchedReads: true}, 16},
+	}
+
+	for _, tt := range tests {
+		if got := tt.caps.MergeGap(); got != tt.want {
+			t.Errorf("%s: MergeGap() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
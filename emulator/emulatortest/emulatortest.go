@@ -0,0 +1,175 @@
+// Package emulatortest provides the read plan and memory the backends'
+// tests and benchmarks read.
+package emulatortest
+
+import (
+	"FactFinder/emulator"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+// ReadPlan is the size of a full game's plan: the watches a split tracker
+// reads every tick, an array of structs, a name, raw bytes and scattered
+// WRAM watches. Every watch is in SNES WRAM.
+func ReadPlan() *emulator.ReadPlan {
+	plan := &emulator.ReadPlan{
+		Name:     "bench",
+		Platform: "SNES",
+		Watches: []emulator.ReadSpec{
+			{Name: "level", Type: emulator.U8, Bank: emulator.WRAM, Address: 0x13BF},
+			{Name: "timer", Type: emulator.U16, Bank: emulator.WRAM, Address: 0x0F31},
+			{Name: "exits", Type: emulator.U8, Bank: emulator.WRAM, Address: 0x1F2E},
+			{Name: "flags", Type: emulator.FlagCount, Bank: emulator.WRAM, Address: 0x1F02, Mask: 0xFF, Count: 15, Stride: 1},
+			{Name: "player", Type: emulator.UTF16LE, Bank: emulator.WRAM, Address: 0x1F40, StringLength: 8},
+			{Name: "items", Type: emulator.Bytes, Bank: emulator.WRAM, Address: 0x1F50, SizeOverride: 4},
+			{
+				Name:    "enemies",
+				Bank:    emulator.WRAM,
+				Address: 0x1000,
+				Count:   12,
+				Stride:  0x10,
+				Fields: []emulator.ReadSpec{
+					{Name: "hp", Type: emulator.U16, Address: 0},
+					{Name: "x", Type: emulator.I16, Address: 2},
+					{Name: "alive", Type: emulator.Bool, Address: 4, Bit: new(int)},
+				},
+			},
+		},
+	}
+
+	for i := range 48 {
+		plan.Watches = append(plan.Watches, emulator.ReadSpec{
+			Name:    fmt.Sprintf("w%d", i),
+			Type:    emulator.U16,
+			Bank:    emulator.WRAM,
+			Address: emulator.HexInt(i * 0x40),
+		})
+	}
+
+	return plan
+}
+
+// WRAM returns the 8KB of SNES WRAM ReadPlan reads, holding the values
+// CheckValues expects.
+func WRAM() []byte {
+	mem := make([]byte, 0x2000)
+	mem[0x13BF] = 0x2A
+	binary.LittleEndian.PutUint16(mem[0x0F31:], 0x0123)
+	copy(mem[0x1F40:], "M\x00A\x00R\x00I\x00O\x00")
+	copy(mem[0x1F50:], []byte{1, 2, 3, 4})
+	binary.LittleEndian.PutUint16(mem[0x10B0:], 0x0456)
+	return mem
+}
+
+// CheckValues fails t when vals, read with ReadPlan from WRAM, lack a
+// value or hold another.
+func CheckValues(t testing.TB, vals []emulator.Value) {
+	t.Helper()
+
+	got := make(map[string]emulator.Value, len(vals))
+	for _, v := range vals {
+		got[v.Name] = v
+	}
+
+	if got["level"].Unsigned != 0x2A || got["timer"].Unsigned != 0x0123 {
+		t.Errorf("read level=0x%X timer=0x%X, want 0x2A and 0x123", got["level"].Unsigned, got["timer"].Unsigned)
+	}
+	if got["player"].String != "MARIO" || !bytes.Equal(got["items"].Bytes, []byte{1, 2, 3, 4}) {
+		t.Errorf("read player=%q items=% X, want MARIO and 01 02 03 04", got["player"].String, got["items"].Bytes)
+	}
+	if hp := got["enemies[12].hp"]; hp.Unsigned != 0x0456 {
+		t.Errorf("read enemies[12].hp=0x%X, want 0x456", hp.Unsigned)
+	}
+
+	// flags and enemies are a value per element and field
+	if want := 5 + 15 + 12*3 + 48; len(vals) != want {
+		t.Errorf("read %d values, want %d", len(vals), want)
+	}
+}
+
+// byteOrderValues are the values ByteOrderPlan reads.
+var byteOrderValues = map[string]uint64{
+	"little":  0x11223344,
+	"big":     0x11223344,
+	"swapped": 0x11223344,
+	"half":    0x1122,
+	"default": 0x11223344,
+}
+
+// ByteOrderPlan reads a U32 in each byte order from bank, one in the
+// platform's own, and a big-endian U16 from the second half of a word.
+func ByteOrderPlan(platform string, bank emulator.Bank) *emulator.ReadPlan {
+	return &emulator.ReadPlan{
+		Name:     "byteorder",
+		Platform: platform,
+		Watches: []emulator.ReadSpec{
+			{Name: "little", Type: emulator.U32, Bank: bank, Address: 0x00, Endian: emulator.LittleEndian},
+			{Name: "big", Type: emulator.U32, Bank: bank, Address: 0x10, Endian: emulator.BigEndian},
+			{Name: "swapped", Type: emulator.U32, Bank: bank, Address: 0x20, Endian: emulator.WordSwapped},
+			{Name: "half", Type: emulator.U16, Bank: bank, Address: 0x32, Endian: emulator.BigEndian},
+			{Name: "default", Type: emulator.U32, Bank: bank, Address: 0x40},
+		},
+	}
+}
+
+// ByteOrderMemory returns size bytes holding the values of plan, a
+// ByteOrderPlan, as the console stores them. With hostWords every 32-bit
+// word is reversed, as a little-endian host holding the words of a
+// big-endian console exposes them.
+func ByteOrderMemory(plan *emulator.ReadPlan, size int, hostWords bool) []byte {
+	mem := make([]byte, size)
+	for _, w := range plan.Watches {
+		b := mem[w.Address : int(w.Address)+w.Size()]
+		v := byteOrderValues[w.Name]
+
+		switch emulator.WatchEndian(plan, w) {
+		case emulator.LittleEndian:
+			putUint(b, v, binary.LittleEndian)
+		case emulator.BigEndian:
+			putUint(b, v, binary.BigEndian)
+		case emulator.WordSwapped:
+			putUint(b, v, binary.BigEndian)
+			reverseWords(b)
+		}
+	}
+
+	if hostWords {
+		reverseWords(mem)
+	}
+	return mem
+}
+
+func putUint(b []byte, v uint64, order binary.ByteOrder) {
+	if len(b) == 2 {
+		order.PutUint16(b, uint16(v))
+		return
+	}
+	order.PutUint32(b, uint32(v))
+}
+
+// reverseWords reverses the bytes of every 32-bit word of b.
+func reverseWords(b []byte) {
+	for i := 0; i+4 <= len(b); i += 4 {
+		b[i], b[i+1], b[i+2], b[i+3] = b[i+3], b[i+2], b[i+1], b[i]
+	}
+}
+
+// CheckByteOrder fails t when vals, read with a ByteOrderPlan from its
+// ByteOrderMemory, hold a value decoded in the wrong byte order: swapped
+// not at all or twice.
+func CheckByteOrder(t testing.TB, vals []emulator.Value) {
+	t.Helper()
+
+	got := make(map[string]uint64, len(vals))
+	for _, v := range vals {
+		got[v.Name] = v.Unsigned
+	}
+
+	for name, want := range byteOrderValues {
+		if v, ok := got[name]; !ok || v != want {
+			t.Errorf("read %s=0x%X, want 0x%X", name, v, want)
+		}
+	}
+}
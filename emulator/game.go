@@ -0,0 +1,67 @@
+package emulator
+
+import (
+	"path"
+	"strings"
+)
+
+// GameInfo identifies the game a backend has loaded. Fields the backend
+// does not report are empty, CRC is 0 when unknown.
+type GameInfo struct {
+	Name string
+	File string
+	CRC  uint32
+}
+
+// GameIdentifier is implemented by backends that can report the loaded
+// game. It returns ErrGameNotLoaded when nothing is running.
+type GameIdentifier interface {
+	GameInfo() (*GameInfo, error)
+}
+
+// GameMatch is one rule of a read plan's Match list. Name and file are
+// case-insensitive glob patterns, file is matched against the base name of
+// the ROM. Every field that is set has to match.
+type GameMatch struct {
+	Name string  `yaml:"name,omitempty"`
+	File string  `yaml:"file,omitempty"`
+	CRC  *HexInt `yaml:"crc,omitempty"`
+}
+
+func (m GameMatch) Matches(game *GameInfo) bool {
+	if m.Name == "" && m.File == "" && m.CRC == nil {
+		return false
+	}
+
+	if m.Name != "" && !globMatch(m.Name, game.Name) {
+		return false
+	}
+
+	if m.File != "" {
+		file := path.Base(strings.ReplaceAll(game.File, "\\", "/"))
+		if game.File == "" || !globMatch(m.File, file) {
+			return false
+		}
+	}
+
+	if m.CRC != nil && uint32(*m.CRC) != game.CRC {
+		return false
+	}
+
+	return true
+}
+
+// MatchGame reports whether any of rules matches game.
+func MatchGame(rules []GameMatch, game *GameInfo) bool {
+	for _, rule := range rules {
+		if rule.Matches(game) {
+			return true
+		}
+	}
+	return false
+}
+
+func globMatch(pattern, value string) bool {
+	ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(strings.TrimSpace(value)))
+	return ok
+}
@@ -0,0 +1,88 @@
+package emulator
+
+import "testing"
+
+func TestMatchGame(t *testing.T) {
+	crc := HexInt(0xB19ED489)
+	other := HexInt(0x12345678)
+
+	tests := []struct {
+		name  string
+		rules []GameMatch
+		game  GameInfo
+		want  bool
+	}{
+		{
+			"name glob",
+			[]GameMatch{{Name: "super mario world*"}},
+			GameInfo{Name: "Super Mario World (USA)"},
+			true,
+		},
+		{
+			"name mismatch",
+			[]GameMatch{{Name: "Super Metroid*"}},
+			GameInfo{Name: "Super Mario World (USA)"},
+			false,
+		},
+		{
+			"file base name",
+			[]GameMatch{{File: "*.sfc"}},
+			GameInfo{File: "/roms/snes/SMW.SFC"},
+			true,
+		},
+		{
+			"windows file path",
+			[]GameMatch{{File: "smw.sfc"}},
+			GameInfo{File: `C:\roms\smw.sfc`},
+			true,
+		},
+		{
+			"file not reported",
+			[]GameMatch{{File: "*"}},
+			GameInfo{Name: "Super Mario World (USA)"},
+			false,
+		},
+		{
+			"crc",
+			[]GameMatch{{CRC: &crc}},
+			GameInfo{Name: "anything", CRC: 0xB19ED489},
+			true,
+		},
+		{
+			"crc mismatch",
+			[]GameMatch{{CRC: &other}},
+			GameInfo{CRC: 0xB19ED489},
+			false,
+		},
+		{
+			"every field has to match",
+			[]GameMatch{{Name: "Super Mario World*", CRC: &other}},
+			GameInfo{Name: "Super Mario World (USA)", CRC: 0xB19ED489},
+			false,
+		},
+		{
+			"any rule",
+			[]GameMatch{{Name: "Super Metroid*"}, {CRC: &crc}},
+			GameInfo{Name: "Super Mario World (USA)", CRC: 0xB19ED489},
+			true,
+		},
+		{
+			"empty rule",
+			[]GameMatch{{}},
+			GameInfo{Name: "Super Mario World (USA)"},
+			false,
+		},
+		{
+			"no rules",
+			nil,
+			GameInfo{Name: "Super Mario World (USA)"},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		if got := MatchGame(tt.rules, &tt.game); got != tt.want {
+			t.Errorf("%s: matched %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package emulator
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// planLoader resolves Extends and Include of read plans in a providers
+// folder. Every node is recorded with the file it came from so diagnostics
+// on the flattened plan point back to the right file.
+type planLoader struct {
+	fsys  fs.FS
+	files map[*yaml.Node]string
+}
+
+// LoadReadPlan loads the read plan name from fsys, resolving Extends and
+// Include into one flattened plan. Names are slash separated and relative
+// to fsys, usually the providers folder. The diagnostics are returned
+// whether the plan loaded or not, a *ValidationError holds them too.
+func LoadReadPlan(fsys fs.FS, name string) (*ReadPlan, []Diagnostic, error) {
+	l := &planLoader{fsys: fsys, files: make(map[*yaml.Node]string)}
+
+	root, diags := l.load(name, nil)
+	if root != nil {
+		diags = append(diags, validatePlan(root, l.files)...)
+	}
+
+	if HasErrors(diags) {
+		err := &ValidationError{Diagnostics: diags}
+		log.Error("invalid readplan %s: %v", name, err)
+		return nil, diags, err
+	}
+	for _, d := range diags {
+		log.Warn("readplan %s: %v", name, d)
+	}
+
+	plan, err := decodeReadPlan(root, l.files)
+	return plan, diags, err
+}
+
+// ValidateReadPlanFS is ValidateReadPlan for a plan that may extend or
+// include others. Diagnostics carry the file they were found in.
+func ValidateReadPlanFS(fsys fs.FS, name string) []Diagnostic {
+	l := &planLoader{fsys: fsys, files: make(map[*yaml.Node]string)}
+
+	root, diags := l.load(name, nil)
+	if root == nil {
+		return diags
+	}
+
+	return append(diags, validatePlan(root, l.files)...)
+}
+
+// ReadPlanSummary is what the provider list needs of a read plan.
+type ReadPlanSummary struct {
+	Name  string      `yaml:"Name"`
+	Match []GameMatch `yaml:"Match"`
+}
+
+// SummarizeReadPlan returns the Name and Match rules of a read plan after
+// resolving Extends and Include, without validating anything else.
+func SummarizeReadPlan(fsys fs.FS, name string) (*ReadPlanSummary, error) {
+	l := &planLoader{fsys: fsys, files: make(map[*yaml.Node]string)}
+
+	root, diags := l.load(name, nil)
+	if len(diags) > 0 {
+		return nil, &ValidationError{Diagnostics: diags}
+	}
+
+	summary := ReadPlanSummary{}
+	if err := root.Decode(&summary); err != nil {
+		return nil, err
+	}
+
+	return &summary, nil
+}
+
+// load reads name and layers it over the plans it extends and includes.
+// stack holds the files currently being loaded, for cycle detection.
+func (l *planLoader) load(name string, stack []string) (*yaml.Node, []Diagnostic) {
+	raw, err := fs.ReadFile(l.fsys, name)
+	if err != nil {
+		return nil, []Diagnostic{{File: name, Message: fmt.Sprintf("read %s: %v", name, pathError(err))}}
+	}
+
+	return l.parse(name, raw, stack)
+}
+
+func (l *planLoader) parse(name string, raw []byte, stack []string) (*yaml.Node, []Diagnostic) {
+	root, diags := parsePlan(raw)
+	if root == nil {
+		for i := range diags {
+			diags[i].File = name
+		}
+		return nil, diags
+	}
+
+	l.mark(root, name)
+
+	bases := l.bases(root, &diags)
+	if len(bases) == 0 {
+		return root, diags
+	}
+
+	stack = append(stack, name)
+	dir := path.Dir(name)
+
+	merged := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: root.Line, Column: root.Column}
+	l.files[merged] = name
+
+	for _, base := range bases {
+		if l.fsys == nil {
+			diags = append(diags, l.diag(base, "%q can only be resolved from a providers folder", base.Value))
+			continue
+		}
+
+		target := path.Join(dir, base.Value)
+
+		if strings.HasPrefix(base.Value, "/") || !fs.ValidPath(target) {
+			diags = append(diags, l.diag(base, "%q is outside the providers folder", base.Value))
+			continue
+		}
+
+		if i := slices.Index(stack, target); i >= 0 {
+			cycle := append(slices.Clone(stack[i:]), target)
+			diags = append(diags, l.diag(base, "include cycle: %s", strings.Join(cycle, " -> ")))
+			continue
+		}
+
+		raw, err := fs.ReadFile(l.fsys, target)
+		if err != nil {
+			diags = append(diags, l.diag(base, "read %s: %v", target, pathError(err)))
+			continue
+		}
+
+		node, baseDiags := l.parse(target, raw, stack)
+		diags = append(diags, baseDiags...)
+		if node != nil {
+			l.mergePlan(merged, node)
+		}
+	}
+
+	l.mergePlan(merged, root)
+
+	return merged, diags
+}
+
+// bases returns the file names of Extends followed by Include. Either may
+// be a single name or a list.
+func (l *planLoader) bases(root *yaml.Node, diags *[]Diagnostic) []*yaml.Node {
+	var out []*yaml.Node
+
+	for _, key := range []string{"Extends", "Include"} {
+		value := mappingValue(root, key)
+		switch {
+		case value == nil:
+		case value.Kind == yaml.ScalarNode:
+			out = append(out, value)
+		case value.Kind == yaml.SequenceNode:
+			for _, item := range value.Content {
+				if item.Kind != yaml.ScalarNode {
+					*diags = append(*diags, l.diag(item, "%s entries must be file names", key))
+					continue
+				}
+				out = append(out, item)
+			}
+		default:
+			*diags = append(*diags, l.diag(value, "%s must be a file name or a list of them", key))
+		}
+	}
+
+	return out
+}
+
+// mergePlan layers over onto dst. Top level keys of over replace those of
+// dst, except Watches which are merged by name. Extends and Include are
+// dropped, they have been resolved.
+func (l *planLoader) mergePlan(dst, over *yaml.Node) {
+	if over.Kind != yaml.MappingNode {
+		return
+	}
+
+	for i := 0; i+1 < len(over.Content); i += 2 {
+		key, value := over.Content[i], over.Content[i+1]
+
+		switch key.Value {
+		case "Extends", "Include":
+			continue
+		case "Watches":
+			if base := mappingValue(dst, "Watches"); base != nil &&
+				base.Kind == yaml.SequenceNode && value.Kind == yaml.SequenceNode {
+				setMappingValue(dst, key, mergeWatches(l.files, base, value))
+				continue
+			}
+		}
+
+		setMappingValue(dst, key, value)
+	}
+}
+
+// mergeWatches overrides watches of base with the ones of the same name in
+// over, key by key, and appends the rest.
+func mergeWatches(files map[*yaml.Node]string, base, over *yaml.Node) *yaml.Node {
+	out := &yaml.Node{
+		Kind:    yaml.SequenceNode,
+		Tag:     "!!seq",
+		Line:    over.Line,
+		Column:  over.Column,
+		Content: slices.Clone(base.Content),
+	}
+	files[out] = files[over]
+
+	for _, item := range over.Content {
+		name := mappingValue(item, "name")
+		if name == nil {
+			out.Content = append(out.Content, item)
+			continue
+		}
+
+		i := slices.IndexFunc(out.Content, func(w *yaml.Node) bool {
+			n := mappingValue(w, "name")
+			return n != nil && n.Value == name.Value
+		})
+		if i < 0 {
+			out.Content = append(out.Content, item)
+			continue
+		}
+
+		watch := &yaml.Node{
+			Kind:    yaml.MappingNode,
+			Tag:     "!!map",
+			Line:    item.Line,
+			Column:  item.Column,
+			Content: slices.Clone(out.Content[i].Content),
+		}
+		files[watch] = files[item]
+
+		for j := 0; j+1 < len(item.Content); j += 2 {
+			setMappingValue(watch, item.Content[j], item.Content[j+1])
+		}
+		out.Content[i] = watch
+	}
+
+	return out
+}
+
+// variantNode returns root with the watches of a Versions entry merged
+// over its Watches, the node a version's plan is decoded from.
+func variantNode(files map[*yaml.Node]string, root, version *yaml.Node) *yaml.Node {
+	out := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: root.Line, Column: root.Column}
+	files[out] = files[root]
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "Versions" {
+			out.Content = append(out.Content, root.Content[i], root.Content[i+1])
+		}
+	}
+
+	watches := mappingValue(root, "Watches")
+	overrides := mappingValue(version, "watches")
+	if watches != nil && overrides != nil &&
+		watches.Kind == yaml.SequenceNode && overrides.Kind == yaml.SequenceNode {
+		setMappingValue(out, &yaml.Node{Kind: yaml.ScalarNode, Value: "Watches"},
+			mergeWatches(files, watches, overrides))
+	}
+
+	return out
+}
+
+func (l *planLoader) mark(node *yaml.Node, name string) {
+	l.files[node] = name
+	for _, child := range node.Content {
+		l.mark(child, name)
+	}
+}
+
+func (l *planLoader) diag(node *yaml.Node, format string, args ...any) Diagnostic {
+	return Diagnostic{
+		File:    l.files[node],
+		Line:    node.Line,
+		Column:  node.Column,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// parsePlan parses raw into its root node. A nil node comes with the
+// diagnostic explaining why.
+func parsePlan(raw []byte) (*yaml.Node, []Diagnostic) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		line := 0
+		if m := linePattern.FindStringSubmatch(err.Error()); m != nil {
+			line, _ = strconv.Atoi(m[1])
+		}
+		return nil, []Diagnostic{{
+			Line:    line,
+			Message: strings.TrimPrefix(err.Error(), "yaml: "),
+		}}
+	}
+
+	if len(doc.Content) == 0 {
+		return nil, []Diagnostic{{Line: 1, Column: 1, Message: "empty read plan"}}
+	}
+
+	return doc.Content[0], nil
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setMappingValue replaces the value of key in node, or appends the pair.
+func setMappingValue(node, key, value *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key.Value {
+			node.Content[i+1] = value
+			return
+		}
+	}
+	node.Content = append(node.Content, key, value)
+}
+
+func pathError(err error) error {
+	if pe, ok := err.(*fs.PathError); ok {
+		return pe.Err
+	}
+	return err
+}
@@ -0,0 +1,203 @@
+package emulator
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+const baseYAML = `
+Name: Base
+Platform: SNES
+ReadInterval: 16
+Watches:
+  - name: lives
+    type: U8
+    address: 0x10
+  - name: coins
+    type: U16
+    address: 0x20
+`
+
+// watchesByName indexes the watches of plan.
+func watchesByName(plan *ReadPlan) map[string]ReadSpec {
+	out := make(map[string]ReadSpec, len(plan.Watches))
+	for _, w := range plan.Watches {
+		out[w.Name] = w
+	}
+	return out
+}
+
+func TestLoadReadPlanExtends(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.yml": {Data: []byte(baseYAML)},
+		"smw/readplan.yml": {Data: []byte(`
+Extends: ../base.yml
+Name: Super Mario World
+`)},
+	}
+
+	plan, diags, err := LoadReadPlan(fsys, "smw/readplan.yml")
+	if err != nil {
+		t.Fatalf("load: %v %v", err, diags)
+	}
+	if plan.Name != "Super Mario World" || plan.Platform != "SNES" || plan.ReadInterval != 16 {
+		t.Errorf("plan %s %s %d, want the base's platform and interval under its own name",
+			plan.Name, plan.Platform, plan.ReadInterval)
+	}
+	if len(plan.Watches) != 2 {
+		t.Errorf("watches %+v, want the base's two", plan.Watches)
+	}
+}
+
+func TestLoadReadPlanInclude(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.yml": {Data: []byte(baseYAML)},
+		"shared/timer.yml": {Data: []byte(`
+Watches:
+  - name: timer
+    type: U16
+    address: 0x30
+`)},
+		"shared/exits.yml": {Data: []byte(`
+Watches:
+  - name: exits
+    type: U8
+    address: 0x40
+`)},
+		"smw/readplan.yml": {Data: []byte(`
+Extends: ../base.yml
+Include:
+  - ../shared/timer.yml
+  - ../shared/exits.yml
+`)},
+	}
+
+	plan, diags, err := LoadReadPlan(fsys, "smw/readplan.yml")
+	if err != nil {
+		t.Fatalf("load: %v %v", err, diags)
+	}
+
+	var names []string
+	for _, w := range plan.Watches {
+		names = append(names, w.Name)
+	}
+	if got := strings.Join(names, ","); got != "lives,coins,timer,exits" {
+		t.Errorf("watches %s, want lives,coins,timer,exits", got)
+	}
+}
+
+func TestLoadReadPlanMergesWatchesByName(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.yml": {Data: []byte(baseYAML)},
+		"smw/readplan.yml": {Data: []byte(`
+Extends: ../base.yml
+Watches:
+  - name: coins
+    address: 0x28
+  - name: timer
+    type: U16
+    address: 0x30
+`)},
+	}
+
+	plan, diags, err := LoadReadPlan(fsys, "smw/readplan.yml")
+	if err != nil {
+		t.Fatalf("load: %v %v", err, diags)
+	}
+
+	watches := watchesByName(plan)
+	if len(plan.Watches) != 3 {
+		t.Fatalf("watches %+v, want lives, coins and timer", plan.Watches)
+	}
+	if coins := watches["coins"]; coins.Type != U16 || coins.Address != 0x28 {
+		t.Errorf("coins %s at $%X, want the base's U16 at the override's $28", coins.Type, int(coins.Address))
+	}
+	if lives := watches["lives"]; lives.Address != 0x10 {
+		t.Errorf("lives at $%X, want the base's $10", int(lives.Address))
+	}
+	if timer := watches["timer"]; timer.Address != 0x30 {
+		t.Errorf("timer at $%X, want $30", int(timer.Address))
+	}
+}
+
+func TestLoadReadPlanIncludeCycle(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.yml": {Data: []byte("Extends: b.yml\n")},
+		"b.yml": {Data: []byte("Extends: a.yml\n")},
+	}
+
+	_, diags, err := LoadReadPlan(fsys, "a.yml")
+	if err == nil {
+		t.Fatalf("cyclic plan loaded")
+	}
+
+	found := false
+	for _, d := range diags {
+		if d.Message == "include cycle: a.yml -> b.yml -> a.yml" {
+			found = d.File == "b.yml" && d.Line == 1
+		}
+	}
+	if !found {
+		t.Errorf("diagnostics %+v, want the cycle reported at b.yml:1", diags)
+	}
+}
+
+func TestLoadReadPlanOutsideProviders(t *testing.T) {
+	for _, base := range []string{"../../base.yml", "/etc/base.yml"} {
+		fsys := fstest.MapFS{
+			"base.yml":         {Data: []byte(baseYAML)},
+			"smw/readplan.yml": {Data: []byte("Extends: " + base + "\n")},
+		}
+
+		_, diags, err := LoadReadPlan(fsys, "smw/readplan.yml")
+		if err == nil {
+			t.Errorf("%s: plan loaded", base)
+			continue
+		}
+		if len(diags) == 0 || !strings.Contains(diags[0].Message, "outside the providers folder") {
+			t.Errorf("%s: diagnostics %+v, want it rejected as outside the providers folder", base, diags)
+		}
+	}
+}
+
+func TestLoadReadPlanDiagnosticFiles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.yml": {Data: []byte(`
+Name: Base
+Platform: SNES
+ReadInterval: 16
+Watches:
+  - name: lives
+    type: U7
+    address: 0x10
+`)},
+		"smw/readplan.yml": {Data: []byte(`
+Extends: ../base.yml
+Watches:
+  - name: coins
+    type: U9
+    address: 0x20
+`)},
+	}
+
+	diags := ValidateReadPlanFS(fsys, "smw/readplan.yml")
+
+	want := map[string]Diagnostic{
+		`unknown type "U7"`: {File: "base.yml", Line: 7},
+		`unknown type "U9"`: {File: "smw/readplan.yml", Line: 5},
+	}
+	for _, d := range diags {
+		w, ok := want[d.Message]
+		if !ok {
+			continue
+		}
+		if d.File != w.File || d.Line != w.Line {
+			t.Errorf("%s reported at %s:%d, want %s:%d", d.Message, d.File, d.Line, w.File, w.Line)
+		}
+		delete(want, d.Message)
+	}
+	for msg := range want {
+		t.Errorf("diagnostics %+v, want %s", diags, msg)
+	}
+}
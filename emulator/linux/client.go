@@ -0,0 +1,515 @@
+package linux
+
+import (
+	"FactFinder/emulator"
+	"FactFinder/logger"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var log = logger.Module("emulator/linux/client").SetLevel(logger.InfoLevel)
+
+var capabilities = emulator.Capabilities{
+	MaxReadSize:  0x100000,
+	PreferredGap: 64,
+	Banks:        []emulator.Bank{emulator.ProcessMemory},
+	// a pread on /proc/<pid>/mem, a syscall rather than a network round
+	// trip, so only gaps within PreferredGap are read through. Nothing
+	// frames a pread, RegionCost is zero.
+	LatencyCost: 64,
+}
+
+// scanInterval is how often /proc is searched while the process is not
+// running, and the process while its signature is not found.
+const scanInterval = time.Second
+
+// scanChunk is how much memory a signature scan reads at once.
+const scanChunk = 1 << 20
+
+// scanBudget is how much memory a signature scan reads per tick, the scan
+// of a large process is spread over ticks rather than stall one.
+const scanBudget = 16 * scanChunk
+
+// maxScanBackoff is the longest wait before mappings a scan did not find
+// the signature in are scanned again.
+const maxScanBackoff = 30 * time.Second
+
+// Client reads the memory of a native, Wine or Proton process through
+// /proc/<pid>/mem. The process is found by the read plan's ProcessName when
+// values are first read, and found again when it exits or restarts.
+type Client struct {
+	m                 sync.Mutex
+	procRoot          string
+	emulatorConnected emulator.ConnectionStatus
+	gameConnected     bool
+
+	processName string
+	pid         int
+	// start time of the process, tells a restarted process from a reused pid
+	started  string
+	mem      *os.File
+	lastScan time.Time
+
+	// the plan's signature and the base it led to, found once per attach
+	signature   emulator.Signature
+	pattern     *emulator.Pattern
+	base        int
+	baseFound   bool
+	scan        *sigScan
+	lastSigScan time.Time
+	// mappings the last scan did not find the signature in, scanned again
+	// once retrySigScan passes, waiting longer after every failure
+	scannedMaps  []mapping
+	sigBackoff   time.Duration
+	retrySigScan time.Time
+
+	// load addresses of modules watches are relative to, by lower case name
+	modules        map[string]int
+	lastModuleScan time.Time
+}
+
+func NewClient() *Client {
+	return &Client{procRoot: "/proc"}
+}
+
+// SetReadPlan sets the plan whose ProcessName is attached to. A different
+// process name detaches from the current process, a different signature is
+// scanned for again.
+func (c *Client) SetReadPlan(plan *emulator.ReadPlan) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if plan.ProcessName != c.processName {
+		c.detach()
+		c.processName = plan.ProcessName
+		c.lastScan = time.Time{}
+	}
+
+	if plan.ProcessSignature != c.signature {
+		c.signature = plan.ProcessSignature
+		c.pattern = nil
+		c.resetBase()
+
+		if c.signature.Pattern != "" {
+			pattern, err := emulator.ParsePattern(c.signature.Pattern)
+			if err != nil {
+				log.Error("invalid signature for %s: %v", plan.Name, err)
+			}
+			c.pattern = pattern
+		}
+	}
+}
+
+// ConnectEmulator checks that process memory can be read at all, attaching
+// to the process waits for the read plan.
+func (c *Client) ConnectEmulator() emulator.ConnectionStatus {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if _, err := os.Stat(filepath.Join(c.procRoot, "self", "mem")); err != nil {
+		log.Error("process memory is not readable here: %v", err)
+		c.emulatorConnected = emulator.Disconnected
+		return emulator.Disconnected
+	}
+
+	c.emulatorConnected = emulator.Connected
+	return emulator.Connected
+}
+
+func (c *Client) EmulatorConnected() emulator.ConnectionStatus {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.emulatorConnected
+}
+
+func (c *Client) GameConnected() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.gameConnected
+}
+
+func (c *Client) Close() error {
+	log.Info("closing process memory reader")
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.emulatorConnected = emulator.Disconnected
+	return c.detach()
+}
+
+func (c *Client) Capabilities() emulator.Capabilities {
+	return capabilities
+}
+
+func (c *Client) CompileReadPlan(
+	plan *emulator.ReadPlan,
+) *emulator.CompiledReadPlan {
+	return emulator.CompileReadPlan(plan, capabilities, emulator.LinuxMem)
+}
+
+func (c *Client) GetValues(plan *emulator.CompiledReadPlan) ([]emulator.Value, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if err := c.attach(); err != nil {
+		return nil, err
+	}
+
+	if err := c.findBase(); err != nil {
+		return nil, err
+	}
+	plan.Rebase("", c.base)
+
+	if err := c.rebaseModules(plan); err != nil {
+		return nil, err
+	}
+
+	vals := plan.Values()
+
+	for i := range plan.Regions {
+		region := &plan.Regions[i]
+
+		if err := c.readMemory(region.Bank, region.Start, region.Buffer); err != nil {
+			return nil, c.readError(err)
+		}
+
+		vals = emulator.DecodeRegion(region, vals)
+	}
+
+	vals, err := plan.ResolvePointerChains(c.readMemory, vals)
+	if err != nil {
+		return nil, c.readError(err)
+	}
+
+	return vals, nil
+}
+
+// readMemory reads len(dst) bytes of the process. Reads of pages that are
+// not mapped fail with EIO or come up short, they return ErrAddressUnmapped
+// so a pointer chain through a freed object is skipped.
+func (c *Client) readMemory(_ emulator.Bank, addr int, dst []byte) error {
+	n, err := c.mem.ReadAt(dst, int64(addr))
+	if n == len(dst) {
+		return nil
+	}
+
+	if !c.running() {
+		return fmt.Errorf("%w: %s exited", emulator.ErrGameNotLoaded, c.processName)
+	}
+	return fmt.Errorf("%w: read 0x%x+%d: %v", emulator.ErrAddressUnmapped, addr, len(dst), err)
+}
+
+// running reports whether the attached process is still the one running.
+func (c *Client) running() bool {
+	started, _ := processStart(c.procRoot, c.pid)
+	return started == c.started
+}
+
+// readError tells a bad address from a process that went away. The
+// process is detached from in the latter case so the next read finds it
+// again.
+func (c *Client) readError(err error) error {
+	if c.running() {
+		return err
+	}
+
+	log.Info("process %s (pid %d) exited", c.processName, c.pid)
+	c.detach()
+	return fmt.Errorf("%w: %s exited", emulator.ErrGameNotLoaded, c.processName)
+}
+
+// attach opens the memory of the plan's process, searching /proc at most
+// once per scanInterval.
+func (c *Client) attach() error {
+	if c.mem != nil {
+		return nil
+	}
+
+	if c.processName == "" || time.Since(c.lastScan) < scanInterval {
+		return emulator.ErrGameNotLoaded
+	}
+	c.lastScan = time.Now()
+
+	pid, ok := findProcess(c.procRoot, c.processName)
+	if !ok {
+		log.Debug("process %s is not running", c.processName)
+		return emulator.ErrGameNotLoaded
+	}
+
+	started, err := processStart(c.procRoot, pid)
+	if err != nil {
+		return emulator.ErrGameNotLoaded
+	}
+
+	mem, err := os.Open(filepath.Join(c.procRoot, strconv.Itoa(pid), "mem"))
+	if err != nil {
+		if errors.Is(err, fs.ErrPermission) {
+			return fmt.Errorf("%w, reading another process needs kernel.yama.ptrace_scope=0 or CAP_SYS_PTRACE", err)
+		}
+		return err
+	}
+
+	c.pid = pid
+	c.started = started
+	c.mem = mem
+	c.gameConnected = true
+
+	log.Info("attached to %s (pid %d)", c.processName, pid)
+	return nil
+}
+
+func (c *Client) detach() error {
+	c.gameConnected = false
+	c.pid = 0
+	c.started = ""
+	c.modules = nil
+	c.lastModuleScan = time.Time{}
+	c.resetBase()
+
+	if c.mem == nil {
+		return nil
+	}
+
+	err := c.mem.Close()
+	c.mem = nil
+	return err
+}
+
+// findBase scans for the plan's signature once per attach, scanBudget
+// bytes per tick. While it is not found the game counts as not loaded, it
+// may still be starting up.
+func (c *Client) findBase() error {
+	if c.pattern == nil || c.baseFound {
+		return nil
+	}
+
+	if c.scan == nil {
+		if err := c.startScan(); err != nil {
+			return err
+		}
+	}
+
+	match, done := c.scan.step(c.readMemory, c.pattern, scanBudget)
+	if !done {
+		return emulator.ErrGameNotLoaded
+	}
+
+	scanned := c.scan.maps
+	c.scan = nil
+
+	base, err := 0, emulator.ErrSignatureNotFound
+	if match >= 0 {
+		base, err = c.signature.Resolve(match, c.readMemory)
+	}
+	if err != nil {
+		if slices.Equal(scanned, c.scannedMaps) {
+			c.sigBackoff = min(2*c.sigBackoff, maxScanBackoff)
+		} else {
+			c.sigBackoff = scanInterval
+		}
+		c.scannedMaps = scanned
+		c.retrySigScan = time.Now().Add(c.sigBackoff)
+
+		log.Warn("signature scan of %s failed, retrying in %s: %v", c.processName, c.sigBackoff, err)
+		return fmt.Errorf("%w: %v", emulator.ErrGameNotLoaded, err)
+	}
+
+	log.Info("signature of %s found, base 0x%x", c.processName, base)
+	c.base = base
+	c.baseFound = true
+	return nil
+}
+
+// startScan starts a signature scan of the process's mappings, at most once
+// per scanInterval. Mappings the last scan failed on are only scanned again
+// once its backoff has passed.
+func (c *Client) startScan() error {
+	if time.Since(c.lastSigScan) < scanInterval {
+		return emulator.ErrGameNotLoaded
+	}
+	c.lastSigScan = time.Now()
+
+	maps, err := readMappings(c.procRoot, c.pid)
+	if err != nil {
+		return c.readError(err)
+	}
+
+	if slices.Equal(maps, c.scannedMaps) && time.Now().Before(c.retrySigScan) {
+		return emulator.ErrGameNotLoaded
+	}
+
+	c.scan = newSigScan(maps, c.pattern)
+	return nil
+}
+
+// sigScan is a signature scan of a process's readable mappings, resumed
+// where it stopped.
+type sigScan struct {
+	maps []mapping
+	// mapping and address the next chunk is read from
+	next int
+	addr int
+	buf  []byte
+}
+
+func newSigScan(maps []mapping, pattern *emulator.Pattern) *sigScan {
+	// chunks overlap by the pattern length so matches across them are found
+	return &sigScan{maps: maps, buf: make([]byte, scanChunk+pattern.Len()-1)}
+}
+
+// step reads about budget bytes from where the last step stopped. It
+// returns the address of the match, -1 for none, and whether the scan is
+// done.
+func (s *sigScan) step(read emulator.MemoryFunc, pattern *emulator.Pattern, budget int) (int, bool) {
+	for ; s.next < len(s.maps); s.next, s.addr = s.next+1, 0 {
+		m := s.maps[s.next]
+		if !m.Readable() || m.Path == "[vvar]" || m.Path == "[vsyscall]" {
+			continue
+		}
+
+		for s.addr = max(s.addr, m.Start); s.addr < m.End; s.addr += scanChunk {
+			if budget <= 0 {
+				return -1, false
+			}
+
+			chunk := s.buf[:min(len(s.buf), m.End-s.addr)]
+			budget -= len(chunk)
+
+			// some mappings refuse reads, device memory and guard pages
+			if err := read(emulator.ProcessMemory, s.addr, chunk); err != nil {
+				break
+			}
+
+			if i := pattern.Index(chunk); i >= 0 {
+				return s.addr + i, true
+			}
+		}
+	}
+
+	return -1, true
+}
+
+// rebaseModules places the plan's module relative reads at the modules'
+// load addresses. Modules that are not loaded yet are looked for at most
+// once per scanInterval, until then the game counts as not loaded.
+func (c *Client) rebaseModules(plan *emulator.CompiledReadPlan) error {
+	for _, module := range plan.Modules() {
+		base, ok := c.modules[strings.ToLower(module)]
+		if !ok {
+			var err error
+			if base, err = c.findModule(module); err != nil {
+				return err
+			}
+		}
+
+		plan.Rebase(module, base)
+	}
+
+	return nil
+}
+
+func (c *Client) findModule(module string) (int, error) {
+	if time.Since(c.lastModuleScan) < scanInterval {
+		return 0, emulator.ErrGameNotLoaded
+	}
+	c.lastModuleScan = time.Now()
+
+	maps, err := readMappings(c.procRoot, c.pid)
+	if err != nil {
+		return 0, c.readError(err)
+	}
+
+	base, ok := moduleBase(maps, module)
+	if !ok {
+		log.Warn("module %s is not loaded in %s", module, c.processName)
+		return 0, fmt.Errorf("%w: module %s is not loaded", emulator.ErrGameNotLoaded, module)
+	}
+
+	log.Info("module %s of %s loaded at 0x%x", module, c.processName, base)
+
+	if c.modules == nil {
+		c.modules = make(map[string]int)
+	}
+	c.modules[strings.ToLower(module)] = base
+	return base, nil
+}
+
+func (c *Client) resetBase() {
+	c.base = 0
+	c.baseFound = false
+	c.scan = nil
+	c.lastSigScan = time.Time{}
+	c.scannedMaps = nil
+	c.sigBackoff = 0
+	c.retrySigScan = time.Time{}
+}
+
+// findProcess returns the first process whose name is name. The kernel
+// truncates comm to 15 bytes, and Wine names processes after the .exe, so
+// the base name of argv[0] is compared too.
+func findProcess(procRoot, name string) (int, bool) {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		log.Error("failed to list processes: %v", err)
+		return 0, false
+	}
+
+	self := os.Getpid()
+
+	for _, ent := range entries {
+		pid, err := strconv.Atoi(ent.Name())
+		if err != nil || pid == self {
+			continue
+		}
+
+		dir := filepath.Join(procRoot, ent.Name())
+
+		if comm, err := os.ReadFile(filepath.Join(dir, "comm")); err == nil {
+			comm := strings.TrimSpace(string(comm))
+			if strings.EqualFold(comm, name) ||
+				(len(comm) == 15 && len(name) > 15 && strings.EqualFold(comm, name[:15])) {
+				return pid, true
+			}
+		}
+
+		if cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline")); err == nil {
+			argv0, _, _ := strings.Cut(string(cmdline), "\x00")
+			argv0 = argv0[strings.LastIndexAny(argv0, `/\`)+1:]
+			if argv0 != "" && strings.EqualFold(argv0, name) {
+				return pid, true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// processStart returns the start time field of /proc/<pid>/stat.
+func processStart(procRoot string, pid int) (string, error) {
+	stat, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return "", err
+	}
+
+	// comm may contain spaces and parentheses, fields resume after the last )
+	i := strings.LastIndexByte(string(stat), ')')
+	if i < 0 {
+		return "", fmt.Errorf("malformed stat for pid %d", pid)
+	}
+
+	// state is field 3, starttime field 22
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 20 {
+		return "", fmt.Errorf("malformed stat for pid %d", pid)
+	}
+
+	return fields[19], nil
+}
@@ -0,0 +1,490 @@
+package linux
+
+import (
+	"FactFinder/emulator"
+	"FactFinder/emulator/emulatortest"
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// helperEnv has the test binary run as a helper process holding its value
+// in helperMemory, see startHelper.
+const helperEnv = "FACTFINDER_LINUX_HELPER"
+
+// helperName is the argv[0] helpers run under, so they are found by name.
+const helperName = "factfinder-helper"
+
+// helperMemory is read by the tests from the helper process.
+var helperMemory struct {
+	value uint32
+	// to value
+	pointer uintptr
+	// to memory that is not mapped, as an object the game freed
+	dangling uintptr
+}
+
+func TestMain(m *testing.M) {
+	if v := os.Getenv(helperEnv); v != "" {
+		runHelper(v)
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// runHelper stores value in helperMemory, prints its offset from the load
+// address of the executable and waits for stdin to close.
+func runHelper(value string) {
+	v, err := strconv.ParseUint(value, 0, 32)
+	if err != nil {
+		panic(err)
+	}
+	helperMemory.value = uint32(v)
+	helperMemory.pointer = uintptr(unsafe.Pointer(&helperMemory.value))
+	helperMemory.dangling = 0x10
+
+	exe, err := os.Executable()
+	if err != nil {
+		panic(err)
+	}
+	maps, err := readMappings("/proc", os.Getpid())
+	if err != nil {
+		panic(err)
+	}
+	base, ok := moduleBase(maps, filepath.Base(exe))
+	if !ok {
+		panic("executable is not mapped")
+	}
+
+	fmt.Printf("%x\n", int(uintptr(unsafe.Pointer(&helperMemory)))-base)
+	_, _ = io.Copy(io.Discard, os.Stdin)
+}
+
+// startHelper runs the test binary as a helper holding value and returns
+// it with the offset of helperMemory in its executable.
+func startHelper(t *testing.T, value uint32) (*exec.Cmd, int) {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0])
+	cmd.Args[0] = helperName
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%d", helperEnv, value))
+
+	// the helper exits when the test does
+	if _, err := cmd.StdinPipe(); err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { stopHelper(cmd) })
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("helper: %v", err)
+	}
+	offset, err := parseHex(line[:len(line)-1])
+	if err != nil {
+		t.Fatalf("helper printed %q: %v", line, err)
+	}
+
+	return cmd, offset
+}
+
+// stopHelper kills a helper and reaps it, a zombie still has a comm and
+// cmdline to be found by.
+func stopHelper(cmd *exec.Cmd) {
+	if cmd.ProcessState != nil {
+		return
+	}
+	_ = cmd.Process.Kill()
+	_ = cmd.Wait()
+}
+
+// fakeProcess writes a /proc/<pid> with maps and a sparse mem file holding
+// data at the given addresses.
+func fakeProcess(t *testing.T, pid int, maps string, data map[int][]byte) *Client {
+	t.Helper()
+
+	root := t.TempDir()
+	dir := filepath.Join(root, fmt.Sprint(pid))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "maps"), []byte(maps), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mem, err := os.Create(filepath.Join(dir, "mem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { mem.Close() })
+
+	for _, m := range mustMappings(t, root, pid) {
+		if err := mem.Truncate(int64(m.End)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for addr, b := range data {
+		if _, err := mem.WriteAt(b, int64(addr)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &Client{procRoot: root, pid: pid, mem: mem}
+}
+
+func mustMappings(t *testing.T, root string, pid int) []mapping {
+	t.Helper()
+	maps, err := readMappings(root, pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return maps
+}
+
+func setSignature(t *testing.T, c *Client, sig emulator.Signature) {
+	t.Helper()
+	pattern, err := emulator.ParsePattern(sig.Pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.signature, c.pattern = sig, pattern
+}
+
+func TestScanSignatureAcrossChunks(t *testing.T) {
+	const start = 0x10000
+	maps := fmt.Sprintf(
+		"%x-%x ---p 00000000 00:00 0\n%x-%x r--p 00000000 00:00 0  /game\n",
+		0x1000, 0x2000, start, start+2*scanChunk,
+	)
+
+	// the match begins 2 bytes before the first chunk ends
+	match := start + scanChunk - 2
+	c := fakeProcess(t, 100, maps, map[int][]byte{
+		0x1000:     {0xDE, 0xAD, 0x00, 0xEF},
+		start + 16: {0xDE, 0xAD, 0x00},
+		match:      {0xDE, 0xAD, 0x42, 0xEF},
+	})
+	setSignature(t, c, emulator.Signature{Pattern: "DE AD ?? EF", ScanOffset: 2, ResultOffset: 0x10})
+
+	if err := c.findBase(); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if want := match + 2 + 0x10; c.base != want {
+		t.Fatalf("base 0x%x, want 0x%x", c.base, want)
+	}
+}
+
+func TestScanSignatureSpreadOverTicks(t *testing.T) {
+	const start = 0x10000
+	maps := fmt.Sprintf("%x-%x r--p 00000000 00:00 0  /game\n", start, start+3*scanChunk)
+
+	match := start + 2*scanChunk + 0x10
+	c := fakeProcess(t, 100, maps, map[int][]byte{match: {0xDE, 0xAD, 0xBE, 0xEF}})
+	setSignature(t, c, emulator.Signature{Pattern: "DE AD BE EF"})
+
+	scan := newSigScan(mustMappings(t, c.procRoot, c.pid), c.pattern)
+	for tick := range 2 {
+		if _, done := scan.step(c.readMemory, c.pattern, scanChunk); done {
+			t.Fatalf("scan done on tick %d, past the budget of a chunk per tick", tick+1)
+		}
+	}
+
+	if at, done := scan.step(c.readMemory, c.pattern, scanChunk); !done || at != match {
+		t.Fatalf("third tick found 0x%x (done %v), want 0x%x", at, done, match)
+	}
+}
+
+func TestScanSignatureNotFound(t *testing.T) {
+	maps := fmt.Sprintf("%x-%x r--p 00000000 00:00 0\n", 0x10000, 0x10000+scanChunk)
+	c := fakeProcess(t, 100, maps, map[int][]byte{0x10000 + scanChunk - 2: {0xDE, 0xAD}})
+	setSignature(t, c, emulator.Signature{Pattern: "DE AD ?? EF"})
+
+	if err := c.findBase(); !errors.Is(err, emulator.ErrGameNotLoaded) || c.baseFound {
+		t.Fatalf("scan: %v, want ErrGameNotLoaded", err)
+	}
+}
+
+func TestScanSignatureBackoff(t *testing.T) {
+	game := fmt.Sprintf("%x-%x r--p 00000000 00:00 0  /game\n", 0x10000, 0x11000)
+	heap := fmt.Sprintf("%x-%x rw-p 00000000 00:00 0  [heap]\n", 0x20000, 0x21000)
+
+	// the signature is in memory the process has not mapped yet
+	heapMem := make([]byte, 0x1000)
+	copy(heapMem[0x100:], []byte{0xDE, 0xAD, 0xBE, 0xEF})
+	c := fakeProcess(t, 100, game, map[int][]byte{0x20000: heapMem})
+	setSignature(t, c, emulator.Signature{Pattern: "DE AD BE EF"})
+
+	// scan skips scanInterval and reports whether a scan ran
+	scan := func() bool {
+		c.lastSigScan = time.Time{}
+		retry := c.retrySigScan
+		_ = c.findBase()
+		return c.baseFound || c.retrySigScan != retry
+	}
+
+	if !scan() || c.sigBackoff != scanInterval {
+		t.Fatalf("first scan backed off %s, want %s", c.sigBackoff, scanInterval)
+	}
+	if scan() {
+		t.Fatalf("the same mappings scanned again before the backoff passed")
+	}
+
+	c.retrySigScan = time.Now()
+	if !scan() || c.sigBackoff != 2*scanInterval {
+		t.Fatalf("second scan of the same mappings backed off %s, want %s", c.sigBackoff, 2*scanInterval)
+	}
+
+	maps := filepath.Join(c.procRoot, strconv.Itoa(c.pid), "maps")
+	if err := os.WriteFile(maps, []byte(game+heap), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !scan() || !c.baseFound || c.base != 0x20100 {
+		t.Fatalf("new mappings not scanned before the backoff passed, base 0x%x found %v", c.base, c.baseFound)
+	}
+}
+
+// readUntil reads plan until it reads want or time runs out, attaching is
+// retried once per scanInterval.
+func readUntil(t *testing.T, c *Client, plan *emulator.CompiledReadPlan, want uint32) {
+	t.Helper()
+
+	deadline := time.Now().Add(3 * scanInterval)
+	for {
+		vals, err := c.GetValues(plan)
+		if err == nil && len(vals) == 1 && vals[0].Unsigned == uint64(want) {
+			return
+		}
+		if err != nil && !errors.Is(err, emulator.ErrGameNotLoaded) {
+			t.Fatalf("read: %v", err)
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("read %v, %v, want value 0x%X", vals, err, want)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestReadHelperProcess(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("reads /proc/<pid>/mem")
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	helper, offset := startHelper(t, 0x11223344)
+
+	plan := &emulator.ReadPlan{
+		Name:        "helper",
+		ProcessName: helperName,
+		Watches: []emulator.ReadSpec{{
+			Name:    "value",
+			Type:    emulator.U32,
+			Bank:    emulator.ProcessMemory,
+			Module:  filepath.Base(exe),
+			Address: emulator.HexInt(offset),
+		}},
+	}
+
+	c := NewClient()
+	t.Cleanup(func() { c.Close() })
+	if c.ConnectEmulator() != emulator.Connected {
+		t.Skip("process memory is not readable")
+	}
+	c.SetReadPlan(plan)
+	compiled := c.CompileReadPlan(plan)
+
+	readUntil(t, c, compiled, 0x11223344)
+	if c.pid != helper.Process.Pid {
+		t.Fatalf("attached to pid %d, want the helper %d", c.pid, helper.Process.Pid)
+	}
+
+	stopHelper(helper)
+	if _, err := c.GetValues(compiled); !errors.Is(err, emulator.ErrGameNotLoaded) {
+		t.Fatalf("read after the helper exited: %v, want ErrGameNotLoaded", err)
+	}
+	if c.GameConnected() {
+		t.Fatalf("still attached after the helper exited")
+	}
+
+	restarted, _ := startHelper(t, 0x55667788)
+	readUntil(t, c, compiled, 0x55667788)
+	if c.pid != restarted.Process.Pid {
+		t.Fatalf("attached to pid %d, want the restarted helper %d", c.pid, restarted.Process.Pid)
+	}
+}
+
+func TestReadHelperDanglingPointer(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("reads /proc/<pid>/mem")
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, offset := startHelper(t, 0x11223344)
+	module := filepath.Base(exe)
+
+	plan := &emulator.ReadPlan{
+		Name:        "helper",
+		ProcessName: helperName,
+		Watches: []emulator.ReadSpec{
+			{
+				Name:    "dangling",
+				Type:    emulator.U32,
+				Bank:    emulator.ProcessMemory,
+				Module:  module,
+				Address: emulator.HexInt(offset + int(unsafe.Offsetof(helperMemory.dangling))),
+				Offsets: []emulator.HexInt{0},
+			},
+			{
+				Name:    "pointer",
+				Type:    emulator.U32,
+				Bank:    emulator.ProcessMemory,
+				Module:  module,
+				Address: emulator.HexInt(offset + int(unsafe.Offsetof(helperMemory.pointer))),
+				Offsets: []emulator.HexInt{0},
+			},
+			{
+				Name:    "value",
+				Type:    emulator.U32,
+				Bank:    emulator.ProcessMemory,
+				Module:  module,
+				Address: emulator.HexInt(offset),
+			},
+		},
+	}
+
+	c := NewClient()
+	t.Cleanup(func() { c.Close() })
+	if c.ConnectEmulator() != emulator.Connected {
+		t.Skip("process memory is not readable")
+	}
+	c.SetReadPlan(plan)
+	compiled := c.CompileReadPlan(plan)
+
+	vals, err := c.GetValues(compiled)
+	if err != nil {
+		t.Fatalf("read with a dangling pointer: %v", err)
+	}
+
+	got := make(map[string]uint64)
+	for _, v := range vals {
+		got[v.Name] = v.Unsigned
+	}
+	if _, ok := got["dangling"]; ok || len(got) != 2 || got["pointer"] != 0x11223344 || got["value"] != 0x11223344 {
+		t.Fatalf("read %v, want pointer and value 0x11223344 without dangling", got)
+	}
+}
+
+// valuesByName returns the unsigned values read, by name.
+func valuesByName(vals []emulator.Value) map[string]uint64 {
+	out := make(map[string]uint64, len(vals))
+	for _, v := range vals {
+		out[v.Name] = v.Unsigned
+	}
+	return out
+}
+
+func TestSignatureChainThroughFreedObject(t *testing.T) {
+	maps := fmt.Sprintf("%x-%x r--p 00000000 00:00 0  /game\n", 0x10000, 0x20000)
+
+	// the object the base points at was freed, its page is no longer mapped
+	freed := make([]byte, 8)
+	binary.LittleEndian.PutUint64(freed, 0x50000)
+
+	c := fakeProcess(t, 100, maps, map[int][]byte{
+		0x10100: {0xDE, 0xAD, 0xBE, 0xEF},
+		0x10110: freed,
+		0x10120: {0x2A},
+	})
+	setSignature(t, c, emulator.Signature{Pattern: "DE AD BE EF"})
+
+	plan := c.CompileReadPlan(&emulator.ReadPlan{
+		Name: "game",
+		Watches: []emulator.ReadSpec{
+			{Name: "player", Type: emulator.U32, Bank: emulator.ProcessMemory, Address: 0x10, Offsets: []emulator.HexInt{0x8}},
+			{Name: "lives", Type: emulator.U8, Bank: emulator.ProcessMemory, Address: 0x20},
+		},
+	})
+
+	vals, err := c.GetValues(plan)
+	if err != nil {
+		t.Fatalf("read through a freed object: %v", err)
+	}
+	if got := valuesByName(vals); len(got) != 1 || got["lives"] != 0x2A {
+		t.Fatalf("read %v, want lives=0x2A only", got)
+	}
+}
+
+func TestModuleChainIntoUnloadedModule(t *testing.T) {
+	// plugin.dll was loaded at 0x70000 and has been unloaded
+	maps := fmt.Sprintf("%x-%x r--p 00000000 00:00 0  C:\\game\\game.dll\n", 0x30000, 0x31000)
+
+	stale := make([]byte, 8)
+	binary.LittleEndian.PutUint64(stale, 0x70000)
+
+	c := fakeProcess(t, 100, maps, map[int][]byte{
+		0x30010: stale,
+		0x30020: {0x2A},
+	})
+
+	plan := c.CompileReadPlan(&emulator.ReadPlan{
+		Name: "game",
+		Watches: []emulator.ReadSpec{
+			{Name: "plugin", Type: emulator.U32, Bank: emulator.ProcessMemory, Module: "game.dll", Address: 0x10, Offsets: []emulator.HexInt{0x4}},
+			{Name: "lives", Type: emulator.U8, Bank: emulator.ProcessMemory, Module: "game.dll", Address: 0x20},
+		},
+	})
+
+	vals, err := c.GetValues(plan)
+	if err != nil {
+		t.Fatalf("read through an unloaded module: %v", err)
+	}
+	if got := valuesByName(vals); len(got) != 1 || got["lives"] != 0x2A {
+		t.Fatalf("read %v, want lives=0x2A only", got)
+	}
+}
+
+func TestByteOrder(t *testing.T) {
+	maps := fmt.Sprintf("%x-%x r--p 00000000 00:00 0  C:\\game\\game.dll\n", 0x30000, 0x31000)
+
+	plan := emulatortest.ByteOrderPlan("", emulator.ProcessMemory)
+	for i := range plan.Watches {
+		plan.Watches[i].Module = "game.dll"
+	}
+	c := fakeProcess(t, 100, maps, map[int][]byte{0x30000: emulatortest.ByteOrderMemory(plan, 0x100, false)})
+
+	caps := c.Capabilities()
+	for _, w := range plan.Watches {
+		if got, want := caps.ByteOrder(plan, w), emulator.WatchEndian(plan, w); got != want {
+			t.Errorf("%s comes back %s, want %s", w.Name, got, want)
+		}
+	}
+
+	vals, err := c.GetValues(c.CompileReadPlan(plan))
+	if err != nil {
+		t.Fatal(err)
+	}
+	emulatortest.CheckByteOrder(t, vals)
+}
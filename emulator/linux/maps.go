@@ -0,0 +1,88 @@
+package linux
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// mapping is one line of /proc/<pid>/maps.
+type mapping struct {
+	Start  int
+	End    int
+	Perms  string
+	Offset int
+	Path   string
+}
+
+func (m mapping) Readable() bool {
+	return strings.HasPrefix(m.Perms, "r")
+}
+
+// readMappings parses /proc/<pid>/maps, lines look like
+// 55d4c5a00000-55d4c5a21000 r--p 00000000 103:02 1835123  /usr/bin/game
+func readMappings(procRoot string, pid int) ([]mapping, error) {
+	f, err := os.Open(filepath.Join(procRoot, strconv.Itoa(pid), "maps"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []mapping
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			return nil, fmt.Errorf("malformed maps line %q", line)
+		}
+
+		start, end, _ := strings.Cut(fields[0], "-")
+		m := mapping{Perms: fields[1]}
+
+		var errs [3]error
+		m.Start, errs[0] = parseHex(start)
+		m.End, errs[1] = parseHex(end)
+		m.Offset, errs[2] = parseHex(fields[2])
+		for _, err := range errs {
+			if err != nil {
+				return nil, fmt.Errorf("malformed maps line %q: %w", line, err)
+			}
+		}
+
+		// the path is the rest of the line and may contain spaces
+		if len(fields) > 5 {
+			m.Path = strings.TrimSpace(line[strings.Index(line, fields[5]):])
+		}
+
+		out = append(out, m)
+	}
+
+	return out, scanner.Err()
+}
+
+func parseHex(s string) (int, error) {
+	v, err := strconv.ParseUint(s, 16, 64)
+	return int(v), err
+}
+
+// moduleBase returns the load address of module, the start of the first
+// mapping of a file of that name at offset 0. Wine and Proton map PE images
+// from their .exe and .dll files, so those are found the same way.
+func moduleBase(maps []mapping, module string) (int, bool) {
+	for _, m := range maps {
+		path := strings.TrimSuffix(m.Path, " (deleted)")
+		name := path[strings.LastIndexAny(path, `/\`)+1:]
+
+		if m.Offset == 0 && name != "" && strings.EqualFold(name, module) {
+			return m.Start, true
+		}
+	}
+
+	return 0, false
+}
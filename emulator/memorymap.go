@@ -0,0 +1,442 @@
+package emulator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Backend names how a client addresses memory. A profile of a backend,
+// "retroarch/melonds", translates like its backend except in the banks that
+// list it.
+type Backend string
+
+const (
+	RetroArch Backend = "retroarch"
+	NWA       Backend = "nwa"
+	USB2SNES  Backend = "qusb2snes"
+	LinuxMem  Backend = "linuxmem"
+
+	RetroArchMelonDS Backend = "retroarch/melonds"
+	// the rcheevos address space, read by RetroArch READ_MEMORY for cores
+	// without memory maps
+	RCheevos Backend = "rcheevos"
+)
+
+// Translation turns a watch address into the address a backend reads.
+// Address = Base + (watch address - BankMap.Start), unless Map is set.
+// Domain names the memory a backend reads by domain (NWA).
+type Translation struct {
+	Base   int
+	Domain string
+	Map    func(plan *ReadPlan, offset int) int
+}
+
+// BankMap is one bank of a platform. Watch addresses are valid from Start
+// up to Start+Size.
+type BankMap struct {
+	Bank     Bank
+	Start    int
+	Size     int
+	Backends map[Backend]Translation
+}
+
+type PlatformMap struct {
+	Name        string
+	DefaultBank Bank
+	Endian      Endian
+	Banks       []BankMap
+	// Bus maps a system bus or virtual address to a bank address
+	Bus func(plan *ReadPlan, addr uint64) (Bank, int, bool)
+}
+
+// MemoryMaps is the registry of every platform FactFinder can read, in the
+// order they are listed to users.
+var MemoryMaps = []PlatformMap{
+	{
+		Name:        "SNES",
+		DefaultBank: WRAM,
+		Endian:      LittleEndian,
+		Bus:         snesBus,
+		Banks: []BankMap{
+			{WRAM, 0, 0x20000, map[Backend]Translation{
+				RetroArch: {Base: 0x7E0000},
+				RCheevos:  {Base: 0},
+				NWA:       {Domain: "WRAM"},
+				USB2SNES:  {Base: 0xF50000},
+			}},
+			// 128KB, the most either mapping can address
+			{SRAM, 0, 0x20000, map[Backend]Translation{
+				RetroArch: {Map: snesSRAMBus},
+				RCheevos:  {Base: 0x20000},
+				NWA:       {Domain: "SRAM"},
+				USB2SNES:  {Base: 0xE00000},
+			}},
+			{ROM, 0, 0x400000, map[Backend]Translation{
+				RetroArch: {Map: snesROMBus},
+				NWA:       {Domain: "CARTROM"},
+				USB2SNES:  {Base: 0},
+			}},
+			// SA-1 $00:3000-37FF
+			{IRAM, 0, 0x800, map[Backend]Translation{
+				RetroArch: {Base: 0x3000},
+			}},
+			// SA-1 $40-$43, the cartridge's battery RAM
+			{BWRAM, 0, 0x40000, map[Backend]Translation{
+				RetroArch: {Base: 0x400000},
+				NWA:       {Domain: "SRAM"},
+				USB2SNES:  {Base: 0xE00000},
+			}},
+			// PPU memory, not on the bus and not exposed by libretro cores
+			{VRAM, 0, 0x10000, map[Backend]Translation{
+				NWA:      {Domain: "VRAM"},
+				USB2SNES: {Base: 0xF70000},
+			}},
+			{OAM, 0, 0x220, map[Backend]Translation{
+				NWA:      {Domain: "OAM"},
+				USB2SNES: {Base: 0xF90200},
+			}},
+		},
+	},
+	{
+		Name:        "GB",
+		DefaultBank: WRAM,
+		Endian:      LittleEndian,
+		Bus:         gbBus,
+		Banks:       gbBanks,
+	},
+	{
+		Name:        "GBC",
+		DefaultBank: WRAM,
+		Endian:      LittleEndian,
+		Bus:         gbBus,
+		Banks:       gbBanks,
+	},
+	{
+		Name:        "PSX",
+		DefaultBank: RAM,
+		Endian:      LittleEndian,
+		Bus:         psxBus,
+		Banks: []BankMap{
+			{RAM, 0, 0x200000, map[Backend]Translation{
+				RetroArch: {Base: 0x010000},
+				RCheevos:  {Base: 0},
+				NWA:       {Domain: "RAM"},
+			}},
+		},
+	},
+	{
+		Name:        "NES",
+		DefaultBank: RAM,
+		Endian:      LittleEndian,
+		Bus:         nesBus,
+		Banks: []BankMap{
+			{RAM, 0, 0x800, map[Backend]Translation{
+				RetroArch: {Base: 0},
+				RCheevos:  {Base: 0},
+				NWA:       {Domain: "RAM"},
+			}},
+			{PRGRAM, 0x6000, 0x2000, map[Backend]Translation{
+				RetroArch: {Base: 0x6000},
+				RCheevos:  {Base: 0x6000},
+				NWA:       {Domain: "SRAM"},
+			}},
+		},
+	},
+	{
+		Name:        "Genesis",
+		DefaultBank: RAM,
+		Endian:      BigEndian,
+		Bus:         genesisBus,
+		Banks: []BankMap{
+			{RAM, 0xFF0000, 0x10000, map[Backend]Translation{
+				RetroArch: {Base: 0xFF0000},
+				RCheevos:  {Base: 0},
+				NWA:       {Domain: "RAM"},
+			}},
+			{ROM, 0, 0x400000, map[Backend]Translation{
+				RetroArch: {Base: 0},
+				NWA:       {Domain: "CARTROM"},
+			}},
+		},
+	},
+	{
+		Name:        "GBA",
+		DefaultBank: IWRAM,
+		Endian:      LittleEndian,
+		Bus:         gbaBus,
+		Banks: []BankMap{
+			{IWRAM, 0, 0x8000, map[Backend]Translation{
+				RetroArch: {Base: 0x19000},
+				RCheevos:  {Base: 0},
+				NWA:       {Domain: "IWRAM"},
+			}},
+			{EWRAM, 0, 0x40000, map[Backend]Translation{
+				RetroArch: {Base: 0x21000},
+				RCheevos:  {Base: 0x8000},
+				NWA:       {Domain: "EWRAM"},
+			}},
+			{ROM, 0, 0x2000000, map[Backend]Translation{
+				RetroArch: {Base: 0x08000000},
+				NWA:       {Domain: "CARTROM"},
+			}},
+			{VRAM, 0, 0x18000, map[Backend]Translation{
+				RetroArch: {Base: 0x06000000},
+				NWA:       {Domain: "VRAM"},
+			}},
+			{OAM, 0, 0x400, map[Backend]Translation{
+				RetroArch: {Base: 0x07000000},
+				NWA:       {Domain: "OAM"},
+			}},
+		},
+	},
+	{
+		Name:        "3DS",
+		DefaultBank: FCRAM,
+		Endian:      LittleEndian,
+		Bus:         ctrBus,
+		Banks: []BankMap{
+			{FCRAM, 0, 0x8000000, map[Backend]Translation{
+				RetroArch: {Base: 0x20000000},
+				NWA:       {Domain: "FCRAM"},
+			}},
+		},
+	},
+	{
+		Name:        "DS",
+		DefaultBank: PSRAM,
+		Endian:      LittleEndian,
+		Bus:         dsBus,
+		Banks: []BankMap{
+			// DeSmuME 0x02000000, melonDS 0x00000000
+			{PSRAM, 0, 0x400000, map[Backend]Translation{
+				RetroArch:        {Base: 0x02000000},
+				RetroArchMelonDS: {Base: 0},
+				RCheevos:         {Base: 0},
+				NWA:              {Domain: "PSRAM"},
+			}},
+			{ROM, 0, 0x20000000, map[Backend]Translation{
+				NWA: {Domain: "CARTROM"},
+			}},
+		},
+	},
+	{
+		Name:        "N64",
+		DefaultBank: RDRAM,
+		Endian:      BigEndian,
+		Bus:         n64Bus,
+		Banks: []BankMap{
+			// 0x400000 without the expansion pak
+			{RDRAM, 0, 0x800000, map[Backend]Translation{
+				RetroArch: {Base: 0},
+				RCheevos:  {Base: 0},
+				NWA:       {Domain: "RDRAM"},
+			}},
+			{ROM, 0, 0x4000000, map[Backend]Translation{
+				RetroArch: {Base: 0x10000000},
+				NWA:       {Domain: "CARTROM"},
+			}},
+		},
+	},
+	{
+		Name:        "SMS",
+		DefaultBank: RAM,
+		Endian:      LittleEndian,
+		Bus:         smsBus,
+		Banks:       smsBanks,
+	},
+	{
+		Name:        "GG",
+		DefaultBank: RAM,
+		Endian:      LittleEndian,
+		Bus:         smsBus,
+		Banks:       smsBanks,
+	},
+	{
+		Name:        "PCE",
+		DefaultBank: RAM,
+		Endian:      LittleEndian,
+		Bus:         pceBus,
+		Banks: []BankMap{
+			// physical $1F0000, logical $2000 through MPR1
+			{RAM, 0, 0x2000, map[Backend]Translation{
+				RetroArch: {Base: 0x1F0000},
+				RCheevos:  {Base: 0},
+				NWA:       {Domain: "RAM"},
+			}},
+		},
+	},
+	{
+		Name:        "Saturn",
+		DefaultBank: HWRAM,
+		Endian:      BigEndian,
+		Bus:         saturnBus,
+		Banks: []BankMap{
+			{HWRAM, 0, 0x100000, map[Backend]Translation{
+				RetroArch: {Base: 0x06000000},
+				RCheevos:  {Base: 0x100000},
+				NWA:       {Domain: "HWRAM"},
+			}},
+			{LWRAM, 0, 0x100000, map[Backend]Translation{
+				RetroArch: {Base: 0x00200000},
+				RCheevos:  {Base: 0},
+				NWA:       {Domain: "LWRAM"},
+			}},
+		},
+	},
+	{
+		Name:        "NGP",
+		DefaultBank: RAM,
+		Endian:      LittleEndian,
+		Bus:         ngpBus,
+		Banks: []BankMap{
+			// work RAM $4000-$6FFF, $7000-$7FFF is shared with the Z80
+			{RAM, 0x4000, 0x4000, map[Backend]Translation{
+				RetroArch: {Base: 0x4000},
+				RCheevos:  {Base: 0},
+				NWA:       {Domain: "RAM"},
+			}},
+		},
+	},
+	{
+		Name:        "A2600",
+		DefaultBank: RAM,
+		Endian:      LittleEndian,
+		Bus:         a2600Bus,
+		Banks: []BankMap{
+			// the RIOT's 128 bytes at $80-$FF
+			{RAM, 0x80, 0x80, map[Backend]Translation{
+				RetroArch: {Base: 0x80},
+				RCheevos:  {Base: 0},
+				NWA:       {Domain: "RAM"},
+			}},
+		},
+	},
+}
+
+var gbBanks = []BankMap{
+	// addressed as on the bus like every other GB bank, so addresses copied
+	// from a debugger need no bank, see bankRelative for $0000-$1FFF
+	{WRAM, 0xC000, 0x2000, map[Backend]Translation{
+		RetroArch: {Base: 0xC000},
+		RCheevos:  {Base: 0xC000},
+		NWA:       {Domain: "WRAM"},
+	}},
+	// bank 0 and the switchable bank as mapped at the time
+	{ROM, 0, 0x8000, map[Backend]Translation{
+		RetroArch: {Base: 0},
+		NWA:       {Domain: "CARTROM"},
+	}},
+	{HRAM, 0xFF80, 0x7F, map[Backend]Translation{
+		RetroArch: {Base: 0xFF80},
+		RCheevos:  {Base: 0xFF80},
+		NWA:       {Domain: "HRAM"},
+	}},
+	// the cartridge RAM bank mapped at the time
+	{CartRAM, 0xA000, 0x2000, map[Backend]Translation{
+		RetroArch: {Base: 0xA000},
+		RCheevos:  {Base: 0xA000},
+		NWA:       {Domain: "SRAM"},
+	}},
+	{VRAM, 0x8000, 0x2000, map[Backend]Translation{
+		RetroArch: {Base: 0x8000},
+		RCheevos:  {Base: 0x8000},
+		NWA:       {Domain: "VRAM"},
+	}},
+	{OAM, 0xFE00, 0xA0, map[Backend]Translation{
+		RetroArch: {Base: 0xFE00},
+		RCheevos:  {Base: 0xFE00},
+		NWA:       {Domain: "OAM"},
+	}},
+}
+
+// SMS and Game Gear RAM $C000-$DFFF
+var smsBanks = []BankMap{
+	{RAM, 0xC000, 0x2000, map[Backend]Translation{
+		RetroArch: {Base: 0xC000},
+		RCheevos:  {Base: 0},
+		NWA:       {Domain: "RAM"},
+	}},
+}
+
+// LookupPlatform returns the memory map of a platform.
+func LookupPlatform(name string) (*PlatformMap, bool) {
+	for i := range MemoryMaps {
+		if MemoryMaps[i].Name == name {
+			return &MemoryMaps[i], true
+		}
+	}
+	return nil, false
+}
+
+// LookupBank returns the map of one bank of a platform.
+func LookupBank(platform string, bank Bank) (*BankMap, bool) {
+	p, ok := LookupPlatform(platform)
+	if !ok {
+		return nil, false
+	}
+
+	for i := range p.Banks {
+		if p.Banks[i].Bank == bank {
+			return &p.Banks[i], true
+		}
+	}
+	return nil, false
+}
+
+// Contains reports whether size bytes at addr lie inside the bank.
+func (b *BankMap) Contains(addr, size int) bool {
+	return addr >= b.Start && addr+max(size, 1) <= b.Start+b.Size
+}
+
+// Translate returns the address and domain a backend reads a watch at.
+func Translate(plan *ReadPlan, backend Backend, spec ReadSpec) (int, string, error) {
+	if spec.Bank == ProcessMemory {
+		// read at the virtual address, by backends that list the bank
+		return int(spec.Address), "", nil
+	}
+
+	bank, ok := LookupBank(plan.Platform, spec.Bank)
+	if !ok {
+		return 0, "", fmt.Errorf("%s has no %s bank", plan.Platform, spec.Bank)
+	}
+
+	t, ok := bank.Backends[backend]
+	if parent, _, isProfile := strings.Cut(string(backend), "/"); !ok && isProfile {
+		t, ok = bank.Backends[Backend(parent)]
+	}
+	if !ok {
+		return 0, "", fmt.Errorf("%s %s is not readable through %s", plan.Platform, spec.Bank, backend)
+	}
+
+	offset := int(spec.Address) - bank.Start
+	if t.Map != nil {
+		return t.Map(plan, offset), t.Domain, nil
+	}
+
+	return t.Base + offset, t.Domain, nil
+}
+
+// snesSRAMBus maps an SRAM offset onto the SNES bus.
+// LoROM $70-$7D:0000-7FFF, 32KB per bank
+// HiROM $30-$3F:6000-7FFF, 8KB per bank
+// HiROM SRAM past its first 8KB continues at $31:6000. Stepping banks every
+// $A000 bytes, as once done, read offsets $2000-$9FFF from $30:8000-FFFF,
+// which is ROM.
+func snesSRAMBus(plan *ReadPlan, offset int) int {
+	if plan.HiROM {
+		return 0x306000 + offset%0x2000 + (offset/0x2000)*0x10000
+	}
+
+	return 0x700000 + offset%0x8000 + (offset/0x8000)*0x10000
+}
+
+// snesROMBus maps a ROM file offset onto the SNES bus.
+// LoROM $00-$7D:8000-FFFF, 32KB per bank
+// HiROM $C0-$FF:0000-FFFF
+func snesROMBus(plan *ReadPlan, offset int) int {
+	if plan.HiROM {
+		return 0xC00000 + offset
+	}
+
+	return (offset/0x8000)<<16 | 0x8000 | offset%0x8000
+}
@@ -0,0 +1,349 @@
+package emulator_test
+
+import (
+	"FactFinder/emulator"
+	"FactFinder/emulator/nwa"
+	"FactFinder/emulator/qusb2snes"
+	"FactFinder/emulator/retroarch"
+	"fmt"
+	"testing"
+)
+
+// at is where a backend reads a bank address.
+type at struct {
+	addr   int
+	domain string
+}
+
+// noBus marks banks that are not on the system bus.
+const noBus = -1
+
+// memoryMapTests are known bus, bank and backend addresses of one byte of
+// every bank. The bus address normalizes to the bank address, which
+// translates to each backend's address.
+var memoryMapTests = []struct {
+	platforms []string
+	hiROM     bool
+	bus       int
+	bank      emulator.Bank
+	addr      int
+	backends  map[emulator.Backend]at
+}{
+	{[]string{"SNES"}, false, 0x7E0100, emulator.WRAM, 0x100, map[emulator.Backend]at{
+		emulator.RetroArch:        {0x7E0100, ""},
+		emulator.RetroArchMelonDS: {0x7E0100, ""},
+		emulator.RCheevos:         {0x100, ""},
+		emulator.NWA:              {0x100, "WRAM"},
+		emulator.USB2SNES:         {0xF50100, ""},
+	}},
+	{[]string{"SNES"}, false, 0x000100, emulator.WRAM, 0x100, map[emulator.Backend]at{
+		emulator.USB2SNES: {0xF50100, ""},
+	}},
+	{[]string{"SNES"}, false, 0x700010, emulator.SRAM, 0x10, map[emulator.Backend]at{
+		emulator.RetroArch: {0x700010, ""},
+		emulator.RCheevos:  {0x20010, ""},
+		emulator.NWA:       {0x10, "SRAM"},
+		emulator.USB2SNES:  {0xE00010, ""},
+	}},
+	{[]string{"SNES"}, false, 0x710000, emulator.SRAM, 0x8000, map[emulator.Backend]at{
+		emulator.RetroArch: {0x710000, ""},
+		emulator.USB2SNES:  {0xE08000, ""},
+	}},
+	{[]string{"SNES"}, true, 0x306010, emulator.SRAM, 0x10, map[emulator.Backend]at{
+		emulator.RetroArch: {0x306010, ""},
+		emulator.USB2SNES:  {0xE00010, ""},
+	}},
+	{[]string{"SNES"}, true, 0x307FFF, emulator.SRAM, 0x1FFF, map[emulator.Backend]at{
+		emulator.RetroArch: {0x307FFF, ""},
+	}},
+	{[]string{"SNES"}, true, 0x316000, emulator.SRAM, 0x2000, map[emulator.Backend]at{
+		emulator.RetroArch: {0x316000, ""},
+	}},
+	{[]string{"SNES"}, false, 0x818010, emulator.ROM, 0x8010, map[emulator.Backend]at{
+		emulator.RetroArch: {0x018010, ""},
+		emulator.NWA:       {0x8010, "CARTROM"},
+		emulator.USB2SNES:  {0x8010, ""},
+	}},
+	{[]string{"SNES"}, true, 0xC10010, emulator.ROM, 0x10010, map[emulator.Backend]at{
+		emulator.RetroArch: {0xC10010, ""},
+		emulator.USB2SNES:  {0x10010, ""},
+	}},
+	// on the bus of SA-1 plans only, see TestSNESBus
+	{[]string{"SNES"}, false, noBus, emulator.IRAM, 0x10, map[emulator.Backend]at{
+		emulator.RetroArch: {0x3010, ""},
+	}},
+	{[]string{"SNES"}, false, noBus, emulator.BWRAM, 0x10, map[emulator.Backend]at{
+		emulator.RetroArch: {0x400010, ""},
+		emulator.NWA:       {0x10, "SRAM"},
+		emulator.USB2SNES:  {0xE00010, ""},
+	}},
+	{[]string{"SNES"}, false, noBus, emulator.VRAM, 0x10, map[emulator.Backend]at{
+		emulator.NWA:      {0x10, "VRAM"},
+		emulator.USB2SNES: {0xF70010, ""},
+	}},
+	{[]string{"SNES"}, false, noBus, emulator.OAM, 0x10, map[emulator.Backend]at{
+		emulator.NWA:      {0x10, "OAM"},
+		emulator.USB2SNES: {0xF90210, ""},
+	}},
+
+	{[]string{"GB", "GBC"}, false, 0xE010, emulator.WRAM, 0xC010, map[emulator.Backend]at{
+		emulator.RetroArch: {0xC010, ""},
+		emulator.RCheevos:  {0xC010, ""},
+		emulator.NWA:       {0x10, "WRAM"},
+	}},
+	{[]string{"GB", "GBC"}, false, 0x0150, emulator.ROM, 0x150, map[emulator.Backend]at{
+		emulator.RetroArch: {0x150, ""},
+		emulator.NWA:       {0x150, "CARTROM"},
+	}},
+	{[]string{"GB", "GBC"}, false, 0xFF90, emulator.HRAM, 0xFF90, map[emulator.Backend]at{
+		emulator.RetroArch: {0xFF90, ""},
+		emulator.RCheevos:  {0xFF90, ""},
+		emulator.NWA:       {0x10, "HRAM"},
+	}},
+	{[]string{"GB", "GBC"}, false, 0xA010, emulator.CartRAM, 0xA010, map[emulator.Backend]at{
+		emulator.RetroArch: {0xA010, ""},
+		emulator.RCheevos:  {0xA010, ""},
+		emulator.NWA:       {0x10, "SRAM"},
+	}},
+	{[]string{"GB", "GBC"}, false, 0x8010, emulator.VRAM, 0x8010, map[emulator.Backend]at{
+		emulator.RetroArch: {0x8010, ""},
+		emulator.RCheevos:  {0x8010, ""},
+		emulator.NWA:       {0x10, "VRAM"},
+	}},
+	{[]string{"GB", "GBC"}, false, 0xFE10, emulator.OAM, 0xFE10, map[emulator.Backend]at{
+		emulator.RetroArch: {0xFE10, ""},
+		emulator.RCheevos:  {0xFE10, ""},
+		emulator.NWA:       {0x10, "OAM"},
+	}},
+
+	{[]string{"PSX"}, false, 0x80010000, emulator.RAM, 0x10000, map[emulator.Backend]at{
+		emulator.RetroArch: {0x20000, ""},
+		emulator.RCheevos:  {0x10000, ""},
+		emulator.NWA:       {0x10000, "RAM"},
+	}},
+
+	{[]string{"NES"}, false, 0x0810, emulator.RAM, 0x10, map[emulator.Backend]at{
+		emulator.RetroArch: {0x10, ""},
+		emulator.RCheevos:  {0x10, ""},
+		emulator.NWA:       {0x10, "RAM"},
+	}},
+	{[]string{"NES"}, false, 0x6010, emulator.PRGRAM, 0x6010, map[emulator.Backend]at{
+		emulator.RetroArch: {0x6010, ""},
+		emulator.RCheevos:  {0x6010, ""},
+		emulator.NWA:       {0x10, "SRAM"},
+	}},
+
+	{[]string{"Genesis"}, false, 0xE00010, emulator.RAM, 0xFF0010, map[emulator.Backend]at{
+		emulator.RetroArch: {0xFF0010, ""},
+		emulator.RCheevos:  {0x10, ""},
+		emulator.NWA:       {0x10, "RAM"},
+	}},
+	{[]string{"Genesis"}, false, 0x000200, emulator.ROM, 0x200, map[emulator.Backend]at{
+		emulator.RetroArch: {0x200, ""},
+		emulator.NWA:       {0x200, "CARTROM"},
+	}},
+
+	{[]string{"GBA"}, false, 0x03000010, emulator.IWRAM, 0x10, map[emulator.Backend]at{
+		emulator.RetroArch: {0x19010, ""},
+		emulator.RCheevos:  {0x10, ""},
+		emulator.NWA:       {0x10, "IWRAM"},
+	}},
+	{[]string{"GBA"}, false, 0x02000010, emulator.EWRAM, 0x10, map[emulator.Backend]at{
+		emulator.RetroArch: {0x21010, ""},
+		emulator.RCheevos:  {0x8010, ""},
+		emulator.NWA:       {0x10, "EWRAM"},
+	}},
+	{[]string{"GBA"}, false, 0x08000010, emulator.ROM, 0x10, map[emulator.Backend]at{
+		emulator.RetroArch: {0x08000010, ""},
+		emulator.NWA:       {0x10, "CARTROM"},
+	}},
+	{[]string{"GBA"}, false, 0x06018010, emulator.VRAM, 0x10010, map[emulator.Backend]at{
+		emulator.RetroArch: {0x06010010, ""},
+		emulator.NWA:       {0x10010, "VRAM"},
+	}},
+	{[]string{"GBA"}, false, 0x07000010, emulator.OAM, 0x10, map[emulator.Backend]at{
+		emulator.RetroArch: {0x07000010, ""},
+		emulator.NWA:       {0x10, "OAM"},
+	}},
+
+	{[]string{"3DS"}, false, 0x20000010, emulator.FCRAM, 0x10, map[emulator.Backend]at{
+		emulator.RetroArch: {0x20000010, ""},
+		emulator.NWA:       {0x10, "FCRAM"},
+	}},
+
+	{[]string{"DS"}, false, 0x02000010, emulator.PSRAM, 0x10, map[emulator.Backend]at{
+		emulator.RetroArch:        {0x02000010, ""},
+		emulator.RetroArchMelonDS: {0x10, ""},
+		emulator.RCheevos:         {0x10, ""},
+		emulator.NWA:              {0x10, "PSRAM"},
+	}},
+	{[]string{"DS"}, false, noBus, emulator.ROM, 0x10, map[emulator.Backend]at{
+		emulator.NWA: {0x10, "CARTROM"},
+	}},
+
+	{[]string{"N64"}, false, 0x80000010, emulator.RDRAM, 0x10, map[emulator.Backend]at{
+		emulator.RetroArch: {0x10, ""},
+		emulator.RCheevos:  {0x10, ""},
+		emulator.NWA:       {0x10, "RDRAM"},
+	}},
+	{[]string{"N64"}, false, 0xB0000010, emulator.ROM, 0x10, map[emulator.Backend]at{
+		emulator.RetroArch: {0x10000010, ""},
+		emulator.NWA:       {0x10, "CARTROM"},
+	}},
+
+	{[]string{"SMS", "GG"}, false, 0xE010, emulator.RAM, 0xC010, map[emulator.Backend]at{
+		emulator.RetroArch: {0xC010, ""},
+		emulator.RCheevos:  {0x10, ""},
+		emulator.NWA:       {0x10, "RAM"},
+	}},
+
+	{[]string{"PCE"}, false, 0x1F0010, emulator.RAM, 0x10, map[emulator.Backend]at{
+		emulator.RetroArch: {0x1F0010, ""},
+		emulator.RCheevos:  {0x10, ""},
+		emulator.NWA:       {0x10, "RAM"},
+	}},
+	{[]string{"PCE"}, false, 0x2010, emulator.RAM, 0x10, map[emulator.Backend]at{
+		emulator.RetroArch: {0x1F0010, ""},
+	}},
+
+	{[]string{"Saturn"}, false, 0x26000010, emulator.HWRAM, 0x10, map[emulator.Backend]at{
+		emulator.RetroArch: {0x06000010, ""},
+		emulator.RCheevos:  {0x100010, ""},
+		emulator.NWA:       {0x10, "HWRAM"},
+	}},
+	{[]string{"Saturn"}, false, 0x00200010, emulator.LWRAM, 0x10, map[emulator.Backend]at{
+		emulator.RetroArch: {0x00200010, ""},
+		emulator.RCheevos:  {0x10, ""},
+		emulator.NWA:       {0x10, "LWRAM"},
+	}},
+
+	{[]string{"NGP"}, false, 0x4010, emulator.RAM, 0x4010, map[emulator.Backend]at{
+		emulator.RetroArch: {0x4010, ""},
+		emulator.RCheevos:  {0x10, ""},
+		emulator.NWA:       {0x10, "RAM"},
+	}},
+
+	{[]string{"A2600"}, false, 0x0190, emulator.RAM, 0x90, map[emulator.Backend]at{
+		emulator.RetroArch: {0x90, ""},
+		emulator.RCheevos:  {0x10, ""},
+		emulator.NWA:       {0x10, "RAM"},
+	}},
+}
+
+// backendCapabilities are the capabilities of the client reading through
+// each backend.
+var backendCapabilities = map[emulator.Backend]emulator.Capabilities{
+	emulator.RetroArch:        retroarch.NewClient("127.0.0.1", "55355").Capabilities(),
+	emulator.RetroArchMelonDS: retroarch.NewClient("127.0.0.1", "55355").Capabilities(),
+	emulator.RCheevos:         retroarch.NewClient("127.0.0.1", "55355").Capabilities(),
+	emulator.NWA:              nwa.NewClient("127.0.0.1", "48879").Capabilities(),
+	emulator.USB2SNES:         qusb2snes.NewClient("127.0.0.1", "23074").Capabilities(),
+}
+
+func TestMemoryMapAddresses(t *testing.T) {
+	for _, tt := range memoryMapTests {
+		for _, platform := range tt.platforms {
+			plan := &emulator.ReadPlan{Platform: platform, HiROM: tt.hiROM}
+			name := fmt.Sprintf("%s %s 0x%X", platform, tt.bank, tt.addr)
+
+			if tt.bus != noBus {
+				bank, addr := normalize(plan, tt.bank, tt.bus)
+				if bank != tt.bank || addr != tt.addr {
+					t.Errorf("%s: bus 0x%X normalized to %s 0x%X", name, tt.bus, bank, addr)
+				}
+			}
+
+			for backend, want := range tt.backends {
+				spec := emulator.ReadSpec{Bank: tt.bank, Address: emulator.HexInt(tt.addr)}
+
+				addr, domain, err := emulator.Translate(plan, backend, spec)
+				if err != nil {
+					t.Errorf("%s through %s: %v", name, backend, err)
+					continue
+				}
+				if addr != want.addr || domain != want.domain {
+					t.Errorf("%s through %s at %q 0x%X, want %q 0x%X", name, backend, domain, addr, want.domain, want.addr)
+				}
+
+				if !backendCapabilities[backend].SupportsBank(tt.bank) {
+					t.Errorf("%s translates for %s, its client does not read %s", name, backend, tt.bank)
+				}
+			}
+		}
+	}
+}
+
+// normalize returns the bank address of a bus address, which is the bus
+// address itself for banks addressed the way the bus does.
+func normalize(plan *emulator.ReadPlan, bank emulator.Bank, bus int) (emulator.Bank, int) {
+	if target, addr, ok := emulator.NormalizeAddress(plan, bank, bus); ok {
+		return target, addr
+	}
+	if m, ok := emulator.LookupBank(plan.Platform, bank); ok && m.Contains(bus, 1) {
+		return bank, bus
+	}
+	return "", 0
+}
+
+func TestSNESBus(t *testing.T) {
+	lorom := &emulator.ReadPlan{Platform: "SNES"}
+	hirom := &emulator.ReadPlan{Platform: "SNES", HiROM: true}
+	sa1 := &emulator.ReadPlan{Platform: "SNES", SA1: true}
+
+	tests := []struct {
+		name string
+		plan *emulator.ReadPlan
+		bus  int
+		bank emulator.Bank
+		addr int
+	}{
+		{"LoROM $40:0000 is not BW-RAM", lorom, 0x400000, "", 0},
+		{"LoROM $40:8000", lorom, 0x408000, emulator.ROM, 0x200000},
+		{"LoROM $00:3010 is not I-RAM", lorom, 0x003010, "", 0},
+		{"HiROM $40:0000", hirom, 0x400000, emulator.ROM, 0},
+		{"SA-1 BW-RAM", sa1, 0x400010, emulator.BWRAM, 0x10},
+		{"SA-1 BW-RAM last bank", sa1, 0x43FFFF, emulator.BWRAM, 0x3FFFF},
+		{"SA-1 I-RAM", sa1, 0x003010, emulator.IRAM, 0x10},
+		{"SA-1 I-RAM mirror", sa1, 0x803010, emulator.IRAM, 0x10},
+		{"SA-1 past BW-RAM", sa1, 0x448000, emulator.ROM, 0x220000},
+	}
+
+	snes, _ := emulator.LookupPlatform("SNES")
+	for _, tt := range tests {
+		bank, addr, _ := snes.BusTarget(tt.plan, uint64(tt.bus))
+		if bank != tt.bank || addr != tt.addr {
+			t.Errorf("%s: bus 0x%X reaches %q 0x%X, want %q 0x%X", tt.name, tt.bus, bank, addr, tt.bank, tt.addr)
+		}
+	}
+}
+
+func TestMemoryMapsCovered(t *testing.T) {
+	covered := make(map[string]bool)
+	for _, tt := range memoryMapTests {
+		for _, platform := range tt.platforms {
+			for backend := range tt.backends {
+				covered[fmt.Sprint(platform, tt.bank, backend)] = true
+			}
+		}
+	}
+
+	for _, p := range emulator.MemoryMaps {
+		if _, ok := emulator.LookupBank(p.Name, p.DefaultBank); !ok {
+			t.Errorf("%s default bank %s is not in its map", p.Name, p.DefaultBank)
+		}
+
+		seen := make(map[emulator.Bank]bool)
+		for _, bank := range p.Banks {
+			if seen[bank.Bank] {
+				t.Errorf("%s lists %s twice", p.Name, bank.Bank)
+			}
+			seen[bank.Bank] = true
+
+			for backend := range bank.Backends {
+				if !covered[fmt.Sprint(p.Name, bank.Bank, backend)] {
+					t.Errorf("%s %s through %s has no known address", p.Name, bank.Bank, backend)
+				}
+			}
+		}
+	}
+}
@@ -19,8 +19,21 @@ const (
 	U64       ValueType = "U64"
 	Bool      ValueType = "Bool"
 	FlagCount ValueType = "FlagCount"
+	I24       ValueType = "I24"
+	U24       ValueType = "U24"
+	BCD       ValueType = "BCD"    // packed BCD, see ReadSpec.Digits
+	Fixed     ValueType = "Fixed"  // signed fixed-point, see ReadSpec.FracBits
+	UFixed    ValueType = "UFixed" // unsigned fixed-point
+	Bytes     ValueType = "Bytes"  // raw bytes, size is required
 )
 
+var ValueTypes = []ValueType{
+	F32, F64, String, UTF16LE,
+	I8, I16, I24, I32, I64,
+	U8, U16, U24, U32, U64,
+	Bool, FlagCount, BCD, Fixed, UFixed, Bytes,
+}
+
 type ConnectionStatus byte
 
 const (
@@ -32,6 +45,10 @@ const (
 
 var ErrGameNotLoaded = errors.New("game not loaded")
 
+// ErrAddressUnmapped is returned for reads the backend has no memory at
+// while a game runs.
+var ErrAddressUnmapped = errors.New("address not mapped")
+
 type Value struct {
 	Type      ValueType
 	Name      string
@@ -42,6 +59,12 @@ type Value struct {
 	String    string
 	Bool      bool
 	FlagCount int
+	Bytes     []byte
+
+	// element of an array watch, see ReadSpec.Expand
+	Array string
+	Index int
+	Field string
 }
 
 type Connector interface {
@@ -59,8 +82,13 @@ type Planner interface {
 	CompileReadPlan(plan *ReadPlan) *CompiledReadPlan
 }
 
+type Describer interface {
+	Capabilities() Capabilities
+}
+
 type MemoryReader interface {
 	Connector
 	Reader
 	Planner
+	Describer
 }
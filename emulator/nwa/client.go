@@ -10,14 +10,40 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"strconv"
 	"strings"
 	"time"
 )
 
 var log = logger.Module("emulator/nwa/client").SetLevel(logger.InfoLevel)
 
-// const maxGap = 16
-// const maxReadSize = 4096
+var capabilities = emulator.Capabilities{
+	MaxReadSize:  0x10000,
+	PreferredGap: 16,
+	Banks: []emulator.Bank{
+		emulator.WRAM,
+		emulator.SRAM,
+		emulator.RAM,
+		emulator.IWRAM,
+		emulator.EWRAM,
+		emulator.FCRAM,
+		emulator.PSRAM,
+		emulator.RDRAM,
+		emulator.ROM,
+		emulator.BWRAM,
+		emulator.HRAM,
+		emulator.CartRAM,
+		emulator.PRGRAM,
+		emulator.VRAM,
+		emulator.OAM,
+		emulator.HWRAM,
+		emulator.LWRAM,
+	},
+	// a TCP round trip, replies are raw binary
+	LatencyCost: 256,
+	// a CORE_READ line and the header of its binary reply
+	RegionCost: 32,
+}
 
 type Error struct {
 	Kind   errorKind
@@ -31,8 +57,15 @@ type Client struct {
 	addr              *net.TCPAddr
 	gameConnected     bool
 
+	compiled *emulator.CompiledReadPlan
+
+	// reused by every command, see execute
+	reader  *bufio.Reader
 	respBuf []byte
 	byteBuf []byte
+	cmdBuf  []byte
+	argBuf  []byte
+	binary  binaryReply
 }
 
 func NewClient(ip, port string) *Client {
@@ -44,6 +77,8 @@ func NewClient(ip, port string) *Client {
 		addr:    tcpAddr,
 		respBuf: make([]byte, 4096),
 		byteBuf: make([]byte, 0, 16),
+		cmdBuf:  make([]byte, 0, 64),
+		argBuf:  make([]byte, 0, 64),
 	}
 }
 
@@ -66,6 +101,11 @@ func (c *Client) ConnectEmulator() emulator.ConnectionStatus {
 	log.Info("tcp connection established")
 
 	c.conn = conn
+	if c.reader == nil {
+		c.reader = bufio.NewReader(conn)
+	} else {
+		c.reader.Reset(conn)
+	}
 
 	summary, err := c.EmuInfo()
 	if err != nil {
@@ -97,19 +137,31 @@ func (c *Client) ConnectEmulator() emulator.ConnectionStatus {
 }
 
 func (c *Client) ExecuteCommand(cmd string, argString *string) (EmulatorReply, error) {
-	var command string
+	if argString == nil {
+		return c.execute(cmd, nil)
+	}
+
+	c.argBuf = append(c.argBuf[:0], *argString...)
+	return c.execute(cmd, c.argBuf)
+}
 
+// execute sends cmd with args, which may be nil, and returns the reply.
+// Binary replies share the client's buffer until the next command.
+func (c *Client) execute(cmd string, args []byte) (EmulatorReply, error) {
 	_ = c.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
 
-	if argString == nil {
-		command = fmt.Sprintf("%s\n", cmd)
-	} else {
-		command = fmt.Sprintf("%s %s\n", cmd, *argString)
+	c.cmdBuf = append(c.cmdBuf[:0], cmd...)
+	if args != nil {
+		c.cmdBuf = append(c.cmdBuf, ' ')
+		c.cmdBuf = append(c.cmdBuf, args...)
 	}
+	c.cmdBuf = append(c.cmdBuf, '\n')
 
-	log.Debug("sending command: %q", strings.TrimSpace(command))
+	if log.DebugEnabled() {
+		log.Debug("sending command: %q", bytes.TrimSpace(c.cmdBuf))
+	}
 
-	_, err := io.WriteString(c.conn, command)
+	_, err := c.conn.Write(c.cmdBuf)
 	if err != nil {
 		log.Error("command write failed: %v", err)
 		return nil, err
@@ -122,8 +174,10 @@ func (c *Client) ExecuteCommand(cmd string, argString *string) (EmulatorReply, e
 		log.Debug("reply=nil")
 	case hash:
 		log.Debug("reply=hash keys=%d", len(v))
-	case []byte:
-		log.Debug("reply=binary bytes=%d", len(v))
+	case *binaryReply:
+		if log.DebugEnabled() {
+			log.Debug("reply=binary bytes=%d", len(v.data))
+		}
 	case Error:
 		log.Warn(
 			"reply=protocol error kind=%v reason=%s",
@@ -145,12 +199,14 @@ func (c *Client) ExecuteCommand(cmd string, argString *string) (EmulatorReply, e
 		return nil, err
 	}
 
-	log.Debug(
-		"command %s completed in %s reply=%T",
-		cmd,
-		duration,
-		reply,
-	)
+	if log.DebugEnabled() {
+		log.Debug(
+			"command %s completed in %s reply=%T",
+			cmd,
+			duration,
+			reply,
+		)
+	}
 
 	return reply, nil
 }
@@ -201,8 +257,15 @@ type hash map[string]string
 
 type EmulatorReply interface{}
 
+// binaryReply is a binary block. It is returned by pointer, a slice in an
+// interface is allocated, and shares the client's buffer until the next
+// command.
+type binaryReply struct {
+	data []byte
+}
+
 func (c *Client) getReply() (EmulatorReply, error) {
-	readStream := bufio.NewReader(c.conn)
+	readStream := c.reader
 
 	firstByte, err := readStream.ReadByte()
 	if err != nil {
@@ -279,41 +342,46 @@ func (c *Client) getReply() (EmulatorReply, error) {
 
 	// Binary
 	if firstByte == 0 {
-		header := make([]byte, 4)
+		header := c.byteBuf[:4]
 		n, err := io.ReadFull(readStream, header)
 		if err != nil || n != 4 {
 			return nil, errors.New("failed to read header")
 		}
 		size := binary.BigEndian.Uint32(header)
-		log.Debug(
-			"binary reply incoming size=%d bytes",
-			size,
-		)
-		data := make([]byte, size)
+		// boxing size allocates once it is past the runtime's small ints
+		if log.DebugEnabled() {
+			log.Debug(
+				"binary reply incoming size=%d bytes",
+				size,
+			)
+		}
+		if cap(c.respBuf) < int(size) {
+			c.respBuf = make([]byte, size)
+		}
+		data := c.respBuf[:size]
 		_, err = io.ReadFull(readStream, data)
 		if err != nil {
 			return nil, err
 		}
-		return data, nil
+		c.binary.data = data
+		return &c.binary, nil
 	}
 
 	return nil, errors.New("invalid reply")
 }
 
-// This would be used if I actually sent data
-// func (c *SyncClient) sendData(data []byte) {
-// 	buf := make([]byte, 5)
-// 	size := len(data)
-// 	buf[0] = 0
-// 	buf[1] = byte((size >> 24) & 0xFF)
-// 	buf[2] = byte((size >> 16) & 0xFF)
-// 	buf[3] = byte((size >> 8) & 0xFF)
-// 	buf[4] = byte(size & 0xFF)
-// 	// TODO: handle the error
-// 	c.Connection.Write(buf)
-// 	// TODO: handle the error
-// 	c.Connection.Write(data)
-// }
+// sendData sends the binary block that follows a b-prefixed command:
+// a zero byte, the size as 32-bit big endian, then the data.
+func (c *Client) sendData(data []byte) error {
+	header := append(c.byteBuf[:0], 0)
+	header = binary.BigEndian.AppendUint32(header, uint32(len(data)))
+
+	if _, err := c.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(data)
+	return err
+}
 
 func (c *Client) ClientID() {
 	cmd := "MY_NAME_IS"
@@ -340,15 +408,43 @@ func (c *Client) EmuInfo() (EmulatorReply, error) {
 	return summary, nil
 }
 
-func (c *Client) EmuGameInfo() {
+func (c *Client) EmuGameInfo() (EmulatorReply, error) {
 	cmd := "GAME_INFO"
 	summary, err := c.ExecuteCommand(cmd, nil)
 	if err != nil {
 		log.Error("GAME_INFO failed: %v", err)
-		return
+		return nil, err
 	}
 
-	log.Info("GAME_INFO response: %#v", summary)
+	log.Debug("GAME_INFO response: %#v", summary)
+	return summary, nil
+}
+
+// GameInfo reports the game from GAME_INFO, its name and file.
+func (c *Client) GameInfo() (*emulator.GameInfo, error) {
+	summary, err := c.EmuGameInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	switch reply := summary.(type) {
+	case hash:
+		if reply["name"] == "" && reply["file"] == "" {
+			return nil, emulator.ErrGameNotLoaded
+		}
+		return &emulator.GameInfo{
+			Name: reply["name"],
+			File: reply["file"],
+		}, nil
+
+	case Error:
+		return nil, emulator.ErrGameNotLoaded
+
+	case nil:
+		return nil, emulator.ErrGameNotLoaded
+	}
+
+	return nil, fmt.Errorf("unexpected GAME_INFO reply %T", summary)
 }
 
 func (c *Client) EmuStatus() {
@@ -414,130 +510,146 @@ func (c *Client) GameConnected() bool {
 	return c.gameConnected
 }
 
+func (c *Client) Capabilities() emulator.Capabilities {
+	return capabilities
+}
+
 func (c *Client) CompileReadPlan(
 	plan *emulator.ReadPlan,
 ) *emulator.CompiledReadPlan {
 	return emulator.CompileReadPlan(
 		plan,
-		emulator.ResolveAddress,
-		nil,
+		capabilities,
+		emulator.NWA,
 	)
 }
 
-func domainForBank(bank emulator.Bank) string {
-	switch bank {
-	case emulator.WRAM:
-		return "WRAM"
+func appendHexUpper(dst []byte, v uint64) []byte {
+	start := len(dst)
+	dst = strconv.AppendUint(dst, v, 16)
+	for i := start; i < len(dst); i++ {
+		if dst[i] >= 'a' {
+			dst[i] -= 'a' - 'A'
+		}
+	}
+	return dst
+}
 
-	case emulator.RAM:
-		return "RAM"
+func (c *Client) GetValues(plan *emulator.CompiledReadPlan) ([]emulator.Value, error) {
+	log.Debug("reading %d merged regions", len(plan.Regions))
 
-	case emulator.IWRAM:
-		return "IWRAM"
+	// readMemory looks up the domain of each bank
+	c.compiled = plan
 
-	case emulator.EWRAM:
-		return "EWRAM"
+	vals := plan.Values()
 
-	case emulator.FCRAM:
-		return "FCRAM"
+	for i := range plan.Regions {
+		region := &plan.Regions[i]
 
-	case emulator.PSRAM:
-		return "PSRAM"
+		err := c.readMemory(region.Bank, region.Start, region.Buffer)
+		if err != nil {
+			return nil, err
+		}
 
-	case emulator.RDRAM:
-		return "RDRAM"
+		vals = emulator.DecodeRegion(region, vals)
+	}
 
-	default:
-		return "RAM"
+	vals, err := plan.ResolvePointerChains(c.readMemory, vals)
+	if err != nil {
+		return nil, err
 	}
-}
 
-func (c *Client) GetValues(plan *emulator.CompiledReadPlan) ([]emulator.Value, error) {
-	log.Debug("reading %d merged regions", len(plan.Regions))
+	log.Debug("decoded %d values", len(vals))
+
+	return vals, nil
+}
 
-	vals := make([]emulator.Value, 0)
+// readMemory issues a CORE_READ for len(dst) bytes and copies the reply into dst.
+func (c *Client) readMemory(bank emulator.Bank, addr int, dst []byte) error {
+	return c.readDomain(c.compiled.Domain(bank), addr, dst)
+}
 
+// readDomain reads len(dst) bytes at addr of a memory domain.
+func (c *Client) readDomain(domain string, addr int, dst []byte) error {
 	cmd := "CORE_READ"
 
-	for _, region := range plan.Regions {
-		domain := domainForBank(region.Bank)
-
-		args := fmt.Sprintf(
-			"%s;$%X;%d",
-			domain,
-			region.Start,
-			region.Size,
-		)
+	// domain;$ADDR;size
+	args := append(c.argBuf[:0], domain...)
+	args = append(args, ";$"...)
+	args = appendHexUpper(args, uint64(addr))
+	args = append(args, ';')
+	args = strconv.AppendInt(args, int64(len(dst)), 10)
+	c.argBuf = args
 
+	if log.DebugEnabled() {
 		log.Debug(
-			"CORE_READ domain=%s bank=%s start=$%X size=%d args=%q",
+			"CORE_READ domain=%s start=$%X size=%d args=%q",
 			domain,
-			region.Bank,
-			region.Start,
-			region.Size,
+			addr,
+			len(dst),
 			args,
 		)
+	}
 
-		log.Debug(
-			"CORE_READ bank=%s start=%X end=%X size=%d",
-			region.Bank,
-			region.Start,
-			region.Start+region.Size,
-			region.Size,
+	summary, err := c.execute(cmd, args)
+	if err != nil {
+		log.Error("CORE_READ failed: %v", err)
+		return err
+	}
+
+	var data []byte
+
+	switch v := summary.(type) {
+	case *binaryReply:
+		data = v.data
+	case Error:
+		log.Error(
+			"CORE_READ rejected: domain=%s start=$%X size=%d kind=%v reason=%s",
+			domain,
+			addr,
+			len(dst),
+			v.Kind,
+			v.Reason,
 		)
 
-		summary, err := c.ExecuteCommand(cmd, &args)
-		if err != nil {
-			log.Error("CORE_READ failed: %v", err)
-			return nil, err
+		// an address outside the domain, a pointer chain through it is
+		// skipped
+		if v.Kind == InvalidArgument {
+			return fmt.Errorf("%w: CORE_READ rejected: %s", emulator.ErrAddressUnmapped, v.Reason)
 		}
 
-		var data []byte
-
-		switch v := summary.(type) {
-		case []byte:
-			data = v
-		case Error:
-			log.Error(
-				"CORE_READ rejected: bank=%s start=$%X size=%d kind=%v reason=%s",
-				region.Bank,
-				region.Start,
-				region.Size,
-				v.Kind,
-				v.Reason,
-			)
-
-			return nil, fmt.Errorf(
-				"CORE_READ rejected: %s",
-				v.Reason,
-			)
-		case hash:
-			log.Error(
-				"CORE_READ returned hash instead of binary: %#v",
-				v,
-			)
+		return fmt.Errorf(
+			"CORE_READ rejected: %s",
+			v.Reason,
+		)
+	case hash:
+		log.Error(
+			"CORE_READ returned hash instead of binary: %#v",
+			v,
+		)
 
-			return nil, fmt.Errorf(
-				"CORE_READ returned hash response",
-			)
-		default:
-			log.Error(
-				"unexpected CORE_READ response type %T value=%#v",
-				summary,
-				summary,
-			)
+		return fmt.Errorf(
+			"CORE_READ returned hash response",
+		)
+	default:
+		log.Error(
+			"unexpected CORE_READ response type %T value=%#v",
+			summary,
+			summary,
+		)
 
-			return nil, fmt.Errorf(
-				"unexpected CORE_READ response type %T",
-				summary,
-			)
-		}
+		return fmt.Errorf(
+			"unexpected CORE_READ response type %T",
+			summary,
+		)
+	}
 
+	if log.DebugEnabled() {
 		log.Debug(
 			"CORE_READ returned %d bytes for %s @ $%X",
 			len(data),
 			domain,
-			region.Start,
+			addr,
 		)
 
 		preview := len(data)
@@ -550,31 +662,77 @@ func (c *Client) GetValues(plan *emulator.CompiledReadPlan) ([]emulator.Value, e
 			preview,
 			data[:preview],
 		)
+	}
 
-		if len(data) < region.Size {
-			return nil, fmt.Errorf(
-				"short read: expected %d bytes, got %d",
-				region.Size,
-				len(data),
-			)
-		}
+	if len(data) < len(dst) {
+		return fmt.Errorf(
+			"short read: expected %d bytes, got %d",
+			len(dst),
+			len(data),
+		)
+	}
 
-		for _, watch := range region.Watches {
-			raw := data[watch.Offset : watch.Offset+watch.Size]
+	copy(dst, data)
 
-			val := emulator.DecodeValue(watch.Spec, raw)
+	return nil
+}
 
-			if val == nil {
-				return nil, fmt.Errorf(
-					"unsupported value decode size %d",
-					watch.Size,
-				)
-			}
+// WriteValue writes v with bCORE_WRITE domain;$ADDR;size followed by the
+// data, answered with an empty reply or an error. Bit fields are read with
+// CORE_READ first.
+func (c *Client) WriteValue(plan *emulator.ReadPlan, spec emulator.ReadSpec, v emulator.Value) error {
+	readBack := func(r emulator.Write) error {
+		return c.readDomain(r.Domain, r.Addr, r.Data)
+	}
+
+	writes, err := emulator.PrepareWrite(plan, emulator.NWA, spec, v, readBack)
+	if err != nil {
+		return err
+	}
 
-			vals = append(vals, *val)
+	for _, w := range writes {
+		if err := c.writeMemory(w); err != nil {
+			return err
 		}
+		log.Debug("wrote %s: %d bytes to %s @ $%X", spec.Name, len(w.Data), w.Domain, w.Addr)
 	}
-	log.Debug("decoded %d values", len(vals))
 
-	return vals, nil
+	return nil
+}
+
+// writeMemory sends one CORE_WRITE and waits for its reply.
+func (c *Client) writeMemory(w emulator.Write) error {
+	args := append(c.argBuf[:0], w.Domain...)
+	args = append(args, ";$"...)
+	args = appendHexUpper(args, uint64(w.Addr))
+	args = append(args, ';')
+	args = strconv.AppendInt(args, int64(len(w.Data)), 10)
+	c.argBuf = args
+
+	c.cmdBuf = append(c.cmdBuf[:0], "bCORE_WRITE "...)
+	c.cmdBuf = append(c.cmdBuf, args...)
+	c.cmdBuf = append(c.cmdBuf, '\n')
+
+	_ = c.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+
+	if _, err := c.conn.Write(c.cmdBuf); err != nil {
+		log.Error("command write failed: %v", err)
+		return err
+	}
+	if err := c.sendData(w.Data); err != nil {
+		log.Error("CORE_WRITE data write failed: %v", err)
+		return err
+	}
+
+	reply, err := c.getReply()
+	if err != nil {
+		log.Error("CORE_WRITE failed: %v", err)
+		return err
+	}
+
+	if v, ok := reply.(Error); ok {
+		return fmt.Errorf("CORE_WRITE rejected: %s", v.Reason)
+	}
+
+	return nil
 }
@@ -0,0 +1,193 @@
+package nwa
+
+import (
+	"FactFinder/emulator"
+	"FactFinder/emulator/emulatortest"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"testing"
+)
+
+// fakeNWA answers NWA commands from a memory image per domain.
+type fakeNWA struct {
+	ln  *net.TCPListener
+	mem map[string][]byte
+}
+
+func newFakeNWA(t testing.TB, mem map[string][]byte) *Client {
+	t.Helper()
+
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	f := &fakeNWA{ln: ln, mem: mem}
+	go f.serve()
+
+	port := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+	client := NewClient("127.0.0.1", port)
+	if client.ConnectEmulator() != emulator.Connected {
+		t.Fatalf("client did not connect")
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+func (f *fakeNWA) serve() {
+	for {
+		conn, err := f.ln.AcceptTCP()
+		if err != nil {
+			return
+		}
+		go f.serveConn(conn)
+	}
+}
+
+// serveConn answers one client. It reuses its buffers, so allocation
+// tests only count the client's.
+func (f *fakeNWA) serveConn(conn *net.TCPConn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	var reply []byte
+	for {
+		line, err := r.ReadSlice('\n')
+		if err != nil {
+			return
+		}
+
+		cmd, args, _ := bytes.Cut(bytes.TrimSpace(line), []byte(" "))
+		reply = f.answer(reply[:0], cmd, args)
+
+		if _, err := conn.Write(reply); err != nil {
+			return
+		}
+	}
+}
+
+// answer appends the reply to cmd to reply, an ascii map or a binary block.
+func (f *fakeNWA) answer(reply, cmd, args []byte) []byte {
+	switch string(cmd) {
+	case "EMULATOR_INFO":
+		return append(reply, "\nname:fake\nversion:1.0\nnwa_version:1.0\n\n"...)
+	case "GAME_INFO":
+		return append(reply, "\nname:Game\nfile:game.sfc\n\n"...)
+	case "CORE_READ":
+		// domain;$ADDR;size
+		domain, rest, _ := bytes.Cut(args, []byte(";$"))
+		addrField, sizeField, _ := bytes.Cut(rest, []byte(";"))
+
+		addr, size := 0, 0
+		for _, c := range addrField {
+			switch {
+			case c >= 'A':
+				addr = addr<<4 | int(c-'A'+10)
+			default:
+				addr = addr<<4 | int(c-'0')
+			}
+		}
+		for _, c := range sizeField {
+			size = size*10 + int(c-'0')
+		}
+
+		mem := f.mem[string(domain)]
+		if addr+size > len(mem) {
+			return append(reply, "\nerror:invalid_argument\nreason:out of range\n\n"...)
+		}
+
+		reply = append(reply, 0)
+		reply = binary.BigEndian.AppendUint32(reply, uint32(size))
+		return append(reply, mem[addr:addr+size]...)
+	}
+
+	return append(reply, "\n\n"...)
+}
+
+func TestGetValues(t *testing.T) {
+	client := newFakeNWA(t, map[string][]byte{"WRAM": emulatortest.WRAM()})
+
+	vals, err := client.GetValues(client.CompileReadPlan(emulatortest.ReadPlan()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	emulatortest.CheckValues(t, vals)
+}
+
+func TestByteOrder(t *testing.T) {
+	tests := []struct {
+		platform string
+		bank     emulator.Bank
+		domain   string
+	}{
+		{"SNES", emulator.WRAM, "WRAM"},
+		// NWA returns RDRAM as the console stores it, nothing is swapped
+		{"N64", emulator.RDRAM, "RDRAM"},
+	}
+
+	for _, tt := range tests {
+		plan := emulatortest.ByteOrderPlan(tt.platform, tt.bank)
+		client := newFakeNWA(t, map[string][]byte{tt.domain: emulatortest.ByteOrderMemory(plan, 0x100, false)})
+
+		caps := client.Capabilities()
+		for _, w := range plan.Watches {
+			if got, want := caps.ByteOrder(plan, w), emulator.WatchEndian(plan, w); got != want {
+				t.Errorf("%s: %s comes back %s, want %s", tt.platform, w.Name, got, want)
+			}
+		}
+
+		vals, err := client.GetValues(client.CompileReadPlan(plan))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.platform, err)
+		}
+		emulatortest.CheckByteOrder(t, vals)
+	}
+}
+
+func TestGetValuesAllocs(t *testing.T) {
+	client := newFakeNWA(t, map[string][]byte{"WRAM": emulatortest.WRAM()})
+	plan := client.CompileReadPlan(emulatortest.ReadPlan())
+
+	// the first read sizes the buffers
+	if _, err := client.GetValues(plan); err != nil {
+		t.Fatal(err)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := client.GetValues(plan); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs > 0 {
+		t.Errorf("GetValues allocates %.1f times a tick, want 0", allocs)
+	}
+}
+
+func TestDanglingPointerSkipped(t *testing.T) {
+	mem := emulatortest.WRAM()
+	// a long pointer to $7F:0000, past the memory the fake has
+	binary.LittleEndian.PutUint32(mem[0x100:], 0x7F0000)
+	client := newFakeNWA(t, map[string][]byte{"WRAM": mem})
+
+	plan := client.CompileReadPlan(&emulator.ReadPlan{
+		Name:     "chain",
+		Platform: "SNES",
+		Watches: []emulator.ReadSpec{
+			{Name: "level", Type: emulator.U8, Bank: emulator.WRAM, Address: 0x13BF},
+			{Name: "boss", Type: emulator.U16, Bank: emulator.WRAM, Address: 0x100, Offsets: []emulator.HexInt{0}, PointerSize: 3},
+		},
+	})
+
+	vals, err := client.GetValues(plan)
+	if err != nil {
+		t.Fatalf("read with a dangling pointer: %v", err)
+	}
+	if len(vals) != 1 || vals[0].Name != "level" || vals[0].Unsigned != 0x2A {
+		t.Fatalf("read %+v, want level=0x2A only", vals)
+	}
+}
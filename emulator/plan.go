@@ -1,6 +1,10 @@
 package emulator
 
-import "slices"
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
 
 const (
 	MaxGap      = 16
@@ -11,26 +15,55 @@ type tempWatch struct {
 	Spec ReadSpec
 	Addr int
 	Size int
+
+	// read extents, aligned to whole words for word-swapped watches
+	Start int
+	End   int
 }
 
 func CompileReadPlan(
 	plan *ReadPlan,
-	resolve func(*ReadPlan, ReadSpec) int,
-	mapper AddressMapper,
+	caps Capabilities,
+	backend Backend,
+) *CompiledReadPlan {
+	return CompileReadPlanBanks(plan, caps, backend, nil)
+}
+
+// CompileReadPlanBanks compiles plan like CompileReadPlan, except the banks
+// in overrides are translated for their own backend, for clients that read
+// some banks another way.
+func CompileReadPlanBanks(
+	plan *ReadPlan,
+	caps Capabilities,
+	backend Backend,
+	overrides map[Bank]Backend,
 ) *CompiledReadPlan {
 	tmp := make([]tempWatch, 0, len(plan.Watches))
-	out := &CompiledReadPlan{}
+	out := &CompiledReadPlan{
+		plan:      plan,
+		backend:   backend,
+		overrides: overrides,
+	}
 
-	for _, spec := range plan.Watches {
-		if spec.Bank == ProcessMemory {
-			out.PointerWatches =
-				append(out.PointerWatches, spec)
+	for _, spec := range expandWatches(plan.Watches) {
+		spec.Endian = caps.ByteOrder(plan, spec)
+
+		if !caps.SupportsBank(spec.Bank) {
+			err := fmt.Errorf("%s %s is not supported by %s", plan.Platform, spec.Bank, backend)
+			log.Warn("skipping watch %s: %v", spec.Name, err)
+			out.Unsupported = append(out.Unsupported, UnsupportedWatch{spec, err})
 			continue
 		}
-		addr := resolve(plan, spec)
 
-		if mapper != nil {
-			addr = mapper(plan, spec, addr)
+		addr, err := out.address(spec)
+		if err != nil {
+			log.Warn("skipping watch %s: %v", spec.Name, err)
+			out.Unsupported = append(out.Unsupported, UnsupportedWatch{spec, err})
+			continue
+		}
+
+		if spec.Module != "" && !slices.Contains(out.modules, spec.Module) {
+			out.modules = append(out.modules, spec.Module)
 		}
 
 		size := spec.SizeOverride
@@ -38,40 +71,81 @@ func CompileReadPlan(
 			size = spec.Size()
 		}
 
+		if len(spec.Offsets) > 0 {
+			ptrSize := PointerSize(plan, spec)
+
+			// pointers are stored the platform's way, as the backend returns it
+			ptrEndian := caps.ByteOrder(plan, ReadSpec{
+				Bank:   spec.Bank,
+				Endian: DefaultEndian(plan.Platform),
+			})
+
+			out.PointerChains = append(out.PointerChains, PointerChain{
+				Spec:          spec,
+				Base:          addr,
+				PointerSize:   ptrSize,
+				PointerEndian: ptrEndian,
+				Size:          size,
+				Buffer:        make([]byte, max(ptrSize, size)),
+				window:        make([]byte, (max(ptrSize, size)+3)&^3+4),
+			})
+			continue
+		}
+
+		start, end := addr, addr+size
+		if spec.Endian.Swapped() {
+			start, end = addr&^3, (end+3)&^3
+		}
+
 		tmp = append(tmp, tempWatch{
-			Spec: spec,
-			Addr: addr,
-			Size: size,
+			Spec:  spec,
+			Addr:  addr,
+			Size:  size,
+			Start: start,
+			End:   end,
 		})
 	}
 
+	// regions never span banks or modules
 	slices.SortFunc(tmp, func(a, b tempWatch) int {
-		return a.Addr - b.Addr
+		if a.Spec.Bank != b.Spec.Bank {
+			return strings.Compare(string(a.Spec.Bank), string(b.Spec.Bank))
+		}
+		if a.Spec.Module != b.Spec.Module {
+			return strings.Compare(a.Spec.Module, b.Spec.Module)
+		}
+		return a.Start - b.Start
 	})
 
+	gap, readSize := caps.MergeGap(), caps.ReadSize()
+
 	for _, w := range tmp {
 		if len(out.Regions) == 0 {
 			out.Regions = append(out.Regions, MergedRegion{
-				Bank:  w.Spec.Bank,
-				Start: w.Addr,
-				Size:  w.Size,
+				Bank:   w.Spec.Bank,
+				Module: w.Spec.Module,
+				Start:  w.Start,
+				Size:   w.End - w.Start,
 			})
 		}
 
 		cur := &out.Regions[len(out.Regions)-1]
 
 		curEnd := cur.Start + cur.Size
-		wEnd := w.Addr + w.Size
+		wEnd := w.End
 
 		canMerge :=
-			w.Addr <= curEnd+MaxGap &&
-				(wEnd-cur.Start) <= MaxReadSize
+			w.Spec.Bank == cur.Bank &&
+				w.Spec.Module == cur.Module &&
+				w.Start <= curEnd+gap &&
+				(max(wEnd, curEnd)-cur.Start) <= readSize
 
 		if !canMerge {
 			out.Regions = append(out.Regions, MergedRegion{
-				Bank:  w.Spec.Bank,
-				Start: w.Addr,
-				Size:  w.Size,
+				Bank:   w.Spec.Bank,
+				Module: w.Spec.Module,
+				Start:  w.Start,
+				Size:   w.End - w.Start,
 			})
 
 			cur = &out.Regions[len(out.Regions)-1]
@@ -87,9 +161,147 @@ func CompileReadPlan(
 		})
 	}
 
+	watches := len(out.PointerChains)
 	for i := range out.Regions {
 		out.Regions[i].Buffer = make([]byte, out.Regions[i].Size)
+		watches += len(out.Regions[i].Watches)
+	}
+	out.values = make([]Value, 0, watches)
+
+	return out
+}
+
+// Values returns the plan's value buffer emptied. Backends append a tick's
+// values to it so reading a compiled plan does not allocate, the result is
+// only valid until the next read.
+func (c *CompiledReadPlan) Values() []Value {
+	return c.values[:0]
+}
+
+// Rebase places the process memory reads relative to module at base, the
+// module's load address. Watches without a module are relative to the base
+// the plan's signature led to, or absolute without a signature.
+func (c *CompiledReadPlan) Rebase(module string, base int) {
+	delta := base - c.bases[module]
+	if delta == 0 {
+		return
+	}
+
+	for i := range c.Regions {
+		region := &c.Regions[i]
+		if region.Bank != ProcessMemory || region.Module != module {
+			continue
+		}
+
+		region.Start += delta
+		for j := range region.Watches {
+			region.Watches[j].Addr += delta
+		}
+	}
+
+	for i := range c.PointerChains {
+		spec := c.PointerChains[i].Spec
+		if spec.Bank == ProcessMemory && spec.Module == module {
+			c.PointerChains[i].Base += delta
+		}
 	}
 
+	if c.bases == nil {
+		c.bases = make(map[string]int)
+	}
+	c.bases[module] = base
+}
+
+// Recompiled replaces c with next, the same plan compiled again in place by
+// a backend whose memory map changed under it, as the plan is still held by
+// its caller.
+func (c *CompiledReadPlan) Recompiled(next *CompiledReadPlan) {
+	generation := c.generation + 1
+	*c = *next
+	c.generation = generation
+}
+
+// Generation counts the times c was recompiled in place, its Unsupported
+// watches may have changed each time.
+func (c *CompiledReadPlan) Generation() int {
+	return c.generation
+}
+
+// Plan returns the read plan c was compiled from.
+func (c *CompiledReadPlan) Plan() *ReadPlan {
+	return c.plan
+}
+
+// Modules returns the modules process watches of the plan are relative to.
+func (c *CompiledReadPlan) Modules() []string {
+	return c.modules
+}
+
+func expandWatches(watches []ReadSpec) []ReadSpec {
+	out := make([]ReadSpec, 0, len(watches))
+	for _, spec := range watches {
+		out = append(out, spec.Expand()...)
+	}
 	return out
 }
+
+// address translates a spec to the address the backend reads from.
+func (c *CompiledReadPlan) address(spec ReadSpec) (int, error) {
+	addr, _, err := Translate(c.plan, c.Backend(spec.Bank), spec)
+	return addr, err
+}
+
+// Backend returns the backend a bank is translated for.
+func (c *CompiledReadPlan) Backend(bank Bank) Backend {
+	if backend, ok := c.overrides[bank]; ok {
+		return backend
+	}
+	return c.backend
+}
+
+// Domain returns the memory domain a bank is read from, for backends that
+// address memory by domain.
+func (c *CompiledReadPlan) Domain(bank Bank) string {
+	b, ok := LookupBank(c.plan.Platform, bank)
+	if !ok {
+		return ""
+	}
+	return b.Backends[c.Backend(bank)].Domain
+}
+
+// DecodeRegion decodes every watch in a region whose Buffer has been filled
+// by the backend and appends the values to vals. A watch whose bytes cannot
+// be decoded, a BCD nibble above 9, is left out for this tick and logged
+// once until it decodes again.
+func DecodeRegion(region *MergedRegion, vals []Value) []Value {
+	var swapped [8]byte
+
+	for i := range region.Watches {
+		watch := &region.Watches[i]
+		raw := region.Buffer[watch.Offset : watch.Offset+watch.Size]
+
+		if watch.Spec.Endian.Swapped() {
+			dst := swapped[:]
+			if watch.Size > len(swapped) {
+				dst = make([]byte, watch.Size)
+			}
+			raw = unswapWords(dst[:watch.Size], region.Buffer, region.Start, watch.Addr)
+		}
+
+		var ok bool
+		vals, ok = appendValue(vals, watch.Spec, raw, &watch.buf)
+		if !ok && !watch.undecodable {
+			// the bytes as stored, raw would move swapped to the heap
+			stored := region.Buffer[watch.Offset : watch.Offset+watch.Size]
+			log.Warn(
+				"cannot decode %s as %s from % X, skipped until it decodes",
+				watch.Spec.Name,
+				watch.Spec.Type,
+				stored,
+			)
+		}
+		watch.undecodable = !ok
+	}
+
+	return vals
+}
@@ -0,0 +1,26 @@
+package emulator_test
+
+import (
+	"FactFinder/emulator"
+	"FactFinder/emulator/emulatortest"
+	"testing"
+)
+
+var benchCapabilities = emulator.Capabilities{
+	MaxReadSize:  emulator.MaxReadSize,
+	PreferredGap: emulator.MaxGap,
+	Banks:        []emulator.Bank{emulator.WRAM},
+	LatencyCost:  128,
+}
+
+func BenchmarkCompileReadPlan(b *testing.B) {
+	plan := emulatortest.ReadPlan()
+
+	b.ReportAllocs()
+	for b.Loop() {
+		compiled := emulator.CompileReadPlan(plan, benchCapabilities, emulator.RetroArch)
+		if len(compiled.Unsupported) > 0 {
+			b.Fatalf("unsupported watches: %v", compiled.Unsupported)
+		}
+	}
+}
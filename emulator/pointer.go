@@ -0,0 +1,206 @@
+package emulator
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrNullPointer = errors.New("null pointer")
+
+// MemoryFunc reads len(dst) bytes from a backend address in the given bank.
+// Every MemoryReader provides one so pointer chains resolve the same way on
+// every backend.
+type MemoryFunc func(bank Bank, addr int, dst []byte) error
+
+type PointerChain struct {
+	Spec        ReadSpec
+	Base        int
+	PointerSize int
+	// byte order of the pointers, the platform's whatever the value's is
+	PointerEndian Endian
+	Size          int
+	Buffer        []byte
+
+	window []byte
+	buf    valueBuffer
+	// the last decode failed, so the failure has been logged
+	undecodable bool
+}
+
+// PointerSize returns the width of a pointer stored in game memory.
+// SNES pointers default to 16-bit (bank $7E implied), use pointerSize: 3
+// for long pointers. Process pointers default to 64-bit, use pointerSize: 4
+// for 32-bit games.
+func PointerSize(plan *ReadPlan, spec ReadSpec) int {
+	if spec.PointerSize > 0 {
+		return spec.PointerSize
+	}
+
+	if spec.Bank == ProcessMemory {
+		return 8
+	}
+
+	switch plan.Platform {
+	case "SNES", "GB", "GBC", "NES", "SMS", "GG", "PCE", "A2600":
+		return 2
+	case "Genesis", "GBA", "PSX", "N64", "DS", "3DS", "Saturn", "NGP":
+		return 4
+	}
+
+	return 4
+}
+
+// PointerTarget converts a pointer read from game memory into the bank and
+// bank-relative address it points at, through the platform's bus map.
+func PointerTarget(plan *ReadPlan, size int, ptr uint64) (Bank, int, error) {
+	if ptr == 0 {
+		return "", 0, ErrNullPointer
+	}
+
+	if plan.Platform == "SNES" && size <= 2 {
+		// near pointer into bank $7E
+		return WRAM, int(ptr & 0xFFFF), nil
+	}
+
+	if p, ok := LookupPlatform(plan.Platform); ok {
+		if bank, addr, ok := p.BusTarget(plan, ptr); ok {
+			return bank, addr, nil
+		}
+	}
+
+	return "", 0, fmt.Errorf(
+		"pointer $%X does not target readable %s memory",
+		ptr,
+		plan.Platform,
+	)
+}
+
+// pointerTarget is PointerTarget for a pointer read from bank. Pointers in
+// process memory are virtual addresses and need no mapping.
+func (c *CompiledReadPlan) pointerTarget(bank Bank, size int, ptr uint64) (Bank, int, error) {
+	if bank != ProcessMemory {
+		return PointerTarget(c.plan, size, ptr)
+	}
+
+	if ptr == 0 {
+		return "", 0, ErrNullPointer
+	}
+	return ProcessMemory, int(ptr), nil
+}
+
+func decodePointer(raw []byte, endian Endian) uint64 {
+	var ptr uint64
+	if endian.Big() {
+		for _, b := range raw {
+			ptr = ptr<<8 | uint64(b)
+		}
+		return ptr
+	}
+
+	for i := len(raw) - 1; i >= 0; i-- {
+		ptr = ptr<<8 | uint64(raw[i])
+	}
+	return ptr
+}
+
+// read reads len(dst) bytes at addr, going through an aligned window when
+// they are word-swapped.
+func (chain *PointerChain) read(
+	read MemoryFunc,
+	bank Bank,
+	addr int,
+	dst []byte,
+	endian Endian,
+) error {
+	if !endian.Swapped() {
+		return read(bank, addr, dst)
+	}
+
+	start := addr &^ 3
+	end := (addr + len(dst) + 3) &^ 3
+	window := chain.window[:end-start]
+
+	if err := read(bank, start, window); err != nil {
+		return err
+	}
+
+	unswapWords(dst, window, start, addr)
+	return nil
+}
+
+// ResolvePointerChains walks every pointer chain in the plan and appends the
+// final values to vals. A chain that hits a null pointer, or memory that is
+// not mapped, a freed object or an unloaded module, is skipped for this
+// tick, as is a value that cannot be decoded. Other errors from read are
+// returned.
+func (c *CompiledReadPlan) ResolvePointerChains(
+	read MemoryFunc,
+	vals []Value,
+) ([]Value, error) {
+	for i := range c.PointerChains {
+		chain := &c.PointerChains[i]
+
+		raw, err := c.resolvePointerChain(chain, read)
+		if err != nil {
+			if !errors.Is(err, ErrGameNotLoaded) &&
+				(errors.Is(err, ErrNullPointer) || errors.Is(err, ErrAddressUnmapped)) {
+				log.Debug("pointer chain %s unavailable: %v", chain.Spec.Name, err)
+				continue
+			}
+			return vals, err
+		}
+
+		var ok bool
+		vals, ok = appendValue(vals, chain.Spec, raw, &chain.buf)
+		if !ok && !chain.undecodable {
+			log.Warn("cannot decode %s as %s from % X, skipped until it decodes", chain.Spec.Name, chain.Spec.Type, raw)
+		}
+		chain.undecodable = !ok
+	}
+
+	return vals, nil
+}
+
+// resolvePointerChain follows chain and returns the raw value it ends at.
+func (c *CompiledReadPlan) resolvePointerChain(
+	chain *PointerChain,
+	read MemoryFunc,
+) ([]byte, error) {
+	bank := chain.Spec.Bank
+	addr := chain.Base
+
+	for _, offset := range chain.Spec.Offsets {
+		raw := chain.Buffer[:chain.PointerSize]
+		if err := chain.read(read, bank, addr, raw, chain.PointerEndian); err != nil {
+			return nil, err
+		}
+
+		ptr := decodePointer(raw, chain.PointerEndian)
+
+		targetBank, target, err := c.pointerTarget(bank, chain.PointerSize, ptr)
+		if err != nil {
+			if errors.Is(err, ErrNullPointer) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: %v", ErrAddressUnmapped, err)
+		}
+
+		spec := chain.Spec
+		spec.Bank = targetBank
+		spec.Address = HexInt(target + int(offset))
+		spec.Offsets = nil
+
+		bank = targetBank
+		addr, err = c.address(spec)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrAddressUnmapped, err)
+		}
+	}
+
+	raw := chain.Buffer[:chain.Size]
+	if err := chain.read(read, bank, addr, raw, chain.Spec.Endian); err != nil {
+		return nil, err
+	}
+
+	return raw, nil
+}
@@ -0,0 +1,102 @@
+package emulator
+
+import (
+	"errors"
+	"testing"
+)
+
+// memoryImage reads a flat image of one bank.
+func memoryImage(mem []byte) MemoryFunc {
+	return func(bank Bank, addr int, dst []byte) error {
+		if addr < 0 || addr+len(dst) > len(mem) {
+			return ErrAddressUnmapped
+		}
+		copy(dst, mem[addr:])
+		return nil
+	}
+}
+
+func TestPointerChainPlatformEndian(t *testing.T) {
+	mem := make([]byte, 0x200)
+	// little-endian SNES pointer to 0x0100
+	mem[0x10], mem[0x11] = 0x00, 0x01
+	// a big-endian value 2 bytes past it
+	mem[0x102], mem[0x103] = 0x12, 0x34
+
+	plan := &ReadPlan{
+		Platform: "SNES",
+		Watches: []ReadSpec{{
+			Name:    "score",
+			Type:    U16,
+			Bank:    WRAM,
+			Address: 0x10,
+			Offsets: []HexInt{2},
+			Endian:  BigEndian,
+		}},
+	}
+
+	compiled := CompileReadPlan(plan, DefaultCapabilities, NWA)
+	vals, err := compiled.ResolvePointerChains(memoryImage(mem), nil)
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if len(vals) != 1 || vals[0].Unsigned != 0x1234 {
+		t.Fatalf("values %+v, want score=0x1234", vals)
+	}
+}
+
+func TestPointerChainUnmappedSkipped(t *testing.T) {
+	mem := make([]byte, 0x200)
+	// a pointer past the end of the image, as to a freed object
+	mem[0x10], mem[0x11] = 0xF0, 0x01
+	// a good pointer to 0x0100
+	mem[0x20], mem[0x21] = 0x00, 0x01
+	mem[0x100] = 0x42
+
+	plan := &ReadPlan{
+		Platform: "SNES",
+		Watches: []ReadSpec{
+			{Name: "dangling", Type: U32, Bank: WRAM, Address: 0x10, Offsets: []HexInt{0x20}},
+			{Name: "lives", Type: U8, Bank: WRAM, Address: 0x20, Offsets: []HexInt{0}},
+		},
+	}
+
+	compiled := CompileReadPlan(plan, DefaultCapabilities, NWA)
+	vals, err := compiled.ResolvePointerChains(memoryImage(mem), nil)
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if len(vals) != 1 || vals[0].Name != "lives" || vals[0].Unsigned != 0x42 {
+		t.Fatalf("values %+v, want lives=0x42 only", vals)
+	}
+
+	// a game that is gone is not a chain to skip
+	gone := func(Bank, int, []byte) error { return ErrGameNotLoaded }
+	if _, err := compiled.ResolvePointerChains(gone, nil); !errors.Is(err, ErrGameNotLoaded) {
+		t.Fatalf("resolve without a game: %v, want ErrGameNotLoaded", err)
+	}
+}
+
+func TestPointerChainBadBCDSkipped(t *testing.T) {
+	mem := make([]byte, 0x200)
+	mem[0x10], mem[0x11] = 0x00, 0x01
+	// the timer is not decimal, the lives beside it are fine
+	mem[0x100], mem[0x101] = 0x1A, 0x03
+
+	plan := &ReadPlan{
+		Platform: "SNES",
+		Watches: []ReadSpec{
+			{Name: "timer", Type: BCD, Digits: 2, Bank: WRAM, Address: 0x10, Offsets: []HexInt{0}},
+			{Name: "lives", Type: U8, Bank: WRAM, Address: 0x10, Offsets: []HexInt{1}},
+		},
+	}
+
+	compiled := CompileReadPlan(plan, DefaultCapabilities, NWA)
+	vals, err := compiled.ResolvePointerChains(memoryImage(mem), nil)
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if len(vals) != 1 || vals[0].Name != "lives" || vals[0].Unsigned != 3 {
+		t.Fatalf("values %+v, want lives=3 only", vals)
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"FactFinder/logger"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/url"
+	"path"
 	"strings"
 
 	"github.com/gorilla/websocket"
@@ -13,11 +15,24 @@ import (
 
 var log = logger.Module("emulator/qusb2snes/client").SetLevel(logger.InfoLevel)
 
-const wramBase = 0xF50000
-const sramBase = 0xE00000
-
-// const maxGap = 16
-// const maxReadSize = 4096
+var capabilities = emulator.Capabilities{
+	MaxReadSize:  0x10000,
+	PreferredGap: 32,
+	Banks: []emulator.Bank{
+		emulator.WRAM,
+		emulator.SRAM,
+		emulator.ROM,
+		emulator.BWRAM,
+		emulator.VRAM,
+		emulator.OAM,
+	},
+	// every region rides along in one GetAddress
+	BatchedReads: true,
+	// a websocket round trip to the device over USB
+	LatencyCost: 1024,
+	// two hex operands of the query, the reply is the data alone
+	RegionCost: 16,
+}
 
 type Command int
 
@@ -36,6 +51,7 @@ const (
 	Rename
 	Remove
 	GetAddress
+	PutAddress
 )
 
 func (c Command) String() string {
@@ -54,6 +70,7 @@ func (c Command) String() string {
 		"Rename",
 		"Remove",
 		"GetAddress",
+		"PutAddress",
 	}[c]
 }
 
@@ -100,6 +117,12 @@ type Client struct {
 
 	respBuf []byte
 	byteBuf []byte
+
+	// GetAddress of queryPlan, sent as is every tick
+	queryPlan *emulator.CompiledReadPlan
+	query     *websocket.PreparedMessage
+	totalSize int
+	data      []byte
 }
 
 func NewClient(host, port string) *Client {
@@ -306,148 +329,246 @@ func (c *Client) Info() (*Info, error) {
 	}, nil
 }
 
+// GameInfo reports the ROM the device is running, qusb2snes only knows its
+// file name.
+func (c *Client) GameInfo() (*emulator.GameInfo, error) {
+	info, err := c.Info()
+	if err != nil {
+		return nil, err
+	}
+
+	game := strings.TrimSpace(info.Game)
+	if game == "" || strings.EqualFold(game, "No Info") || strings.HasPrefix(game, "/sd2snes/") {
+		// menu or nothing loaded
+		return nil, emulator.ErrGameNotLoaded
+	}
+
+	file := path.Base(strings.ReplaceAll(game, "\\", "/"))
+
+	return &emulator.GameInfo{
+		Name: strings.TrimSuffix(file, path.Ext(file)),
+		File: file,
+	}, nil
+}
+
 func (c *Client) Reset() error {
 	return c.sendCommand(Reset, CMD)
 }
 
+func (c *Client) Capabilities() emulator.Capabilities {
+	return capabilities
+}
+
 func (c *Client) CompileReadPlan(
 	plan *emulator.ReadPlan,
 ) *emulator.CompiledReadPlan {
 	return emulator.CompileReadPlan(
 		plan,
-		emulator.ResolveAddress,
-		qusb2snesAddress,
+		capabilities,
+		emulator.USB2SNES,
 	)
 }
 
-func qusb2snesAddress(
-	plan *emulator.ReadPlan,
-	spec emulator.ReadSpec,
-	addr int,
-) int {
-	switch spec.Bank {
-	case emulator.WRAM:
-		return addr + wramBase
-
-	case emulator.SRAM:
-		return addr + sramBase
-	}
-
-	return addr
-}
-
 // generate addresses and sizes
 // get data
 // copy into external data
 func (c *Client) GetValues(plan *emulator.CompiledReadPlan) ([]emulator.Value, error) {
-	log.Debug(
-		"reading %d merged regions",
-		len(plan.Regions),
-	)
-
-	var args []string
-	var sizes []int
-	totalSize := 0
-
-	for index, region := range plan.Regions {
+	if log.DebugEnabled() {
 		log.Debug(
-			"GetAddress region=%d start=$%X size=%d watches=%d",
-			index,
-			region.Start,
-			region.Size,
-			len(region.Watches),
+			"reading %d merged regions",
+			len(plan.Regions),
 		)
+	}
+
+	out := plan.Values()
+
+	if len(plan.Regions) == 0 {
+		return plan.ResolvePointerChains(c.readMemory, out)
+	}
+
+	// operands only change with the plan
+	if c.queryPlan != plan {
+		var args []string
+		c.totalSize = 0
 
-		size := region.Size
-		if size <= 0 {
-			return nil, fmt.Errorf("invalid size for region")
+		for index, region := range plan.Regions {
+			log.Debug(
+				"GetAddress region=%d start=$%X size=%d watches=%d",
+				index,
+				region.Start,
+				region.Size,
+				len(region.Watches),
+			)
+
+			if region.Size <= 0 {
+				return nil, fmt.Errorf("invalid size for region")
+			}
+
+			// Protocol args: address (hex, upper) + size (hex)
+			args = append(args, strings.ToUpper(fmt.Sprintf("%x", region.Start)))
+			args = append(args, fmt.Sprintf("%x", region.Size))
+
+			c.totalSize += region.Size
 		}
 
-		// Protocol args: address (hex, upper) + size (hex)
-		addr := region.Start
-		args = append(args, strings.ToUpper(fmt.Sprintf("%x", addr)))
-		args = append(args, fmt.Sprintf("%x", size))
+		data, err := encodeQuery(GetAddress, SNES, args...)
+		if err != nil {
+			return nil, err
+		}
+		// the frame is built once, not on every write
+		c.query, err = websocket.NewPreparedMessage(websocket.TextMessage, data)
+		if err != nil {
+			return nil, err
+		}
 
-		sizes = append(sizes, size)
-		totalSize += size
+		c.queryPlan = plan
 	}
 
-	log.Debug("requesting SNES memory read: regions=%d totalBytes=%d",
-		len(plan.Regions),
-		totalSize,
-	)
+	if log.DebugEnabled() {
+		log.Debug("requesting SNES memory read: regions=%d totalBytes=%d",
+			len(plan.Regions),
+			c.totalSize,
+		)
+	}
 
-	if err := c.sendCommand(GetAddress, SNES, args...); err != nil {
+	err := c.conn.WritePreparedMessage(c.query)
+	if err != nil {
+		log.Error("websocket write failed opcode=%s: %v", GetAddress, err)
 		return nil, err
 	}
-	log.Debug(
-		"expecting %d bytes total",
-		totalSize,
-	)
 
-	data := make([]byte, 0, totalSize)
-	for len(data) < totalSize {
-		_, msgData, err := c.conn.ReadMessage()
+	if cap(c.data) < c.totalSize {
+		c.data = make([]byte, c.totalSize)
+	}
+	data := c.data[:c.totalSize]
+
+	err = c.readBinary(data)
+	if err != nil {
+		return nil, err
+	}
+
+	consumed := 0
+
+	for index := range plan.Regions {
+		mergedRegion := &plan.Regions[index]
+		// data for region
+		consumed += copy(mergedRegion.Buffer, data[consumed:consumed+mergedRegion.Size])
+
+		out = emulator.DecodeRegion(mergedRegion, out)
+	}
+
+	out, err = plan.ResolvePointerChains(c.readMemory, out)
+	if err != nil {
+		return nil, err
+	}
+
+	if log.DebugEnabled() {
 		log.Debug(
-			"rx binary chunk=%d accumulated=%d/%d",
-			len(msgData),
-			len(data)+len(msgData),
-			totalSize,
+			"decoded %d values",
+			len(out),
 		)
-		if err != nil {
-			log.Error("protocol desync: expected %d got %d", totalSize, len(data))
-			return nil, err
+	}
+
+	return out, nil
+}
+
+// readMemory issues a single GetAddress for len(dst) bytes at a mapped address.
+func (c *Client) readMemory(_ emulator.Bank, addr int, dst []byte) error {
+	err := c.sendCommand(
+		GetAddress,
+		SNES,
+		strings.ToUpper(fmt.Sprintf("%x", addr)),
+		fmt.Sprintf("%x", len(dst)),
+	)
+	if err != nil {
+		return err
+	}
+
+	return c.readBinary(dst)
+}
+
+// WriteValue writes v with a PutAddress followed by the data as a binary
+// message. An FXPak only takes SNES space writes to SRAM and ROM, WRAM
+// writes need a device that is an emulator. Bit fields are read with
+// GetAddress first.
+func (c *Client) WriteValue(plan *emulator.ReadPlan, spec emulator.ReadSpec, v emulator.Value) error {
+	readBack := func(r emulator.Write) error {
+		return c.readMemory(spec.Bank, r.Addr, r.Data)
+	}
+
+	writes, err := emulator.PrepareWrite(plan, emulator.USB2SNES, spec, v, readBack)
+	if err != nil {
+		return err
+	}
+
+	for _, w := range writes {
+		if err := c.writeMemory(w); err != nil {
+			return err
 		}
-		data = append(data, msgData...)
+		log.Debug("wrote %s: %d bytes at $%X", spec.Name, len(w.Data), w.Addr)
 	}
 
-	if len(data) != totalSize {
-		log.Error(
-			"binary read size mismatch expected=%d got=%d",
-			totalSize,
-			len(data),
-		)
+	return nil
+}
+
+// writeMemory sends one PutAddress and its data.
+func (c *Client) writeMemory(w emulator.Write) error {
+	err := c.sendCommand(
+		PutAddress,
+		SNES,
+		strings.ToUpper(fmt.Sprintf("%x", w.Addr)),
+		fmt.Sprintf("%x", len(w.Data)),
+	)
+	if err != nil {
+		return err
 	}
 
-	var out []emulator.Value
-	consumed := 0
+	err = c.conn.WriteMessage(websocket.BinaryMessage, w.Data)
+	if err != nil {
+		log.Error("websocket write failed opcode=%s: %v", PutAddress, err)
+		return err
+	}
 
-	for index, mergedRegion := range plan.Regions {
-		// size of region
-		size := sizes[index]
-		// data for region
-		b := data[consumed : consumed+size]
-		consumed += size
-
-		for _, watch := range mergedRegion.Watches {
-			raw := b[watch.Offset : watch.Offset+watch.Size]
-			val := emulator.DecodeValue(watch.Spec, raw)
-			if val == nil {
-				log.Error(
-					"decode failed watch=%s type=%v size=%d raw=% X",
-					watch.Spec.Name,
-					watch.Spec.Type,
-					watch.Size,
-					raw,
-				)
-				return nil, fmt.Errorf(
-					"unsupported value decode size %d",
-					watch.Size,
+	return nil
+}
+
+// readBinary fills dst from the binary messages of a GetAddress reply,
+// reading straight into dst instead of allocating per message.
+func (c *Client) readBinary(dst []byte) error {
+	read := 0
+	for read < len(dst) {
+		_, r, err := c.conn.NextReader()
+		if err != nil {
+			log.Error("protocol desync: expected %d got %d", len(dst), read)
+			return err
+		}
+
+		for read < len(dst) {
+			n, err := r.Read(dst[read:])
+			read += n
+			if log.DebugEnabled() {
+				log.Debug(
+					"rx binary chunk=%d accumulated=%d/%d",
+					n,
+					read,
+					len(dst),
 				)
 			}
-
-			out = append(out, *val)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				log.Error("protocol desync: expected %d got %d", len(dst), read)
+				return err
+			}
 		}
 	}
-	log.Debug(
-		"decoded %d values",
-		len(out),
-	)
 
-	return out, nil
+	return nil
 }
 
-func (c *Client) sendCommand(command Command, space Space, args ...string) error {
+// encodeQuery returns the JSON of a command.
+func encodeQuery(command Command, space Space, args ...string) ([]byte, error) {
 	query := USB2SnesQuery{
 		Opcode:   command.String(),
 		Space:    space.String(),
@@ -468,14 +589,22 @@ func (c *Client) sendCommand(command Command, space Space, args ...string) error
 			query.Opcode,
 			err,
 		)
+		return nil, err
+	}
+
+	return jsonData, nil
+}
+
+func (c *Client) sendCommand(command Command, space Space, args ...string) error {
+	jsonData, err := encodeQuery(command, space, args...)
+	if err != nil {
 		return err
 	}
 
 	log.Debug(
-		"tx opcode=%s space=%s operands=%v",
-		query.Opcode,
-		query.Space,
-		query.Operands,
+		"tx opcode=%s operands=%v",
+		command,
+		args,
 	)
 	err = c.conn.WriteMessage(
 		websocket.TextMessage,
@@ -485,7 +614,7 @@ func (c *Client) sendCommand(command Command, space Space, args ...string) error
 	if err != nil {
 		log.Error(
 			"websocket write failed opcode=%s: %v",
-			query.Opcode,
+			command,
 			err,
 		)
 		return err
@@ -493,7 +622,7 @@ func (c *Client) sendCommand(command Command, space Space, args ...string) error
 
 	log.Debug(
 		"tx opcode=%s bytes=%d",
-		query.Opcode,
+		command,
 		len(jsonData),
 	)
 
@@ -0,0 +1,283 @@
+package qusb2snes
+
+import (
+	"FactFinder/emulator"
+	"FactFinder/emulator/emulatortest"
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// wramBase is the address of WRAM in usb2snes space.
+const wramBase = 0xF50000
+
+// fakeQUsb2Snes answers usb2snes commands from an image of WRAM. It speaks
+// the websocket protocol itself with reused buffers, so allocation tests
+// only count the client's.
+type fakeQUsb2Snes struct {
+	ln  *net.TCPListener
+	mem []byte
+}
+
+func newFakeQUsb2Snes(t testing.TB, mem []byte) *Client {
+	t.Helper()
+
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	f := &fakeQUsb2Snes{ln: ln, mem: mem}
+	go f.serve()
+
+	port := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+	client := NewClient("127.0.0.1", port)
+	if client.ConnectEmulator() != emulator.Connected {
+		t.Fatalf("client did not connect")
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+func (f *fakeQUsb2Snes) serve() {
+	for {
+		conn, err := f.ln.AcceptTCP()
+		if err != nil {
+			return
+		}
+		go f.serveConn(conn)
+	}
+}
+
+func (f *fakeQUsb2Snes) serveConn(conn *net.TCPConn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	req, err := http.ReadRequest(r)
+	if err != nil {
+		return
+	}
+	sum := sha1.Sum([]byte(req.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	_, err = io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: "+base64.StdEncoding.EncodeToString(sum[:])+"\r\n\r\n")
+	if err != nil {
+		return
+	}
+
+	var msg, reply, payload []byte
+	for {
+		if msg, err = readFrame(r, msg[:0]); err != nil {
+			return
+		}
+
+		binary, text := f.answer(payload[:0], msg)
+		payload = text
+		if len(text) == 0 {
+			continue
+		}
+
+		reply = appendFrame(reply[:0], binary, text)
+		if _, err := conn.Write(reply); err != nil {
+			return
+		}
+	}
+}
+
+// answer returns the reply to a JSON query, nothing for commands without
+// one.
+func (f *fakeQUsb2Snes) answer(reply, query []byte) (binary bool, _ []byte) {
+	opcode, _ := jsonStrings(query, "Opcode")
+	switch {
+	case bytes.Equal(opcode, []byte("AppVersion")):
+		return false, append(reply, `{"Results":["7.0.0"]}`...)
+	case bytes.Equal(opcode, []byte("DeviceList")):
+		return false, append(reply, `{"Results":["fake"]}`...)
+	case bytes.Equal(opcode, []byte("Info")):
+		return false, append(reply, `{"Results":["1.11.0","fake","/games/game.sfc"]}`...)
+	case bytes.Equal(opcode, []byte("GetAddress")):
+		// address and size pairs in hex
+		operands := query
+		for {
+			addr, rest := jsonStrings(operands, "")
+			size, rest := jsonStrings(rest, "")
+			if addr == nil || size == nil {
+				return true, reply
+			}
+			operands = rest
+
+			start, n := parseHex(addr)-wramBase, parseHex(size)
+			reply = append(reply, f.mem[start:start+n]...)
+		}
+	}
+
+	return false, reply
+}
+
+// jsonStrings returns the string value of key, or the next string in an
+// array when key is empty, and the JSON after it.
+func jsonStrings(data []byte, key string) ([]byte, []byte) {
+	if key != "" {
+		i := bytes.Index(data, []byte(`"`+key+`":`))
+		if i < 0 {
+			return nil, nil
+		}
+		data = data[i+len(key)+3:]
+	} else if i := bytes.Index(data, []byte(`"Operands":`)); i >= 0 {
+		data = data[i+len(`"Operands":`):]
+	}
+
+	start := bytes.IndexByte(data, '"')
+	if start < 0 {
+		return nil, nil
+	}
+	end := bytes.IndexByte(data[start+1:], '"')
+	if end < 0 {
+		return nil, nil
+	}
+	return data[start+1 : start+1+end], data[start+end+2:]
+}
+
+func parseHex(s []byte) int {
+	n := 0
+	for _, c := range s {
+		switch {
+		case c >= 'a':
+			n = n<<4 | int(c-'a'+10)
+		case c >= 'A':
+			n = n<<4 | int(c-'A'+10)
+		default:
+			n = n<<4 | int(c-'0')
+		}
+	}
+	return n
+}
+
+// readFrame appends the unmasked payload of the next client frame to dst.
+func readFrame(r *bufio.Reader, dst []byte) ([]byte, error) {
+	// the header is read a byte at a time, a buffer passed to io.ReadFull
+	// would be allocated
+	readUint := func(n int) (int, error) {
+		v := 0
+		for range n {
+			b, err := r.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+			v = v<<8 | int(b)
+		}
+		return v, nil
+	}
+
+	header, err := readUint(2)
+	if err != nil {
+		return nil, err
+	}
+	if header>>8&0x0F == 8 {
+		return nil, io.EOF
+	}
+
+	size := header & 0x7F
+	switch size {
+	case 126:
+		size, err = readUint(2)
+	case 127:
+		size, err = readUint(8)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	mask, err := readUint(4)
+	if err != nil {
+		return nil, err
+	}
+
+	start := len(dst)
+	dst = append(dst, make([]byte, size)...)
+	if _, err := io.ReadFull(r, dst[start:]); err != nil {
+		return nil, err
+	}
+	for i := range size {
+		dst[start+i] ^= byte(mask >> (24 - 8*(i&3)))
+	}
+	return dst, nil
+}
+
+// appendFrame appends an unmasked server frame of payload to dst.
+func appendFrame(dst []byte, binary bool, payload []byte) []byte {
+	opcode := byte(1)
+	if binary {
+		opcode = 2
+	}
+	dst = append(dst, 0x80|opcode)
+
+	switch n := len(payload); {
+	case n < 126:
+		dst = append(dst, byte(n))
+	case n <= 0xFFFF:
+		dst = append(dst, 126, byte(n>>8), byte(n))
+	default:
+		dst = append(dst, 127, 0, 0, 0, 0, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(dst, payload...)
+}
+
+func TestGetValues(t *testing.T) {
+	client := newFakeQUsb2Snes(t, emulatortest.WRAM())
+
+	vals, err := client.GetValues(client.CompileReadPlan(emulatortest.ReadPlan()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	emulatortest.CheckValues(t, vals)
+}
+
+func TestByteOrder(t *testing.T) {
+	plan := emulatortest.ByteOrderPlan("SNES", emulator.WRAM)
+	client := newFakeQUsb2Snes(t, emulatortest.ByteOrderMemory(plan, 0x100, false))
+
+	caps := client.Capabilities()
+	for _, w := range plan.Watches {
+		if got, want := caps.ByteOrder(plan, w), emulator.WatchEndian(plan, w); got != want {
+			t.Errorf("%s comes back %s, want %s", w.Name, got, want)
+		}
+	}
+
+	vals, err := client.GetValues(client.CompileReadPlan(plan))
+	if err != nil {
+		t.Fatal(err)
+	}
+	emulatortest.CheckByteOrder(t, vals)
+}
+
+func TestGetValuesAllocs(t *testing.T) {
+	client := newFakeQUsb2Snes(t, emulatortest.WRAM())
+	plan := client.CompileReadPlan(emulatortest.ReadPlan())
+
+	// the first read builds the query and sizes the buffers
+	if _, err := client.GetValues(plan); err != nil {
+		t.Fatal(err)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := client.GetValues(plan); err != nil {
+			t.Fatal(err)
+		}
+	})
+	// gorilla/websocket allocates a reader for every message received, the
+	// GetAddress reply is one message
+	const readerAllocs = 1
+	if allocs > readerAllocs {
+		t.Errorf("GetValues allocates %.1f times a tick besides the websocket reader, want 0", allocs-readerAllocs)
+	}
+}
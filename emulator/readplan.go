@@ -4,6 +4,8 @@ import (
 	"FactFinder/logger"
 	"fmt"
 	"io"
+	"math/bits"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -17,19 +19,48 @@ type ResolvedWatch struct {
 	Addr   int
 	Size   int
 	Offset int
+
+	buf valueBuffer
+	// the last decode failed, so the failure has been logged
+	undecodable bool
 }
 
 type MergedRegion struct {
 	Bank    Bank
+	Module  string
 	Start   int
 	Size    int
 	Watches []ResolvedWatch
 	Buffer  []byte
 }
 
+// UnsupportedWatch is a watch CompileReadPlan skipped and why.
+type UnsupportedWatch struct {
+	Spec ReadSpec
+	Err  error
+}
+
+func (u UnsupportedWatch) String() string {
+	return fmt.Sprintf("%s: %v", u.Spec.Name, u.Err)
+}
+
 type CompiledReadPlan struct {
-	Regions        []MergedRegion
-	PointerWatches []ReadSpec
+	Regions       []MergedRegion
+	PointerChains []PointerChain
+	// watches in banks the backend cannot read
+	Unsupported []UnsupportedWatch
+
+	plan    *ReadPlan
+	backend Backend
+	// banks translated for another backend, see CompileReadPlanBanks
+	overrides map[Bank]Backend
+	values    []Value
+	// process memory bases the regions and chains are placed at by module,
+	// "" for the signature base, see Rebase
+	bases   map[string]int
+	modules []string
+	// times the plan was compiled again in place, see Recompiled
+	generation int
 }
 
 type Bank string
@@ -37,44 +68,59 @@ type Bank string
 const (
 	WRAM          Bank = "wram"    // SNES/GB/GBC Memory
 	SRAM          Bank = "sram"    // SNES Save Memory
-	RAM           Bank = "ram"     // PSX/NES/Genesis Memory
+	RAM           Bank = "ram"     // PSX/NES/Genesis/SMS/GG/PCE/NGP/A2600 Memory
 	IWRAM         Bank = "iwram"   // GBA Internal Memory
 	EWRAM         Bank = "ewram"   // GBA External Memory
 	FCRAM         Bank = "fcram"   // 3DS Memory
 	PSRAM         Bank = "psram"   // DS Memory
 	RDRAM         Bank = "rdram"   // N64 Memory
 	ProcessMemory Bank = "process" // PC Memory
+	ROM           Bank = "rom"     // Cartridge ROM, by file offset
+	IRAM          Bank = "iram"    // SNES SA-1 Internal RAM
+	BWRAM         Bank = "bwram"   // SNES SA-1 Bitmap-Work RAM
+	HRAM          Bank = "hram"    // GB/GBC High RAM
+	CartRAM       Bank = "cartram" // GB/GBC Cartridge RAM, the mapped bank
+	PRGRAM        Bank = "prgram"  // NES Battery PRG-RAM
+	VRAM          Bank = "vram"    // Video Memory
+	OAM           Bank = "oam"     // Sprite Attribute Memory
+	HWRAM         Bank = "hwram"   // Saturn Work RAM High
+	LWRAM         Bank = "lwram"   // Saturn Work RAM Low
 )
 
+var Banks = []Bank{
+	WRAM,
+	SRAM,
+	RAM,
+	IWRAM,
+	EWRAM,
+	FCRAM,
+	PSRAM,
+	RDRAM,
+	ProcessMemory,
+	ROM,
+	IRAM,
+	BWRAM,
+	HRAM,
+	CartRAM,
+	PRGRAM,
+	VRAM,
+	OAM,
+	HWRAM,
+	LWRAM,
+}
+
 func (b *Bank) UnmarshalYAML(value *yaml.Node) error {
 	if value.Kind != yaml.ScalarNode {
 		return fmt.Errorf("expected scalar for bank")
 	}
 
-	switch strings.ToLower(strings.TrimSpace(value.Value)) {
-	case "wram":
-		*b = WRAM
-	case "sram":
-		*b = SRAM
-	case "ram":
-		*b = RAM
-	case "iwram":
-		*b = IWRAM
-	case "ewram":
-		*b = EWRAM
-	case "fcram":
-		*b = FCRAM
-	case "psram":
-		*b = PSRAM
-	case "rdram":
-		*b = RDRAM
-	case "process":
-		*b = ProcessMemory
-	default:
+	bank := Bank(strings.ToLower(strings.TrimSpace(value.Value)))
+	if !slices.Contains(Banks, bank) {
 		log.Error("unknown bank in yaml: %q", value.Value)
 		return fmt.Errorf("unknown bank: %q", value.Value)
 	}
 
+	*b = bank
 	return nil
 }
 
@@ -120,17 +166,93 @@ func (h *HexInt) UnmarshalYAML(value *yaml.Node) error {
 
 	s := strings.TrimSpace(value.Value)
 	s = strings.TrimPrefix(strings.ToLower(s), "0x")
+	s = strings.TrimPrefix(s, "$")
 
 	v, err := strconv.ParseInt(s, 16, 0)
 	if err != nil {
 		log.Error("invalid hex value: %q (%v)", value.Value, err)
-		return err
+		return fmt.Errorf("invalid hex value %q", value.Value)
 	}
 
 	*h = HexInt(v)
 	return nil
 }
 
+type Endian string
+
+var Endians = []Endian{LittleEndian, BigEndian, WordSwapped}
+
+const (
+	LittleEndian Endian = "little"
+	BigEndian    Endian = "big"
+	// WordSwapped is big-endian data stored as little-endian 32-bit words,
+	// how N64 RDRAM comes back from RetroArch cores.
+	WordSwapped Endian = "wordswapped"
+	// WordSwappedLittle is little-endian data stored as little-endian
+	// 32-bit words, how little-endian values in N64 RDRAM come back from
+	// RetroArch cores. Backends return it, plans do not declare it.
+	WordSwappedLittle Endian = "wordswappedlittle"
+)
+
+// Swapped reports whether e is stored as reversed 32-bit words, restored
+// with unswapWords before decoding.
+func (e Endian) Swapped() bool {
+	return e == WordSwapped || e == WordSwappedLittle
+}
+
+// Big reports whether e, once its words are restored, stores the most
+// significant byte first.
+func (e Endian) Big() bool {
+	return e == BigEndian || e == WordSwapped
+}
+
+// endianNames are the spellings of each byte order a read plan accepts,
+// in any case.
+var endianNames = map[string]Endian{
+	"little":       LittleEndian,
+	"le":           LittleEndian,
+	"big":          BigEndian,
+	"be":           BigEndian,
+	"wordswapped":  WordSwapped,
+	"word-swapped": WordSwapped,
+	"word_swapped": WordSwapped,
+	"swapped":      WordSwapped,
+}
+
+func (e *Endian) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.ScalarNode {
+		return fmt.Errorf("expected scalar for endian")
+	}
+
+	endian, ok := endianNames[strings.ToLower(strings.TrimSpace(value.Value))]
+	if !ok {
+		log.Error("unknown endian in yaml: %q", value.Value)
+		return fmt.Errorf("unknown endian: %q", value.Value)
+	}
+
+	*e = endian
+	return nil
+}
+
+// DefaultEndian returns the byte order of a platform's memory.
+func DefaultEndian(platform string) Endian {
+	if p, ok := LookupPlatform(platform); ok {
+		return p.Endian
+	}
+
+	return LittleEndian
+}
+
+// WatchEndian returns the byte order of a watch, falling back to the
+// platform default when the watch does not override it.
+func WatchEndian(plan *ReadPlan, spec ReadSpec) Endian {
+	if spec.Endian != "" {
+		return spec.Endian
+	}
+
+	return DefaultEndian(plan.Platform)
+}
+
 type Signal string
 
 const (
@@ -140,15 +262,64 @@ const (
 	Edge    Signal = "edge"
 )
 
+var SignalNames = []Signal{Rising, Falling, Delta, Edge}
+
+// Signals accepts either a single signal or a list of them.
+type Signals []Signal
+
+func (s *Signals) UnmarshalYAML(value *yaml.Node) error {
+	var names []string
+
+	switch value.Kind {
+	case yaml.ScalarNode:
+		names = []string{value.Value}
+	case yaml.SequenceNode:
+		if err := value.Decode(&names); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("expected scalar or list for signal")
+	}
+
+	out := make(Signals, 0, len(names))
+	for _, name := range names {
+		sig := Signal(strings.ToLower(strings.TrimSpace(name)))
+		if !slices.Contains(SignalNames, sig) {
+			log.Error("unknown signal in yaml: %q", name)
+			return fmt.Errorf("unknown signal: %q", name)
+		}
+		out = append(out, sig)
+	}
+
+	*s = out
+	return nil
+}
+
 type ReadSpec struct {
-	Name         string    `yaml:"name"`
-	Address      HexInt    `yaml:"address"`
-	Offsets      []HexInt  `yaml:"offsets,omitempty"`
-	Type         ValueType `yaml:"type"`
-	Bank         Bank      `yaml:"bank,omitempty"`
-	SizeOverride int       `yaml:"size,omitempty"`
-	StringLength int       `yaml:"stringLength,omitempty"`
-	Mask         HexInt    `yaml:"mask,omitempty"`
+	Name         string     `yaml:"name"`
+	Address      HexInt     `yaml:"address"`
+	Offsets      []HexInt   `yaml:"offsets,omitempty"`
+	Type         ValueType  `yaml:"type"`
+	Bank         Bank       `yaml:"bank,omitempty"`
+	Module       string     `yaml:"module,omitempty"`
+	SizeOverride int        `yaml:"size,omitempty"`
+	StringLength int        `yaml:"stringLength,omitempty"`
+	Mask         HexInt     `yaml:"mask,omitempty"`
+	Shift        int        `yaml:"shift,omitempty"`
+	Bit          *int       `yaml:"bit,omitempty"`
+	PointerSize  int        `yaml:"pointerSize,omitempty"`
+	Endian       Endian     `yaml:"endian,omitempty"`
+	Digits       int        `yaml:"digits,omitempty"`
+	FracBits     int        `yaml:"fracBits,omitempty"`
+	Signals      Signals    `yaml:"signal,omitempty"`
+	Count        int        `yaml:"count,omitempty"`
+	Stride       HexInt     `yaml:"stride,omitempty"`
+	Fields       []ReadSpec `yaml:"fields,omitempty"`
+
+	// set on the watches an array expands into
+	Array string `yaml:"-"`
+	Index int    `yaml:"-"`
+	Field string `yaml:"-"`
 }
 
 func (r ReadSpec) Size() int {
@@ -157,49 +328,176 @@ func (r ReadSpec) Size() int {
 	}
 
 	switch r.Type {
-	case I8, U8, Bool:
+	case I8, U8:
 		return 1
 	case I16, U16:
 		return 2
-	case F32, I32, U32:
+	case I24, U24:
+		return 3
+	case F32, I32, U32, Fixed, UFixed:
 		return 4
 	case F64, I64, U64:
 		return 8
+	case String:
+		return r.StringLength
+	case UTF16LE:
+		return r.StringLength * 2
+	case BCD:
+		// two digits per byte
+		return max(1, (r.Digits+1)/2)
+	case Bool, FlagCount:
+		// wide enough to cover the mask or bit, one byte without either
+		n := bits.Len64(uint64(r.Mask))
+		if r.Bit != nil {
+			n = max(n, *r.Bit+1)
+		}
+
+		switch n = (n + 7) / 8; {
+		case n <= 1:
+			return 1
+		case n <= 2:
+			return 2
+		case n <= 4:
+			return 4
+		default:
+			return 8
+		}
 	default:
 		return 0
 	}
 }
 
+// FractionBits returns the fractional bit count of a fixed-point watch,
+// half of its width when fracBits is not set (8.8, 16.16).
+func (r ReadSpec) FractionBits() int {
+	if r.FracBits > 0 {
+		return r.FracBits
+	}
+
+	return r.Size() * 4
+}
+
+// Signature finds the base of process watches in a game whose addresses
+// move between patches. Pattern is searched in the process, the base is the
+// match plus offset, past the 32-bit displacement stored there when
+// ripRelative is set, read as a pointer of derefSize bytes (8 unless set)
+// when deref is set, plus resultOffset. Process watch addresses are then
+// relative to the base.
 type Signature struct {
 	Pattern      string `yaml:"pattern,omitempty"`
 	ScanOffset   int    `yaml:"offset,omitempty"`
+	RIPRelative  bool   `yaml:"ripRelative,omitempty"`
 	Deref        bool   `yaml:"deref,omitempty"`
+	DerefSize    int    `yaml:"derefSize,omitempty"`
 	ResultOffset int    `yaml:"resultOffset,omitempty"`
 }
 
+// FileList accepts either a single file name or a list of them.
+type FileList []string
+
+func (f *FileList) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		*f = FileList{value.Value}
+		return nil
+	case yaml.SequenceNode:
+		var names []string
+		if err := value.Decode(&names); err != nil {
+			return err
+		}
+		*f = names
+		return nil
+	}
+
+	return fmt.Errorf("expected scalar or list for file names")
+}
+
 type ReadPlan struct {
-	Name             string     `yaml:"Name"`
-	ProcessName      string     `yaml:"ProcessName,omitempty"`
-	ProcessSignature Signature  `yaml:",inline"`
-	ReadInterval     int64      `yaml:"ReadInterval"`
-	HiROM            bool       `yaml:"HiROM"`
-	Watches          []ReadSpec `yaml:"Watches"`
-	Platform         string     `yaml:"Platform"`
+	// base plans, resolved by LoadReadPlan and empty once loaded
+	Extends FileList `yaml:"Extends,omitempty"`
+	Include FileList `yaml:"Include,omitempty"`
+
+	Name             string       `yaml:"Name"`
+	ProcessName      string       `yaml:"ProcessName,omitempty"`
+	ProcessSignature Signature    `yaml:",inline"`
+	ReadInterval     int64        `yaml:"ReadInterval"`
+	HiROM            bool         `yaml:"HiROM"`
+	SA1              bool         `yaml:"SA1"`
+	Watches          []ReadSpec   `yaml:"Watches"`
+	Platform         string       `yaml:"Platform"`
+	Versions         []RomVersion `yaml:"Versions,omitempty"`
+	Match            []GameMatch  `yaml:"Match,omitempty"`
+
+	// name of the detected RomVersion this plan was built for
+	Version string `yaml:"-"`
+}
+
+var Platforms = platformNames()
+
+func platformNames() []string {
+	names := make([]string, len(MemoryMaps))
+	for i, p := range MemoryMaps {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// DefaultBank returns the bank watches use when they do not name one.
+func DefaultBank(platform string) Bank {
+	if p, ok := LookupPlatform(platform); ok {
+		return p.DefaultBank
+	}
+
+	return ""
 }
 
+// DefaultBank returns the bank of watches that name none, process memory
+// for plans that read a process instead of a platform.
+func (p *ReadPlan) DefaultBank() Bank {
+	if p.Platform == "" && p.ProcessName != "" {
+		return ProcessMemory
+	}
+	return DefaultBank(p.Platform)
+}
+
+// NewReadPlan loads a single read plan. Plans that use Extends or Include
+// must be loaded with LoadReadPlan.
 func NewReadPlan(reader io.Reader) (*ReadPlan, error) {
-	rp := ReadPlan{}
 	rawYaml, err := io.ReadAll(reader)
 	if err != nil {
 		log.Error("failed to read readplan input: %v", err)
 		return nil, err
 	}
 
-	err = yaml.Unmarshal(rawYaml, &rp)
+	l := &planLoader{files: make(map[*yaml.Node]string)}
+
+	root, diags := l.parse("", rawYaml, nil)
+	if root != nil {
+		diags = append(diags, validatePlan(root, l.files)...)
+	}
+
+	if HasErrors(diags) {
+		err := &ValidationError{Diagnostics: diags}
+		log.Error("invalid readplan: %v", err)
+		return nil, err
+	}
+	for _, d := range diags {
+		log.Warn("readplan: %v", d)
+	}
+
+	return decodeReadPlan(root, l.files)
+}
+
+func decodeReadPlan(root *yaml.Node, files map[*yaml.Node]string) (*ReadPlan, error) {
+	rp := ReadPlan{}
+
+	err := root.Decode(&rp)
 	if err != nil {
 		log.Error("failed to parse readplan yaml: %v", err)
 		return nil, err
 	}
+	rp.Extends = nil
+	rp.Include = nil
 
 	log.Info("loaded readplan: %s (%d watches, interval=%dms)",
 		rp.Name,
@@ -207,41 +505,55 @@ func NewReadPlan(reader io.Reader) (*ReadPlan, error) {
 		rp.ReadInterval,
 	)
 
-	for i := range rp.Watches {
-		if rp.Watches[i].Bank == "" {
-			log.Debug("defaulting bank for watch %s (platform=%s)",
-				rp.Watches[i].Name,
-				rp.Platform,
-			)
-			switch rp.Platform {
-			case "SNES":
-				rp.Watches[i].Bank = WRAM
-			case "GB":
-				rp.Watches[i].Bank = WRAM
-			case "GBC":
-				rp.Watches[i].Bank = WRAM
-			case "PSX":
-				rp.Watches[i].Bank = RAM
-			case "NES":
-				rp.Watches[i].Bank = RAM
-			case "Genesis":
-				rp.Watches[i].Bank = RAM
-			case "GBA":
-				rp.Watches[i].Bank = IWRAM
-			case "3DS":
-				rp.Watches[i].Bank = FCRAM
-			case "DS":
-				rp.Watches[i].Bank = PSRAM
-			case "N64":
-				rp.Watches[i].Bank = RDRAM
+	defaultBanks(&rp)
+
+	if versions := mappingValue(root, "Versions"); versions != nil {
+		for i, item := range versions.Content {
+			variant := ReadPlan{}
+			if err := variantNode(files, root, item).Decode(&variant); err != nil {
+				log.Error("failed to parse version %s: %v", rp.Versions[i].Name, err)
+				return nil, err
 			}
+
+			variant.Versions = nil
+			variant.Version = rp.Versions[i].Name
+			defaultBanks(&variant)
+
+			rp.Versions[i].plan = &variant
 		}
 	}
 
-	log.Debug("readplan summary: platform=%s hirom=%v watches=%d",
+	log.Debug("readplan summary: platform=%s hirom=%v watches=%d versions=%d",
 		rp.Platform,
 		rp.HiROM,
 		len(rp.Watches),
+		len(rp.Versions),
 	)
 	return &rp, nil
 }
+
+// defaultBanks converts bus addresses to bank addresses and fills in the
+// platform's default bank.
+func defaultBanks(rp *ReadPlan) {
+	for i := range rp.Watches {
+		spec := &rp.Watches[i]
+		if bank, addr, ok := NormalizeAddress(rp, spec.Bank, int(spec.Address)); ok {
+			log.Debug("watch %s: bus address $%X is %s $%X",
+				spec.Name,
+				int(spec.Address),
+				bank,
+				addr,
+			)
+			spec.Bank = bank
+			spec.Address = HexInt(addr)
+		}
+
+		if rp.Watches[i].Bank == "" {
+			log.Debug("defaulting bank for watch %s (platform=%s)",
+				rp.Watches[i].Name,
+				rp.Platform,
+			)
+			rp.Watches[i].Bank = rp.DefaultBank()
+		}
+	}
+}
@@ -3,24 +3,68 @@ package retroarch
 import (
 	"FactFinder/emulator"
 	"FactFinder/logger"
+	"bytes"
+	"errors"
 	"fmt"
 	"net"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
 var log = logger.Module("emulator/retroarch/client").SetLevel(logger.InfoLevel)
 
-const wramOffset = 0x7e0000
-const iwramOffset = 0x19000
-const ewramOffset = 0x21000
-const fcramOffset = 0x20000000
-const psramOffset = 0x02000000
-const psxRAMOffset = 0x010000
+const maxReadSize = 4096
 
-// const maxGap = 16
-// const maxReadSize = 4096
+// READ_CORE_MEMORY <address> followed by " XX" per byte
+const maxReplySize = len("READ_CORE_MEMORY ") + 16 + 3*maxReadSize
+
+// Memory is read with READ_CORE_MEMORY through the core's memory map. Cores
+// without one only answer READ_MEMORY, which reads the rcheevos address
+// space instead.
+const (
+	readCoreMemory = "READ_CORE_MEMORY"
+	readMemory     = "READ_MEMORY"
+)
+
+// replyTimeout is how long replies are waited for once requests are sent.
+const replyTimeout = 500 * time.Millisecond
+
+// maxInFlight bounds the reads sent before their replies are collected, so
+// the replies fit in the socket's receive buffer.
+const maxInFlight = 16
+
+var capabilities = emulator.Capabilities{
+	MaxReadSize:  maxReadSize,
+	PreferredGap: 16,
+	Banks: []emulator.Bank{
+		emulator.WRAM,
+		emulator.SRAM,
+		emulator.RAM,
+		emulator.IWRAM,
+		emulator.EWRAM,
+		emulator.FCRAM,
+		emulator.PSRAM,
+		emulator.RDRAM,
+		emulator.ROM,
+		emulator.IRAM,
+		emulator.BWRAM,
+		emulator.HRAM,
+		emulator.CartRAM,
+		emulator.PRGRAM,
+		emulator.VRAM,
+		emulator.OAM,
+		emulator.HWRAM,
+		emulator.LWRAM,
+	},
+	// a UDP round trip, bytes cost three characters of hex
+	LatencyCost: 128,
+	// a READ_CORE_MEMORY command and the address heading its reply
+	RegionCost:   16,
+	NativeEndian: nativeEndian,
+}
 
 type Client struct {
 	m                 sync.Mutex
@@ -32,13 +76,39 @@ type Client struct {
 	respBuf []byte
 	byteBuf []byte
 	cmdBuf  []byte
+
+	// reads of the current exchange, reused every tick
+	requests []request
+	// a read timed out, its reply may still arrive
+	timedOut bool
+
+	// RetroArch version, and the core system and content GET_STATUS last
+	// reported
+	version string
+	system  string
+	content string
+	// memory map of the running core by platform, chosen again when the
+	// core or content changes
+	profiles map[string]emulator.Backend
+	// banks read with READ_MEMORY, the core's memory map lacks them
+	fallback map[emulator.Bank]bool
+}
+
+// request is one read in flight.
+type request struct {
+	bank emulator.Bank
+	cmd  string
+	addr int
+	dst  []byte
+	done bool
+	err  error
 }
 
 func NewClient(host, port string) *Client {
 	addr, _ := net.ResolveUDPAddr("udp", host+":"+port)
 	return &Client{
 		addr:    addr,
-		respBuf: make([]byte, 4096),
+		respBuf: make([]byte, maxReplySize),
 		byteBuf: make([]byte, 0, 16),
 		cmdBuf:  make([]byte, 0, 64),
 	}
@@ -61,6 +131,11 @@ func (c *Client) ConnectEmulator() emulator.ConnectionStatus {
 	}
 	c.conn = conn
 
+	// room for every reply of a batch of reads
+	if err := c.conn.SetReadBuffer(maxInFlight * maxReplySize); err != nil {
+		log.Warn("failed to grow UDP receive buffer: %v", err)
+	}
+
 	_, err = c.conn.Write([]byte("VERSION"))
 	if err != nil {
 		log.Debug("VERSION request failed: %v", err)
@@ -80,7 +155,13 @@ func (c *Client) ConnectEmulator() emulator.ConnectionStatus {
 		return emulator.Disconnected
 	}
 
-	log.Info("retroarch handshake completed")
+	c.m.Lock()
+	c.version = strings.TrimSpace(string(c.respBuf[:n]))
+	c.system, c.content = "", ""
+	c.coreChanged()
+	c.m.Unlock()
+
+	log.Info("retroarch %s handshake completed", c.version)
 
 	if n > 0 {
 		_ = c.conn.SetReadDeadline(time.Time{})
@@ -106,9 +187,10 @@ func (c *Client) Close() error {
 	return c.conn.Close()
 }
 
-func (c *Client) buildReadCoreMemoryCmd(address int, size int) []byte {
+func (c *Client) buildReadCmd(cmd string, address int, size int) []byte {
 	c.cmdBuf = c.cmdBuf[:0]
-	c.cmdBuf = append(c.cmdBuf, "READ_CORE_MEMORY "...)
+	c.cmdBuf = append(c.cmdBuf, cmd...)
+	c.cmdBuf = append(c.cmdBuf, ' ')
 	c.cmdBuf = appendHexUpper(c.cmdBuf, uint64(address))
 	c.cmdBuf = append(c.cmdBuf, ' ')
 	c.cmdBuf = strconv.AppendInt(c.cmdBuf, int64(size), 10)
@@ -125,116 +207,471 @@ func (c *Client) GameConnected() bool {
 	return c.gameConnected
 }
 
+// GameInfo asks GET_STATUS for the running content, answered as
+// GET_STATUS PLAYING super_nes,Super Mario World,crc32=b19ed489
+// A different core system or content has the memory map chosen again.
+func (c *Client) GameInfo() (*emulator.GameInfo, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	reply, err := c.exchange([]byte("GET_STATUS"), "GET_STATUS")
+	if err != nil {
+		return nil, err
+	}
+
+	system, game, err := parseStatus(string(reply))
+
+	content := ""
+	if game != nil {
+		content = game.Name
+	}
+	if system != c.system || content != c.content {
+		log.Info("core system=%q content=%q", system, content)
+		c.system, c.content = system, content
+		c.coreChanged()
+	}
+
+	return game, err
+}
+
+// coreChanged forgets the memory map chosen for the last core, plans are
+// compiled again for the new one on their next read. Callers hold c.m.
+func (c *Client) coreChanged() {
+	clear(c.profiles)
+	if len(c.fallback) > 0 {
+		log.Info("reading every bank with READ_CORE_MEMORY again")
+		clear(c.fallback)
+	}
+}
+
+// parseStatus returns the system id of the core and the game it runs.
+func parseStatus(reply string) (string, *emulator.GameInfo, error) {
+	fields, ok := strings.CutPrefix(strings.TrimSpace(reply), "GET_STATUS ")
+	if !ok {
+		return "", nil, fmt.Errorf("unexpected GET_STATUS reply %q", reply)
+	}
+
+	status, content, _ := strings.Cut(fields, " ")
+	if status == "CONTENTLESS" || content == "" {
+		return "", nil, emulator.ErrGameNotLoaded
+	}
+
+	// system id first, crc last, the name may contain commas
+	system, content, _ := strings.Cut(content, ",")
+
+	game := &emulator.GameInfo{Name: content}
+
+	if i := strings.LastIndex(content, ",crc32="); i >= 0 {
+		game.Name = content[:i]
+		crc, err := strconv.ParseUint(content[i+len(",crc32="):], 16, 32)
+		if err == nil {
+			game.CRC = uint32(crc)
+		}
+	}
+
+	return system, game, nil
+}
+
+func (c *Client) Capabilities() emulator.Capabilities {
+	return capabilities
+}
+
+// CompileReadPlan compiles plan for the memory map of the running core.
 func (c *Client) CompileReadPlan(
 	plan *emulator.ReadPlan,
 ) *emulator.CompiledReadPlan {
-	return emulator.CompileReadPlan(
-		plan,
-		emulator.ResolveAddress,
-		retroarchAddress,
-	)
+	return c.compile(plan, c.detectCore(plan))
 }
 
-func retroarchAddress(
+func (c *Client) compile(
 	plan *emulator.ReadPlan,
-	spec emulator.ReadSpec,
-	addr int,
-) int {
-	switch spec.Bank {
-	case emulator.WRAM:
-		// GB & GBC 0xC000-0xDFFF
-		return addr + wramOffset
+	backend emulator.Backend,
+) *emulator.CompiledReadPlan {
+	c.m.Lock()
+	overrides := make(map[emulator.Bank]emulator.Backend, len(c.fallback))
+	for bank := range c.fallback {
+		overrides[bank] = emulator.RCheevos
+	}
+	c.m.Unlock()
 
-	case emulator.IWRAM:
-		// GBA 0x19000 – 0x20FFF
-		return addr + iwramOffset
+	return emulator.CompileReadPlanBanks(plan, capabilities, backend, overrides)
+}
+
+// nativeEndian is the byte order a core exposes a watch in. N64 cores keep
+// RDRAM as host-endian 32-bit words, so big-endian values come back
+// word-swapped, word-swapped values big-endian and little-endian values in
+// swapped words too.
+func nativeEndian(plan *emulator.ReadPlan, spec emulator.ReadSpec) emulator.Endian {
+	endian := emulator.WatchEndian(plan, spec)
+	if plan.Platform != "N64" || spec.Bank != emulator.RDRAM {
+		return endian
+	}
+
+	switch endian {
+	case emulator.BigEndian:
+		return emulator.WordSwapped
+	case emulator.WordSwapped:
+		return emulator.BigEndian
+	case emulator.LittleEndian:
+		return emulator.WordSwappedLittle
+	}
+	return endian
+}
 
-	case emulator.EWRAM:
-		// GBA 0x21000 – 0x60FFF
-		return addr + ewramOffset
+func (c *Client) GetValues(plan *emulator.CompiledReadPlan) ([]emulator.Value, error) {
+	// a core switch keeps the plan, so it is compiled again in place. The
+	// new core may have the memory map the last one lacked.
+	if backend := c.detectCore(plan.Plan()); c.stale(plan, backend) {
+		plan.Recompiled(c.compile(plan.Plan(), backend))
+	}
 
-	case emulator.FCRAM:
-		// 3DS 0x20000000-0x28000000
-		return addr + fcramOffset
+	vals, err := c.readValues(plan)
+	if err != nil && c.fallBack(plan) {
+		vals, err = c.readValues(plan)
+	}
 
-	case emulator.PSRAM:
-		// DeSmuME 0x02000000-0x02400000
-		// MelonDS 0x00000000-0x00400000
-		return addr + psramOffset
+	return vals, err
+}
 
-	case emulator.RAM:
-		if plan.Platform == "PSX" {
+// stale reports whether plan was compiled for another memory map than
+// backend, or other banks read with READ_MEMORY.
+func (c *Client) stale(plan *emulator.CompiledReadPlan, backend emulator.Backend) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
 
-			// PSX 0x010000-0x200000
-			return addr + psxRAMOffset
+	compiledFor := func(bank emulator.Bank) bool {
+		if c.fallback[bank] {
+			return plan.Backend(bank) == emulator.RCheevos
 		}
+		return plan.Backend(bank) == backend
 	}
 
-	// NES 0x0000-0x07FF
-	// Genesis 0xFF0000-0xFFFFFF
-	// 0x00000000 – 0x003FFFFF No expansion pack
-	// 0x00000000 – 0x007FFFFF With expansion pack
-	return addr
+	for i := range plan.Regions {
+		if !compiledFor(plan.Regions[i].Bank) {
+			return true
+		}
+	}
+	for i := range plan.PointerChains {
+		if !compiledFor(plan.PointerChains[i].Spec.Bank) {
+			return true
+		}
+	}
+
+	return false
 }
 
-func (c *Client) GetValues(plan *emulator.CompiledReadPlan) ([]emulator.Value, error) {
-	vals := make([]emulator.Value, 0)
+func (c *Client) readValues(plan *emulator.CompiledReadPlan) ([]emulator.Value, error) {
+	vals := plan.Values()
 
 	log.Debug("retroarch read cycle: regions=%d", len(plan.Regions))
 
-	for _, region := range plan.Regions {
-		msg := c.buildReadCoreMemoryCmd(
-			region.Start,
-			region.Size,
-		)
+	// every region is requested before any reply is waited for
+	c.requests = c.requests[:0]
+	for i := range plan.Regions {
+		region := &plan.Regions[i]
+		c.requests = c.appendRequests(c.requests, region.Bank, region.Start, region.Buffer)
+	}
+
+	if err := c.read(c.requests); err != nil {
+		return nil, err
+	}
+
+	for i := range plan.Regions {
+		vals = emulator.DecodeRegion(&plan.Regions[i], vals)
+	}
+
+	vals, err := plan.ResolvePointerChains(c.readMemory, vals)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug("retroarch read cycle completed: values=%d", len(vals))
+	return vals, nil
+}
+
+// readMemory reads len(dst) bytes at a mapped core memory address.
+func (c *Client) readMemory(bank emulator.Bank, addr int, dst []byte) error {
+	c.requests = c.appendRequests(c.requests[:0], bank, addr, dst)
+	return c.read(c.requests)
+}
+
+// appendRequests splits a read into pieces small enough for one reply
+// datagram, read with the command of the bank.
+func (c *Client) appendRequests(reqs []request, bank emulator.Bank, addr int, dst []byte) []request {
+	c.m.Lock()
+	cmd := readCoreMemory
+	if c.fallback[bank] {
+		cmd = readMemory
+	}
+	c.m.Unlock()
+
+	for len(dst) > maxReadSize {
+		reqs = append(reqs, request{bank: bank, cmd: cmd, addr: addr, dst: dst[:maxReadSize]})
+		addr += maxReadSize
+		dst = dst[maxReadSize:]
+	}
+
+	return append(reqs, request{bank: bank, cmd: cmd, addr: addr, dst: dst})
+}
+
+// fallBack switches the banks whose last READ_CORE_MEMORY failed to
+// READ_MEMORY, when READ_MEMORY can read them, and compiles plan again in
+// place. It reports whether any bank switched.
+func (c *Client) fallBack(plan *emulator.CompiledReadPlan) bool {
+	source := plan.Plan()
+
+	var failed []emulator.Bank
+	for _, req := range c.requests {
+		if req.err != nil && req.cmd == readCoreMemory && req.bank != "" && !slices.Contains(failed, req.bank) {
+			failed = append(failed, req.bank)
+		}
+	}
+
+	switched := false
+	for _, bank := range failed {
+		if !c.probeReadMemory(source, bank) {
+			continue
+		}
+
+		log.Info("the core has no memory map for %s %s, reading it with READ_MEMORY", source.Platform, bank)
 
 		c.m.Lock()
+		if c.fallback == nil {
+			c.fallback = make(map[emulator.Bank]bool)
+		}
+		c.fallback[bank] = true
+		c.m.Unlock()
+
+		switched = true
+	}
 
-		log.Debug("reading region start=0x%x size=%d", region.Start, region.Size)
+	if switched {
+		plan.Recompiled(c.compile(source, c.detectCore(source)))
+	}
+	return switched
+}
+
+// probeReadMemory reports whether READ_MEMORY reads the first byte of bank.
+func (c *Client) probeReadMemory(plan *emulator.ReadPlan, bank emulator.Bank) bool {
+	m, ok := emulator.LookupBank(plan.Platform, bank)
+	if !ok {
+		return false
+	}
 
-		_, err := c.conn.Write(msg)
+	// banks outside the rcheevos address space have no translation
+	addr, _, err := emulator.Translate(plan, emulator.RCheevos, emulator.ReadSpec{
+		Bank:    bank,
+		Address: emulator.HexInt(m.Start),
+	})
+	if err != nil {
+		return false
+	}
+
+	var b [1]byte
+	return c.read([]request{{bank: bank, cmd: readMemory, addr: addr, dst: b[:]}}) == nil
+}
+
+// read sends reqs, up to maxInFlight at a time, and fills each from the
+// reply that echoes its address. Replies nothing is waiting for, answers
+// to reads that timed out and duplicates, are dropped.
+func (c *Client) read(reqs []request) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.readLocked(reqs)
+}
+
+// readLocked is read for callers holding c.m.
+func (c *Client) readLocked(reqs []request) error {
+	c.discardStale()
+
+	for len(reqs) > 0 {
+		batch := reqs[:min(len(reqs), maxInFlight)]
+		reqs = reqs[len(batch):]
+
+		if err := c.readBatch(batch); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) readBatch(batch []request) error {
+	for i := range batch {
+		req := &batch[i]
+		req.done, req.err = false, nil
+
+		if log.DebugEnabled() {
+			log.Debug("%s start=0x%x size=%d", req.cmd, req.addr, len(req.dst))
+		}
+
+		_, err := c.conn.Write(c.buildReadCmd(req.cmd, req.addr, len(req.dst)))
 		if err != nil {
-			c.m.Unlock()
 			log.Error("UDP write failed: %v", err)
-			return nil, err
+			return err
 		}
+	}
 
-		_ = c.conn.SetReadDeadline(
-			time.Now().Add(500 * time.Millisecond),
-		)
+	_ = c.conn.SetReadDeadline(time.Now().Add(replyTimeout))
 
+	for pending := len(batch); pending > 0; {
 		n, err := c.conn.Read(c.respBuf)
+		if err != nil {
+			log.Error("UDP read failed with %d of %d replies missing: %v", pending, len(batch), err)
+			c.timedOut = true
+			return err
+		}
+		reply := c.respBuf[:n]
 
-		c.m.Unlock()
+		req := matchRequest(batch, reply)
+		if req == nil {
+			log.Debug("discarding unexpected reply %.40q", reply)
+			continue
+		}
+		req.done = true
+		pending--
+
+		// the other replies are read regardless, they are already on the way
+		req.err = decodeRetroArchReadCoreMemoryBytes(reply, req.dst, len(req.dst))
+
+		// without content every read fails, "no memory map defined" among
+		// others, the game is not loaded rather than the address unmapped
+		if errors.Is(req.err, emulator.ErrAddressUnmapped) && c.content == "" {
+			req.err = fmt.Errorf("%w: %v", emulator.ErrGameNotLoaded, req.err)
+		}
+	}
+
+	for _, req := range batch {
+		if req.err != nil {
+			if !errors.Is(req.err, emulator.ErrGameNotLoaded) {
+				log.Error("%s start=0x%x size=%d failed: %v", req.cmd, req.addr, len(req.dst), req.err)
+			}
+			return req.err
+		}
+	}
 
+	return nil
+}
+
+// matchRequest returns the request a read reply answers, nil when it
+// answers none or one that is already answered.
+func matchRequest(batch []request, reply []byte) *request {
+	for i := range batch {
+		req := &batch[i]
+		if addr, ok := replyAddress(reply, req.cmd); ok && addr == req.addr && !req.done {
+			return req
+		}
+	}
+	return nil
+}
+
+// discardStale drops the replies queued since a read timed out, so a late
+// reply is not taken for the answer to a new read of the same address.
+func (c *Client) discardStale() {
+	if !c.timedOut {
+		return
+	}
+	c.timedOut = false
+
+	_ = c.conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	for {
+		n, err := c.conn.Read(c.respBuf)
 		if err != nil {
-			log.Error("UDP read failed: %v", err)
-			return nil, err
+			return
 		}
+		log.Debug("discarding stale reply %.40q", c.respBuf[:n])
+	}
+}
+
+// exchange sends msg and returns the first reply to cmd, dropping any
+// other. The reply shares respBuf, callers hold c.m.
+func (c *Client) exchange(msg []byte, cmd string) ([]byte, error) {
+	c.discardStale()
+
+	_, err := c.conn.Write(msg)
+	if err != nil {
+		log.Error("UDP write failed: %v", err)
+		return nil, err
+	}
+
+	_ = c.conn.SetReadDeadline(time.Now().Add(replyTimeout))
 
-		err = decodeRetroArchReadCoreMemoryBytes(
-			c.respBuf[:n],
-			region.Buffer,
-			region.Size,
-		)
+	for {
+		n, err := c.conn.Read(c.respBuf)
 		if err != nil {
-			log.Error("decode failed for region start=0x%x size=%d", region.Start, region.Size)
+			log.Error("UDP read failed: %v", err)
+			c.timedOut = true
 			return nil, err
 		}
 
-		for _, watch := range region.Watches {
-			raw := region.Buffer[watch.Offset : watch.Offset+watch.Size]
+		reply := c.respBuf[:n]
+		if rest, ok := bytes.CutPrefix(reply, []byte(cmd)); ok && (len(rest) == 0 || rest[0] == ' ') {
+			return reply, nil
+		}
+		log.Debug("discarding unexpected reply %.40q", reply)
+	}
+}
 
-			val := emulator.DecodeValue(watch.Spec, raw)
+// WriteValue writes v with WRITE_CORE_MEMORY, answered as
+// WRITE_CORE_MEMORY <address> <bytes written>, or -1 and a reason. Banks
+// read with READ_MEMORY are written with WRITE_MEMORY. RetroArch refuses
+// writes while achievements run in hardcore mode. Bit fields are read with
+// the bank's read command first.
+func (c *Client) WriteValue(plan *emulator.ReadPlan, spec emulator.ReadSpec, v emulator.Value) error {
+	spec.Endian = nativeEndian(plan, spec)
+	backend := c.detectCore(plan)
 
-			if val == nil {
-				return nil, fmt.Errorf("unsupported size %d", watch.Size)
-			}
+	c.m.Lock()
+	defer c.m.Unlock()
 
-			vals = append(vals, *val)
+	cmd, readCmd := "WRITE_CORE_MEMORY", readCoreMemory
+	if c.fallback[spec.Bank] {
+		cmd, readCmd, backend = "WRITE_MEMORY", readMemory, emulator.RCheevos
+	}
+
+	readBack := func(r emulator.Write) error {
+		return c.readLocked([]request{{bank: spec.Bank, cmd: readCmd, addr: r.Addr, dst: r.Data}})
+	}
+
+	writes, err := emulator.PrepareWrite(plan, backend, spec, v, readBack)
+	if err != nil {
+		return err
+	}
+
+	for _, w := range writes {
+		if err := c.writeMemory(cmd, w); err != nil {
+			return err
 		}
+		log.Debug("wrote %s: %d bytes at 0x%X", spec.Name, len(w.Data), w.Addr)
 	}
 
-	log.Debug("retroarch read cycle completed: values=%d", len(vals))
-	return vals, nil
+	return nil
+}
+
+// writeMemory sends one write with cmd. Callers hold c.m.
+func (c *Client) writeMemory(cmd string, w emulator.Write) error {
+	msg := append(c.cmdBuf[:0], cmd...)
+	msg = append(msg, ' ')
+	msg = appendHexUpper(msg, uint64(w.Addr))
+	for _, b := range w.Data {
+		msg = append(msg, ' ')
+		msg = appendHexByte(msg, b)
+	}
+	c.cmdBuf = msg
+
+	reply, err := c.exchange(msg, cmd)
+	if err != nil {
+		return err
+	}
+
+	fields := strings.Fields(string(reply))
+	if len(fields) < 3 {
+		return fmt.Errorf("unexpected %s reply %q", cmd, reply)
+	}
+	if fields[2] == "-1" {
+		return fmt.Errorf("%s rejected: %s", cmd, strings.Join(fields[3:], " "))
+	}
+
+	return nil
 }
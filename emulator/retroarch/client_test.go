@@ -0,0 +1,597 @@
+package retroarch
+
+import (
+	"FactFinder/emulator"
+	"FactFinder/emulator/emulatortest"
+	"bytes"
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRetroArch answers RetroArch network commands from a memory image.
+type fakeRetroArch struct {
+	conn *net.UDPConn
+
+	m      sync.Mutex
+	status string
+	// READ_CORE_MEMORY address of mem, -1 for a core without a memory map
+	base int
+	mem  []byte
+	// delivers read replies out of order, twice or late when set
+	scramble *scrambler
+	// where the last command came from
+	client netip.AddrPort
+}
+
+// scrambler delivers read replies as a busy network may.
+type scrambler struct {
+	// replies are held until this many are queued, then sent in reverse
+	reverse int
+	// every reply of a batch but the last is sent twice
+	duplicate bool
+	// replies to reads of these addresses are held back and sent ahead of
+	// the reply to the next read of any other address
+	stall map[int]bool
+
+	queued [][]byte
+	late   [][]byte
+}
+
+// replies returns the datagrams to send once reply to a read of addr is
+// ready, none while it is held.
+func (s *scrambler) replies(reply []byte, addr int) [][]byte {
+	reply = bytes.Clone(reply)
+	if s.stall[addr] {
+		s.late = append(s.late, reply)
+		return nil
+	}
+
+	out := s.late
+	s.late = nil
+
+	s.queued = append(s.queued, reply)
+	if len(s.queued) < s.reverse {
+		return out
+	}
+
+	for i := len(s.queued) - 1; i >= 0; i-- {
+		out = append(out, s.queued[i])
+		if s.duplicate && i > 0 {
+			out = append(out, s.queued[i])
+		}
+	}
+	s.queued = nil
+
+	return out
+}
+
+func newFakeRetroArch(t testing.TB, status string, base int, mem []byte) (*fakeRetroArch, *Client) {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	f := &fakeRetroArch{conn: conn, status: status, base: base, mem: mem}
+	go f.serve()
+
+	port := strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port)
+	client := NewClient("127.0.0.1", port)
+	if client.ConnectEmulator() != emulator.Connected {
+		t.Fatalf("client did not connect")
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return f, client
+}
+
+// switchCore has the fake run another core and content.
+func (f *fakeRetroArch) switchCore(status string, base int) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.status, f.base = status, base
+}
+
+// setMemory changes the byte at offset of the memory image.
+func (f *fakeRetroArch) setMemory(offset int, b byte) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.mem[offset] = b
+}
+
+// setScrambler has read replies delivered by s, nil for in order.
+func (f *fakeRetroArch) setScrambler(s *scrambler) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.scramble = s
+}
+
+// stall holds back the replies to reads of addr, see scrambler.
+func (f *fakeRetroArch) stall(addr int, stalled bool) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.scramble.stall[addr] = stalled
+}
+
+// release sends the replies stalled so far right away.
+func (f *fakeRetroArch) release() {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	for _, datagram := range f.scramble.late {
+		_, _ = f.conn.WriteToUDPAddrPort(datagram, f.client)
+	}
+	f.scramble.late = nil
+}
+
+// serve answers until the connection closes. It reuses its buffers unless
+// replies are scrambled, so allocation tests only count the client's.
+func (f *fakeRetroArch) serve() {
+	buf := make([]byte, 64*1024)
+	var reply []byte
+	for {
+		n, from, err := f.conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return
+		}
+
+		f.m.Lock()
+		f.client = from
+		reply = f.answer(reply[:0], buf[:n])
+		var out [][]byte
+		addr, isRead := readAddress(buf[:n])
+		scrambled := f.scramble != nil && isRead
+		if scrambled {
+			out = f.scramble.replies(reply, addr)
+		}
+		f.m.Unlock()
+
+		if !scrambled {
+			_, _ = f.conn.WriteToUDPAddrPort(reply, from)
+			continue
+		}
+		for _, datagram := range out {
+			_, _ = f.conn.WriteToUDPAddrPort(datagram, from)
+		}
+	}
+}
+
+// readAddress returns the address a read command asks for.
+func readAddress(msg []byte) (int, bool) {
+	cmd, msg := nextField(msg)
+	if string(cmd) != readCoreMemory && string(cmd) != readMemory {
+		return 0, false
+	}
+
+	addrField, _ := nextField(msg)
+	return parseHex(addrField), true
+}
+
+func parseHex(field []byte) int {
+	n := 0
+	for _, c := range field {
+		nibble, _ := fromHexNibble(c)
+		n = n<<4 | int(nibble)
+	}
+	return n
+}
+
+// answer appends the reply to msg, "<command> [<address> <size>]".
+func (f *fakeRetroArch) answer(reply, msg []byte) []byte {
+	cmd, msg := nextField(msg)
+	switch string(cmd) {
+	case "VERSION":
+		return append(reply, "1.19.1\n"...)
+	case "GET_STATUS":
+		reply = append(reply, "GET_STATUS "...)
+		reply = append(reply, f.status...)
+		return append(reply, '\n')
+	case readCoreMemory, readMemory:
+		addrField, msg := nextField(msg)
+		sizeField, _ := nextField(msg)
+
+		addr, size := parseHex(addrField), 0
+		for _, c := range sizeField {
+			size = size*10 + int(c-'0')
+		}
+
+		reply = append(reply, cmd...)
+		reply = append(reply, ' ')
+		reply = append(reply, addrField...)
+
+		start := addr
+		if string(cmd) == readCoreMemory {
+			if f.base < 0 {
+				return append(reply, " -1 no memory map defined\n"...)
+			}
+			start -= f.base
+		}
+		if start < 0 || start+size > len(f.mem) {
+			return append(reply, " -1 no descriptor for address\n"...)
+		}
+
+		for _, b := range f.mem[start : start+size] {
+			reply = append(reply, ' ')
+			reply = appendHexByte(reply, b)
+		}
+		return append(reply, '\n')
+	}
+
+	reply = append(reply, cmd...)
+	return append(reply, " -1\n"...)
+}
+
+// nextField splits the first space separated field off msg.
+func nextField(msg []byte) (field, rest []byte) {
+	msg = bytes.TrimLeft(msg, " \n")
+	if i := bytes.IndexAny(msg, " \n"); i >= 0 {
+		return msg[:i], msg[i:]
+	}
+	return msg, nil
+}
+
+func valuesByName(vals []emulator.Value) map[string]uint64 {
+	out := make(map[string]uint64, len(vals))
+	for _, v := range vals {
+		out[v.Name] = v.Unsigned
+	}
+	return out
+}
+
+func TestCoreChangeRecompilesEachPlan(t *testing.T) {
+	mem := make([]byte, 0x1000)
+	copy(mem[0x100:], []byte{0x44, 0x33, 0x22, 0x11})
+	mem[0x200] = 0x55
+
+	// DeSmuME maps main RAM at 0x02000000, melonDS at 0
+	fake, client := newFakeRetroArch(t, "PLAYING nds,Game A,crc32=1", 0x02000000, mem)
+
+	main := client.CompileReadPlan(&emulator.ReadPlan{
+		Name:     "main",
+		Platform: "DS",
+		Watches:  []emulator.ReadSpec{{Name: "hp", Type: emulator.U32, Bank: emulator.PSRAM, Address: 0x100}},
+	})
+	vals, err := client.GetValues(main)
+	if err != nil {
+		t.Fatalf("read on DeSmuME: %v", err)
+	}
+	if got := valuesByName(vals); got["hp"] != 0x11223344 {
+		t.Fatalf("hp = 0x%X on DeSmuME, want 0x11223344", got["hp"])
+	}
+
+	// a plan compiled after main, as the ROM header plan is
+	other := client.CompileReadPlan(&emulator.ReadPlan{
+		Name:     "other",
+		Platform: "DS",
+		Watches:  []emulator.ReadSpec{{Name: "lives", Type: emulator.U8, Bank: emulator.PSRAM, Address: 0x200}},
+	})
+
+	fake.switchCore("PLAYING nds,Game B,crc32=2", 0)
+	if _, err := client.GameInfo(); err != nil {
+		t.Fatalf("GameInfo: %v", err)
+	}
+
+	vals, err = client.GetValues(main)
+	if err != nil {
+		t.Fatalf("read on melonDS: %v", err)
+	}
+	got := valuesByName(vals)
+	if len(got) != 1 || got["hp"] != 0x11223344 {
+		t.Fatalf("main read %v on melonDS, want hp=0x11223344 only", got)
+	}
+	if main.Generation() != 1 {
+		t.Fatalf("main recompiled %d times, want once for the core switch", main.Generation())
+	}
+
+	vals, err = client.GetValues(other)
+	if err != nil {
+		t.Fatalf("read other on melonDS: %v", err)
+	}
+	if got := valuesByName(vals); len(got) != 1 || got["lives"] != 0x55 {
+		t.Fatalf("other read %v on melonDS, want lives=0x55 only", got)
+	}
+}
+
+func TestCoreIdentifiedByName(t *testing.T) {
+	// DeSmuME's probe would answer, the core names itself melonDS
+	_, client := newFakeRetroArch(t, "PLAYING melonDS,Game,crc32=1", 0x02000000, make([]byte, 0x100))
+
+	plan := client.CompileReadPlan(&emulator.ReadPlan{
+		Name:     "main",
+		Platform: "DS",
+		Watches:  []emulator.ReadSpec{{Name: "hp", Type: emulator.U32, Bank: emulator.PSRAM, Address: 0x10}},
+	})
+	if backend := plan.Backend(emulator.PSRAM); backend != emulator.RetroArchMelonDS {
+		t.Fatalf("PSRAM compiled for %s, want %s", backend, emulator.RetroArchMelonDS)
+	}
+}
+
+func snesPlan(addr int) *emulator.ReadPlan {
+	return &emulator.ReadPlan{
+		Name:     "snes",
+		Platform: "SNES",
+		Watches:  []emulator.ReadSpec{{Name: "lives", Type: emulator.U8, Bank: emulator.WRAM, Address: emulator.HexInt(addr)}},
+	}
+}
+
+func TestUnmappedReadErrors(t *testing.T) {
+	_, client := newFakeRetroArch(t, "PLAYING super_nes,Game,crc32=1", 0x7E0000, make([]byte, 0x100))
+	if _, err := client.GameInfo(); err != nil {
+		t.Fatalf("GameInfo: %v", err)
+	}
+
+	_, err := client.GetValues(client.CompileReadPlan(snesPlan(0x1000)))
+	if !errors.Is(err, emulator.ErrAddressUnmapped) || errors.Is(err, emulator.ErrGameNotLoaded) {
+		t.Fatalf("read past the core's memory: %v, want ErrAddressUnmapped", err)
+	}
+	if !strings.Contains(err.Error(), "no descriptor for address") {
+		t.Errorf("error %q lost the reason", err)
+	}
+}
+
+func TestContentlessReadErrors(t *testing.T) {
+	_, client := newFakeRetroArch(t, "CONTENTLESS", -1, nil)
+	if _, err := client.GameInfo(); !errors.Is(err, emulator.ErrGameNotLoaded) {
+		t.Fatalf("GameInfo: %v, want ErrGameNotLoaded", err)
+	}
+
+	_, err := client.GetValues(client.CompileReadPlan(snesPlan(0x10)))
+	if !errors.Is(err, emulator.ErrGameNotLoaded) {
+		t.Fatalf("read without content: %v, want ErrGameNotLoaded", err)
+	}
+}
+
+func TestFallbackClearedOnCoreChange(t *testing.T) {
+	mem := make([]byte, 0x100)
+	mem[0x10] = 0x42
+
+	fake, client := newFakeRetroArch(t, "PLAYING super_nes,Game A,crc32=1", -1, mem)
+	if _, err := client.GameInfo(); err != nil {
+		t.Fatalf("GameInfo: %v", err)
+	}
+
+	plan := client.CompileReadPlan(snesPlan(0x10))
+	vals, err := client.GetValues(plan)
+	if err != nil {
+		t.Fatalf("read on a core without a memory map: %v", err)
+	}
+	if got := valuesByName(vals); got["lives"] != 0x42 {
+		t.Fatalf("lives = 0x%X with READ_MEMORY, want 0x42", got["lives"])
+	}
+	if backend := plan.Backend(emulator.WRAM); backend != emulator.RCheevos {
+		t.Fatalf("WRAM compiled for %s, want %s", backend, emulator.RCheevos)
+	}
+
+	fake.switchCore("PLAYING super_nes,Game B,crc32=2", 0x7E0000)
+	if _, err := client.GameInfo(); err != nil {
+		t.Fatalf("GameInfo: %v", err)
+	}
+
+	vals, err = client.GetValues(plan)
+	if err != nil {
+		t.Fatalf("read on a core with a memory map: %v", err)
+	}
+	if got := valuesByName(vals); got["lives"] != 0x42 {
+		t.Fatalf("lives = 0x%X with READ_CORE_MEMORY, want 0x42", got["lives"])
+	}
+	if backend := plan.Backend(emulator.WRAM); backend != emulator.RetroArch {
+		t.Fatalf("WRAM compiled for %s after the core change, want %s", backend, emulator.RetroArch)
+	}
+}
+
+func TestGetValues(t *testing.T) {
+	_, client := newFakeRetroArch(t, "PLAYING super_nes,Game,crc32=1", 0x7E0000, emulatortest.WRAM())
+
+	vals, err := client.GetValues(client.CompileReadPlan(emulatortest.ReadPlan()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	emulatortest.CheckValues(t, vals)
+}
+
+func TestByteOrder(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    string
+		base      int
+		platform  string
+		bank      emulator.Bank
+		hostWords bool
+		native    map[string]emulator.Endian
+	}{
+		{
+			"SNES WRAM as stored",
+			"PLAYING super_nes,Game,crc32=1", 0x7E0000, "SNES", emulator.WRAM, false,
+			map[string]emulator.Endian{
+				"little":  emulator.LittleEndian,
+				"big":     emulator.BigEndian,
+				"swapped": emulator.WordSwapped,
+				"half":    emulator.BigEndian,
+				"default": emulator.LittleEndian,
+			},
+		},
+		{
+			"N64 RDRAM in host-endian words",
+			"PLAYING n64,Game,crc32=1", 0, "N64", emulator.RDRAM, true,
+			map[string]emulator.Endian{
+				"little":  emulator.WordSwappedLittle,
+				"big":     emulator.WordSwapped,
+				"swapped": emulator.BigEndian,
+				"half":    emulator.WordSwapped,
+				"default": emulator.WordSwapped,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		plan := emulatortest.ByteOrderPlan(tt.platform, tt.bank)
+		_, client := newFakeRetroArch(t, tt.status, tt.base, emulatortest.ByteOrderMemory(plan, 0x100, tt.hostWords))
+
+		caps := client.Capabilities()
+		for _, w := range plan.Watches {
+			if got := caps.ByteOrder(plan, w); got != tt.native[w.Name] {
+				t.Errorf("%s: %s comes back %s, want %s", tt.name, w.Name, got, tt.native[w.Name])
+			}
+		}
+
+		vals, err := client.GetValues(client.CompileReadPlan(plan))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		emulatortest.CheckByteOrder(t, vals)
+	}
+}
+
+// BenchmarkGetValues times a tick of the default backend, the others share
+// its decoding and are only checked for allocations.
+func BenchmarkGetValues(b *testing.B) {
+	_, client := newFakeRetroArch(b, "PLAYING super_nes,Game,crc32=1", 0x7E0000, emulatortest.WRAM())
+	plan := client.CompileReadPlan(emulatortest.ReadPlan())
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := client.GetValues(plan); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestGetValuesAllocs(t *testing.T) {
+	_, client := newFakeRetroArch(t, "PLAYING super_nes,Game,crc32=1", 0x7E0000, emulatortest.WRAM())
+	plan := client.CompileReadPlan(emulatortest.ReadPlan())
+
+	// the first read detects the core and sizes the buffers
+	if _, err := client.GetValues(plan); err != nil {
+		t.Fatal(err)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := client.GetValues(plan); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs > 0 {
+		t.Errorf("GetValues allocates %.1f times a tick, want 0", allocs)
+	}
+}
+
+// spreadPlan reads four WRAM bytes too far apart to share a region.
+func spreadPlan(client *Client) *emulator.CompiledReadPlan {
+	plan := &emulator.ReadPlan{Name: "spread", Platform: "SNES"}
+	for i, name := range []string{"a", "b", "c", "d"} {
+		plan.Watches = append(plan.Watches, emulator.ReadSpec{
+			Name:    name,
+			Type:    emulator.U8,
+			Bank:    emulator.WRAM,
+			Address: emulator.HexInt(i * 0x400),
+		})
+	}
+
+	compiled := client.CompileReadPlan(plan)
+	if len(compiled.Regions) != 4 {
+		panic("spreadPlan watches share a region")
+	}
+	return compiled
+}
+
+func TestPipelinedRepliesMatchedByAddress(t *testing.T) {
+	fake, client := newFakeRetroArch(t, "PLAYING super_nes,Game,crc32=1", 0x7E0000, make([]byte, 0x1000))
+	if _, err := client.GameInfo(); err != nil {
+		t.Fatalf("GameInfo: %v", err)
+	}
+	plan := spreadPlan(client)
+
+	fake.setScrambler(&scrambler{reverse: 4, duplicate: true})
+
+	for tick := range 3 {
+		for i := range 4 {
+			fake.setMemory(i*0x400, byte(tick<<4|i))
+		}
+
+		vals, err := client.GetValues(plan)
+		if err != nil {
+			t.Fatalf("tick %d: %v", tick, err)
+		}
+
+		got := valuesByName(vals)
+		for i, name := range []string{"a", "b", "c", "d"} {
+			if want := uint64(tick<<4 | i); got[name] != want {
+				t.Errorf("tick %d: %s = 0x%X, want 0x%X", tick, name, got[name], want)
+			}
+		}
+	}
+}
+
+func TestLateReplyDroppedDuringNextBatch(t *testing.T) {
+	mem := make([]byte, 0x1000)
+	mem[0x400] = 0x11
+	fake, client := newFakeRetroArch(t, "PLAYING super_nes,Game,crc32=1", 0x7E0000, mem)
+	if _, err := client.GameInfo(); err != nil {
+		t.Fatalf("GameInfo: %v", err)
+	}
+
+	fake.setScrambler(&scrambler{stall: map[int]bool{0x7E0000: true}})
+
+	stalled := client.CompileReadPlan(snesPlan(0))
+	if _, err := client.GetValues(stalled); err == nil {
+		t.Fatalf("read of a stalled address succeeded")
+	}
+
+	// the stalled reply turns up ahead of this read's
+	vals, err := client.GetValues(client.CompileReadPlan(snesPlan(0x400)))
+	if err != nil {
+		t.Fatalf("read after the timeout: %v", err)
+	}
+	if got := valuesByName(vals); got["lives"] != 0x11 {
+		t.Fatalf("lives = 0x%X, want 0x11 rather than the late reply's", got["lives"])
+	}
+
+	// nothing of the timed out read is left to answer the next one
+	fake.stall(0x7E0000, false)
+	fake.setMemory(0, 0x22)
+
+	vals, err = client.GetValues(stalled)
+	if err != nil {
+		t.Fatalf("read once replies arrive: %v", err)
+	}
+	if got := valuesByName(vals); got["lives"] != 0x22 {
+		t.Fatalf("lives = 0x%X, want 0x22", got["lives"])
+	}
+}
+
+func TestLateReplyDiscardedBeforeNextRead(t *testing.T) {
+	mem := make([]byte, 0x1000)
+	mem[0] = 0x11
+	fake, client := newFakeRetroArch(t, "PLAYING super_nes,Game,crc32=1", 0x7E0000, mem)
+	if _, err := client.GameInfo(); err != nil {
+		t.Fatalf("GameInfo: %v", err)
+	}
+
+	fake.setScrambler(&scrambler{stall: map[int]bool{0x7E0000: true}})
+
+	plan := client.CompileReadPlan(snesPlan(0))
+	if _, err := client.GetValues(plan); err == nil {
+		t.Fatalf("read of a stalled address succeeded")
+	}
+
+	// the reply to the timed out read arrives before the address is read
+	// again, with the value it had then
+	fake.setMemory(0, 0x22)
+	fake.release()
+	fake.setScrambler(nil)
+
+	vals, err := client.GetValues(plan)
+	if err != nil {
+		t.Fatalf("read after the late reply: %v", err)
+	}
+	if got := valuesByName(vals); got["lives"] != 0x22 {
+		t.Fatalf("lives = 0x%X, want 0x22 rather than the late reply's", got["lives"])
+	}
+}
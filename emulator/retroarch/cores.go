@@ -0,0 +1,134 @@
+package retroarch
+
+import (
+	"FactFinder/emulator"
+	"slices"
+	"strings"
+)
+
+// profile is the memory map shared by some cores of a platform, read
+// through backend.
+type profile struct {
+	// as GET_STATUS reports cores without a system id
+	name     string
+	platform string
+	backend  emulator.Backend
+	// an address only this map has, read to tell cores of a system apart
+	probe int
+}
+
+// profiles lists the memory maps of platforms whose cores disagree. The
+// profile of the core GET_STATUS names is used, else the first of the
+// plan's platform whose probe answers. Plans of other platforms read
+// through the RetroArch translation.
+var profiles = []profile{
+	{name: "DeSmuME", platform: "DS", backend: emulator.RetroArch, probe: 0x02000000},
+	{name: "melonDS", platform: "DS", backend: emulator.RetroArchMelonDS, probe: 0},
+}
+
+// systems maps the system id of a core, as GET_STATUS reports it, to the
+// platforms it runs. Cores that run several systems report one of them.
+var systems = map[string][]string{
+	"super_nes":        {"SNES"},
+	"game_boy":         {"GB", "GBC"},
+	"game_boy_color":   {"GB", "GBC"},
+	"game_boy_advance": {"GBA", "GB", "GBC"},
+	"nes":              {"NES"},
+	"mega_drive":       {"Genesis", "SMS", "GG"},
+	"master_system":    {"SMS", "GG"},
+	"game_gear":        {"GG", "SMS"},
+	"playstation":      {"PSX"},
+	"n64":              {"N64"},
+	"nds":              {"DS"},
+	"3ds":              {"3DS"},
+	"pc_engine":        {"PCE"},
+	"sega_saturn":      {"Saturn"},
+	"neo_geo_pocket":   {"NGP"},
+	"atari_2600":       {"A2600"},
+}
+
+// detectCore returns the backend to read plan through on the running core,
+// warning when the core does not run the plan's platform. The choice is kept
+// until the core or content changes.
+func (c *Client) detectCore(plan *emulator.ReadPlan) emulator.Backend {
+	c.m.Lock()
+	backend, detected := c.profiles[plan.Platform]
+	c.m.Unlock()
+
+	if detected {
+		return backend
+	}
+
+	backend = c.probeProfiles(plan)
+
+	c.m.Lock()
+	if c.profiles == nil {
+		c.profiles = make(map[string]emulator.Backend)
+	}
+	c.profiles[plan.Platform] = backend
+	c.m.Unlock()
+
+	return backend
+}
+
+// corePlatforms returns the platforms the running core runs, and its
+// profile when GET_STATUS named the core. Cores without a system id report
+// their name in its place.
+func corePlatforms(system string) ([]string, *profile, bool) {
+	if platforms, ok := systems[system]; ok {
+		return platforms, nil, true
+	}
+
+	for i := range profiles {
+		if strings.EqualFold(profiles[i].name, system) {
+			return []string{profiles[i].platform}, &profiles[i], true
+		}
+	}
+
+	return nil, nil, false
+}
+
+// probeProfiles finds the memory map of the running core for plan, the
+// profile of the core GET_STATUS named or else the first whose probe
+// answers.
+func (c *Client) probeProfiles(plan *emulator.ReadPlan) emulator.Backend {
+	c.m.Lock()
+	system := c.system
+	c.m.Unlock()
+
+	if system == "" {
+		// GameInfo records the system id
+		if _, err := c.GameInfo(); err != nil {
+			log.Debug("core unknown: %v", err)
+		}
+		c.m.Lock()
+		system = c.system
+		c.m.Unlock()
+	}
+
+	platforms, core, known := corePlatforms(system)
+	switch {
+	case system == "":
+	case !known:
+		log.Debug("unknown core system %s", system)
+	case !slices.Contains(platforms, plan.Platform):
+		log.Warn("the core runs %s but %s is a %s read plan", system, plan.Name, plan.Platform)
+	case core != nil:
+		log.Info("using the %s memory map for %s", core.name, plan.Platform)
+		return core.backend
+	}
+
+	for _, p := range profiles {
+		if p.platform != plan.Platform {
+			continue
+		}
+
+		var b [1]byte
+		if err := c.readMemory("", p.probe, b[:]); err == nil {
+			log.Info("using the %s memory map for %s", p.name, plan.Platform)
+			return p.backend
+		}
+	}
+
+	return emulator.RetroArch
+}
@@ -2,6 +2,7 @@ package retroarch
 
 import (
 	"FactFinder/emulator"
+	"bytes"
 	"fmt"
 )
 
@@ -40,6 +41,31 @@ func skipField(buf []byte, i int) int {
 	return i
 }
 
+// replyAddress returns the hex address a reply to cmd echoes,
+// "<cmd> <address> ...".
+func replyAddress(reply []byte, cmd string) (int, bool) {
+	rest, ok := bytes.CutPrefix(reply, []byte(cmd))
+	if !ok || len(rest) < 2 || rest[0] != ' ' {
+		return 0, false
+	}
+
+	addr := 0
+	digits := 0
+	for _, c := range rest[1:] {
+		if isSpace(c) {
+			break
+		}
+		n, ok := fromHexNibble(c)
+		if !ok || digits == 16 {
+			return 0, false
+		}
+		addr = addr<<4 | int(n)
+		digits++
+	}
+
+	return addr, digits > 0
+}
+
 // parseHexByteToken expects a 2-hex-digit token at buf[i:], returns byte value and new index.
 // It tolerates tokens longer than 2 by consuming until whitespace after reading first 2 hex digits.
 func parseHexByteToken(buf []byte, i int) (byte, int, error) {
@@ -74,7 +100,7 @@ func parseHexByteToken(buf []byte, i int) (byte, int, error) {
 }
 
 // decodeRetroArchReadCoreMemoryBytes expects a response like:
-// "READ_CORE_MEMORY <addr> <b0> <b1> ..."
+// "READ_CORE_MEMORY <addr> <b0> <b1> ...", or the same from READ_MEMORY.
 // It skips the first 2 fields and decodes `want` hex byte tokens into dst.
 // dst must have length >= want.
 func decodeRetroArchReadCoreMemoryBytes(resp []byte, dst []byte, want int) error {
@@ -87,8 +113,13 @@ func decodeRetroArchReadCoreMemoryBytes(resp []byte, dst []byte, want int) error
 	// Skip "<addr>"
 	i = skipField(resp, i)
 
+	// -1 and a reason, such as "no descriptor for address"
 	if i < len(resp) && resp[i] == '-' {
-		return emulator.ErrGameNotLoaded
+		reason := bytes.TrimSpace(resp[skipField(resp, i):])
+		if len(reason) == 0 {
+			return emulator.ErrAddressUnmapped
+		}
+		return fmt.Errorf("%w: %s", emulator.ErrAddressUnmapped, reason)
 	}
 
 	for j := 0; j < want; j++ {
@@ -127,3 +158,9 @@ func appendHexUpper(dst []byte, v uint64) []byte {
 
 	return append(dst, tmp[i:]...)
 }
+
+// appendHexByte writes b as two uppercase hex digits.
+func appendHexByte(dst []byte, b byte) []byte {
+	const digits = "0123456789ABCDEF"
+	return append(dst, digits[b>>4], digits[b&0xF])
+}
@@ -0,0 +1,194 @@
+package emulator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrUnknownRomVersion = errors.New("unknown rom version")
+	// the backend cannot read the rom header and the game it reports, if
+	// any, matches no version
+	ErrRomUnreadable = errors.New("cannot detect rom version")
+)
+
+// RomVersion is one release of a game. It matches when every criterion that
+// is set equals the loaded ROM's header, its watches override the plan's
+// watches of the same name key by key. Match rules pick the version from the
+// game the backend reports when the header cannot be read.
+type RomVersion struct {
+	Name     string      `yaml:"name"`
+	Title    string      `yaml:"title,omitempty"`
+	Checksum *HexInt     `yaml:"checksum,omitempty"`
+	Match    []GameMatch `yaml:"match,omitempty"`
+	Watches  []ReadSpec  `yaml:"watches,omitempty"`
+
+	// the plan with the overrides applied, set by NewReadPlan/LoadReadPlan
+	plan *ReadPlan
+}
+
+// RomHeader is where a platform keeps the title and checksum of a ROM,
+// as offsets into the ROM bank.
+type RomHeader struct {
+	Title        int
+	TitleLength  int
+	Checksum     int
+	ChecksumSize int
+	Endian       Endian
+}
+
+// RomHeaderLayout returns the header of a platform's ROMs.
+// SNES: internal header, checksum at $FFDE
+// GB/GBC: cartridge header, global checksum at $014E
+// GBA: game title, header complement at $BD
+// Genesis: domestic title, checksum at $18E
+// N64: image name, CRC1 at $10
+// DS: game title, header CRC16 at $15E
+func RomHeaderLayout(plan *ReadPlan) (RomHeader, bool) {
+	switch plan.Platform {
+	case "SNES":
+		base := 0x7FC0
+		if plan.HiROM {
+			base = 0xFFC0
+		}
+		return RomHeader{base, 21, base + 0x1E, 2, LittleEndian}, true
+	case "GB", "GBC":
+		return RomHeader{0x134, 16, 0x14E, 2, BigEndian}, true
+	case "GBA":
+		return RomHeader{0xA0, 12, 0xBD, 1, LittleEndian}, true
+	case "Genesis":
+		return RomHeader{0x120, 48, 0x18E, 2, BigEndian}, true
+	case "N64":
+		return RomHeader{0x20, 20, 0x10, 4, BigEndian}, true
+	case "DS":
+		return RomHeader{0x0, 12, 0x15E, 2, LittleEndian}, true
+	}
+
+	return RomHeader{}, false
+}
+
+// Variant returns the plan to compile for version, or the plan itself when
+// version is nil.
+func (p *ReadPlan) Variant(version *RomVersion) *ReadPlan {
+	if version == nil || version.plan == nil {
+		return p
+	}
+	return version.plan
+}
+
+// DetectRomVersion reads the ROM header through reader and returns the
+// first version of plan that matches it. Plans without versions return nil.
+// When the header cannot be read the version is picked by its Match rules
+// from the game reader reports, ErrRomUnreadable when none fits.
+func DetectRomVersion(reader MemoryReader, plan *ReadPlan) (*RomVersion, error) {
+	if len(plan.Versions) == 0 {
+		return nil, nil
+	}
+
+	header, ok := RomHeaderLayout(plan)
+	if !ok || !reader.Capabilities().SupportsBank(ROM) {
+		return versionForGame(reader, plan)
+	}
+
+	checksumType := map[int]ValueType{1: U8, 2: U16, 4: U32}[header.ChecksumSize]
+
+	headerPlan := &ReadPlan{
+		Name:     plan.Name + " header",
+		Platform: plan.Platform,
+		HiROM:    plan.HiROM,
+		SA1:      plan.SA1,
+		Watches: []ReadSpec{
+			{
+				Name:         "title",
+				Address:      HexInt(header.Title),
+				Type:         String,
+				Bank:         ROM,
+				StringLength: header.TitleLength,
+			},
+			{
+				Name:    "checksum",
+				Address: HexInt(header.Checksum),
+				Type:    checksumType,
+				Bank:    ROM,
+				Endian:  header.Endian,
+			},
+		},
+	}
+
+	compiled := reader.CompileReadPlan(headerPlan)
+	if len(compiled.Unsupported) > 0 {
+		return versionForGame(reader, plan)
+	}
+
+	vals, err := reader.GetValues(compiled)
+	if err != nil {
+		// cores without rom descriptors answer like unmapped memory
+		if errors.Is(err, ErrAddressUnmapped) && !errors.Is(err, ErrGameNotLoaded) {
+			return versionForGame(reader, plan)
+		}
+		return nil, err
+	}
+
+	var title string
+	var checksum uint64
+	for _, v := range vals {
+		switch v.Name {
+		case "title":
+			title = strings.TrimRight(v.String, " \x00")
+		case "checksum":
+			checksum = v.Unsigned
+		}
+	}
+
+	log.Debug("rom header: title=%q checksum=0x%X", title, checksum)
+
+	for i := range plan.Versions {
+		version := &plan.Versions[i]
+
+		if version.Title == "" && version.Checksum == nil {
+			continue
+		}
+		if version.Title != "" && strings.TrimSpace(version.Title) != strings.TrimSpace(title) {
+			continue
+		}
+		if version.Checksum != nil && uint64(*version.Checksum) != checksum {
+			continue
+		}
+
+		log.Info("detected rom version %s", version.Name)
+		return version, nil
+	}
+
+	// versions told apart by match rules only
+	if version, err := versionForGame(reader, plan); err == nil {
+		return version, nil
+	}
+
+	return nil, fmt.Errorf("%w: title=%q checksum=0x%X", ErrUnknownRomVersion, title, checksum)
+}
+
+// versionForGame returns the first version of plan whose Match rules fit
+// the game reader reports.
+func versionForGame(reader MemoryReader, plan *ReadPlan) (*RomVersion, error) {
+	identifier, ok := reader.(GameIdentifier)
+	if !ok {
+		return nil, ErrRomUnreadable
+	}
+
+	game, err := identifier.GameInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range plan.Versions {
+		version := &plan.Versions[i]
+
+		if MatchGame(version.Match, game) {
+			log.Info("detected rom version %s from game name=%q crc=%08X", version.Name, game.Name, game.CRC)
+			return version, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: game name=%q crc=%08X", ErrRomUnreadable, game.Name, game.CRC)
+}
@@ -0,0 +1,73 @@
+package emulator
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// romlessReader reads like a RetroArch core without rom descriptors and
+// reports the game it runs.
+type romlessReader struct {
+	MemoryReader
+	game *GameInfo
+}
+
+func (r romlessReader) Capabilities() Capabilities { return DefaultCapabilities }
+
+func (r romlessReader) CompileReadPlan(plan *ReadPlan) *CompiledReadPlan {
+	return CompileReadPlan(plan, DefaultCapabilities, RetroArch)
+}
+
+func (r romlessReader) GetValues(*CompiledReadPlan) ([]Value, error) {
+	return nil, fmt.Errorf("%w: no rom descriptor", ErrAddressUnmapped)
+}
+
+func (r romlessReader) GameInfo() (*GameInfo, error) { return r.game, nil }
+
+// blindReader cannot read the rom or report the game, as a process.
+type blindReader struct {
+	MemoryReader
+}
+
+func (blindReader) Capabilities() Capabilities {
+	return Capabilities{Banks: []Bank{WRAM}}
+}
+
+func TestDetectRomVersionFromGame(t *testing.T) {
+	checksum, crc := HexInt(0xA0DA), HexInt(0xB19ED489)
+	plan := &ReadPlan{
+		Name:     "smw",
+		Platform: "SNES",
+		Versions: []RomVersion{
+			{Name: "US", Checksum: &checksum, Match: []GameMatch{{CRC: &crc}}},
+			{Name: "JP", Match: []GameMatch{{Name: "*(Japan)*"}}},
+		},
+	}
+
+	tests := []struct {
+		game *GameInfo
+		want string
+	}{
+		{&GameInfo{Name: "Super Mario World (USA)", CRC: 0xB19ED489}, "US"},
+		{&GameInfo{Name: "Super Mario World (Japan)"}, "JP"},
+		{&GameInfo{Name: "Super Mario World (Europe)"}, ""},
+	}
+
+	for _, tt := range tests {
+		version, err := DetectRomVersion(romlessReader{game: tt.game}, plan)
+		if tt.want == "" {
+			if !errors.Is(err, ErrRomUnreadable) {
+				t.Errorf("%s: detected %v, %v, want ErrRomUnreadable", tt.game.Name, version, err)
+			}
+			continue
+		}
+		if err != nil || version == nil || version.Name != tt.want {
+			t.Errorf("%s: detected %v, %v, want %s", tt.game.Name, version, err, tt.want)
+		}
+	}
+
+	if _, err := DetectRomVersion(blindReader{}, plan); !errors.Is(err, ErrRomUnreadable) {
+		t.Errorf("without rom or game: %v, want ErrRomUnreadable", err)
+	}
+}
@@ -0,0 +1,113 @@
+package emulator
+
+import (
+	"encoding/json"
+	"maps"
+	"reflect"
+	"slices"
+)
+
+// ReadPlanSchema returns a JSON Schema for readplan.yml, generated from the
+// yaml tags of ReadPlan so editors can autocomplete providers.
+func ReadPlanSchema() ([]byte, error) {
+	g := &schemaGenerator{definitions: make(map[string]any)}
+
+	schema := g.schema(reflect.TypeOf(ReadPlan{}))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "FactFinder read plan"
+	schema["required"] = []string{"Name", "ReadInterval", "Watches"}
+	schema["definitions"] = g.definitions
+
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+type schemaGenerator struct {
+	definitions map[string]any
+}
+
+var (
+	hexIntType    = reflect.TypeOf(HexInt(0))
+	bankType      = reflect.TypeOf(Bank(""))
+	valueTypeType = reflect.TypeOf(ValueType(""))
+	endianType    = reflect.TypeOf(Endian(""))
+	signalsType   = reflect.TypeOf(Signals(nil))
+	fileListType  = reflect.TypeOf(FileList(nil))
+	readSpecType  = reflect.TypeOf(ReadSpec{})
+)
+
+func (g *schemaGenerator) schema(t reflect.Type) map[string]any {
+	switch t {
+	case hexIntType:
+		return map[string]any{
+			"type":    []string{"integer", "string"},
+			"pattern": `^(0[xX]|\$)?[0-9A-Fa-f]+$`,
+		}
+	case bankType:
+		return map[string]any{"type": "string", "enum": Banks}
+	case valueTypeType:
+		return map[string]any{"type": "string", "enum": ValueTypes}
+	case endianType:
+		// every spelling Endian.UnmarshalYAML accepts
+		return map[string]any{"type": "string", "enum": slices.Sorted(maps.Keys(endianNames))}
+	case signalsType:
+		signal := map[string]any{"type": "string", "enum": SignalNames}
+		return map[string]any{
+			"oneOf": []any{
+				signal,
+				map[string]any{"type": "array", "items": signal},
+			},
+		}
+	case fileListType:
+		file := map[string]any{"type": "string"}
+		return map[string]any{
+			"oneOf": []any{
+				file,
+				map[string]any{"type": "array", "items": file},
+			},
+		}
+	case readSpecType:
+		if _, ok := g.definitions["ReadSpec"]; !ok {
+			// placeholder first, ReadSpec.Fields refers back to itself
+			g.definitions["ReadSpec"] = nil
+			g.definitions["ReadSpec"] = g.object(t)
+		}
+		return map[string]any{"$ref": "#/definitions/ReadSpec"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.Struct:
+		return g.object(t)
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	default:
+		return map[string]any{"type": "string"}
+	}
+}
+
+func (g *schemaGenerator) object(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+
+	for key, index := range yamlFields(t) {
+		field := t.FieldByIndex(index)
+		properties[key] = g.schema(field.Type)
+	}
+
+	if t == reflect.TypeOf(ReadPlan{}) {
+		properties["Platform"] = map[string]any{"type": "string", "enum": Platforms}
+	}
+
+	return map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
@@ -0,0 +1,41 @@
+package emulator
+
+import (
+	"encoding/json"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSchemaEndianNames(t *testing.T) {
+	raw, err := ReadPlanSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var schema struct {
+		Definitions struct {
+			ReadSpec struct {
+				Properties struct {
+					Endian struct {
+						Enum []string `json:"enum"`
+					} `json:"endian"`
+				} `json:"properties"`
+			} `json:"ReadSpec"`
+		} `json:"definitions"`
+	}
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	names := schema.Definitions.ReadSpec.Properties.Endian.Enum
+	if len(names) != len(endianNames) {
+		t.Fatalf("schema lists endians %v, want every one of %d spellings", names, len(endianNames))
+	}
+	for _, name := range names {
+		var e Endian
+		if err := yaml.Unmarshal([]byte(name), &e); err != nil {
+			t.Errorf("schema endian %q does not load: %v", name, err)
+		}
+	}
+}
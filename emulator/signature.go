@@ -0,0 +1,131 @@
+package emulator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrSignatureNotFound = errors.New("signature not found")
+
+// Pattern is a parsed array of bytes signature such as
+// "48 8B 05 ?? ?? ?? ?? 48 85 C0", ?? matches any byte.
+type Pattern struct {
+	Bytes []byte
+	// false where the pattern has a wildcard
+	Mask []bool
+
+	// first byte that is not a wildcard, searched for with IndexByte
+	anchor int
+}
+
+// ParsePattern parses space separated hex bytes and ?? (or ?) wildcards.
+// Bytes may also be written without spaces, "488B05????????".
+func ParsePattern(s string) (*Pattern, error) {
+	p := &Pattern{anchor: -1}
+
+	for _, field := range strings.Fields(s) {
+		tokens := []string{field}
+		if len(field) > 2 && len(field)%2 == 0 {
+			tokens = tokens[:0]
+			for i := 0; i < len(field); i += 2 {
+				tokens = append(tokens, field[i:i+2])
+			}
+		}
+
+		for _, tok := range tokens {
+			if tok == "?" || tok == "??" {
+				p.Bytes = append(p.Bytes, 0)
+				p.Mask = append(p.Mask, false)
+				continue
+			}
+
+			b, err := strconv.ParseUint(tok, 16, 8)
+			if err != nil || len(tok) != 2 {
+				return nil, fmt.Errorf("invalid pattern byte %q", tok)
+			}
+
+			if p.anchor < 0 {
+				p.anchor = len(p.Bytes)
+			}
+			p.Bytes = append(p.Bytes, byte(b))
+			p.Mask = append(p.Mask, true)
+		}
+	}
+
+	if len(p.Bytes) == 0 {
+		return nil, fmt.Errorf("empty pattern")
+	}
+	if p.anchor < 0 {
+		return nil, fmt.Errorf("pattern is only wildcards")
+	}
+
+	return p, nil
+}
+
+func (p *Pattern) Len() int {
+	return len(p.Bytes)
+}
+
+// Index returns the offset of the first match of p in data, or -1.
+func (p *Pattern) Index(data []byte) int {
+	n := len(p.Bytes)
+
+	for pos := 0; pos+n <= len(data); pos++ {
+		i := bytes.IndexByte(data[pos+p.anchor:len(data)-n+p.anchor+1], p.Bytes[p.anchor])
+		if i < 0 {
+			return -1
+		}
+		pos += i
+
+		if p.matchAt(data[pos : pos+n]) {
+			return pos
+		}
+	}
+
+	return -1
+}
+
+func (p *Pattern) matchAt(window []byte) bool {
+	for i, b := range p.Bytes {
+		if p.Mask[i] && window[i] != b {
+			return false
+		}
+	}
+	return true
+}
+
+// Resolve returns the address a signature leads to once its pattern matched
+// at match: match + offset, plus 4 and the displacement stored there when
+// ripRelative is set, through the pointer stored there when deref is set,
+// plus resultOffset.
+func (s Signature) Resolve(match int, read MemoryFunc) (int, error) {
+	addr := match + s.ScanOffset
+
+	if s.RIPRelative {
+		// the displacement counts from the end of the 4 bytes holding it
+		var raw [4]byte
+		if err := read(ProcessMemory, addr, raw[:]); err != nil {
+			return 0, fmt.Errorf("read displacement at 0x%x: %w", addr, err)
+		}
+		addr += 4 + int(int32(binary.LittleEndian.Uint32(raw[:])))
+	}
+
+	if s.Deref {
+		size := s.DerefSize
+		if size == 0 {
+			size = 8
+		}
+
+		var raw [8]byte
+		if err := read(ProcessMemory, addr, raw[:size]); err != nil {
+			return 0, fmt.Errorf("deref signature at 0x%x: %w", addr, err)
+		}
+		addr = int(binary.LittleEndian.Uint64(raw[:]))
+	}
+
+	return addr + s.ResultOffset, nil
+}
@@ -0,0 +1,116 @@
+package emulator
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestParsePattern(t *testing.T) {
+	tests := []struct {
+		in    string
+		bytes []byte
+		mask  []bool
+		err   bool
+	}{
+		{in: "48 8B 05", bytes: []byte{0x48, 0x8B, 0x05}, mask: []bool{true, true, true}},
+		{in: "48 ?? ? C0", bytes: []byte{0x48, 0, 0, 0xC0}, mask: []bool{true, false, false, true}},
+		{in: "488B05????", bytes: []byte{0x48, 0x8B, 0x05, 0, 0}, mask: []bool{true, true, true, false, false}},
+		{in: "?? 8b", bytes: []byte{0, 0x8B}, mask: []bool{false, true}},
+		{in: "", err: true},
+		{in: "?? ??", err: true},
+		{in: "4G", err: true},
+		{in: "488", err: true},
+	}
+
+	for _, tt := range tests {
+		p, err := ParsePattern(tt.in)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParsePattern(%q) accepted", tt.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParsePattern(%q): %v", tt.in, err)
+			continue
+		}
+		if string(p.Bytes) != string(tt.bytes) {
+			t.Errorf("ParsePattern(%q) bytes % X, want % X", tt.in, p.Bytes, tt.bytes)
+		}
+		for i := range tt.mask {
+			if p.Mask[i] != tt.mask[i] {
+				t.Errorf("ParsePattern(%q) mask %v, want %v", tt.in, p.Mask, tt.mask)
+				break
+			}
+		}
+	}
+}
+
+func TestPatternIndex(t *testing.T) {
+	p, err := ParsePattern("?? 8B ?? C0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		data []byte
+		want int
+	}{
+		{[]byte{0x48, 0x8B, 0x05, 0xC0}, 0},
+		{[]byte{0x8B, 0x8B, 0x8B, 0x00, 0xC0}, 1},
+		{[]byte{0x00, 0x8B, 0x00, 0xC1, 0x00, 0x8B, 0x01, 0xC0}, 4},
+		// the anchor is past the start of the data, no match can begin there
+		{[]byte{0x8B, 0x00, 0xC0}, -1},
+		{[]byte{0x00, 0x8B, 0x00}, -1},
+		{nil, -1},
+	}
+
+	for _, tt := range tests {
+		if got := p.Index(tt.data); got != tt.want {
+			t.Errorf("Index(% X) = %d, want %d", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestSignatureResolve(t *testing.T) {
+	image := make([]byte, 0x100)
+	read := memoryImage(image)
+
+	// mov rax, [rip+0x20] at 0x10, the displacement at 0x13
+	copy(image[0x10:], []byte{0x48, 0x8B, 0x05})
+	binary.LittleEndian.PutUint32(image[0x13:], 0x20)
+	// a negative displacement at 0x50, back to 0x24
+	back := int32(0x24 - 0x54)
+	binary.LittleEndian.PutUint32(image[0x50:], uint32(back))
+	// the pointer the instruction loads, 0x37 == 0x17 + 0x20
+	binary.LittleEndian.PutUint64(image[0x37:], 0x123456789A)
+	binary.LittleEndian.PutUint64(image[0x80:], 0x7FF600000040)
+
+	tests := []struct {
+		name string
+		sig  Signature
+		want int
+	}{
+		{"offset", Signature{ScanOffset: 3, ResultOffset: 1}, 0x14},
+		{"rip", Signature{ScanOffset: 3, RIPRelative: true}, 0x37},
+		{"rip backwards", Signature{ScanOffset: 0x40, RIPRelative: true}, 0x24},
+		{"rip deref", Signature{ScanOffset: 3, RIPRelative: true, Deref: true, ResultOffset: 8}, 0x12345678A2},
+		{"deref 64-bit", Signature{ScanOffset: 0x70, Deref: true}, 0x7FF600000040},
+		{"deref 32-bit", Signature{ScanOffset: 0x70, Deref: true, DerefSize: 4}, 0x40},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.sig.Resolve(0x10, read)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s resolved to 0x%x, want 0x%x", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := (Signature{ScanOffset: 0xF0, Deref: true}).Resolve(0x10, read); err == nil {
+		t.Errorf("deref past the image resolved")
+	}
+}
@@ -0,0 +1,580 @@
+package emulator
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Diagnostic is one problem found in a read plan, positioned at the YAML
+// node it came from. Warnings do not stop the plan from loading.
+type Diagnostic struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+	Warning bool   `json:"warning,omitempty"`
+}
+
+func (d Diagnostic) String() string {
+	msg := d.Message
+	if d.Warning {
+		msg = "warning: " + msg
+	}
+
+	if d.File != "" {
+		return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, msg)
+	}
+	return fmt.Sprintf("%d:%d: %s", d.Line, d.Column, msg)
+}
+
+// HasErrors reports whether any of diags is not a warning.
+func HasErrors(diags []Diagnostic) bool {
+	return slices.ContainsFunc(diags, func(d Diagnostic) bool { return !d.Warning })
+}
+
+type ValidationError struct {
+	Diagnostics []Diagnostic
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Diagnostics))
+	for i, d := range e.Diagnostics {
+		msgs[i] = d.String()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+var (
+	identPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	linePattern  = regexp.MustCompile(`line (\d+)`)
+)
+
+type validator struct {
+	diags []Diagnostic
+	files map[*yaml.Node]string
+}
+
+func (v *validator) add(node *yaml.Node, format string, args ...any) {
+	v.diags = append(v.diags, Diagnostic{
+		File:    v.files[node],
+		Line:    node.Line,
+		Column:  node.Column,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// warn adds a diagnostic that does not stop the plan from loading.
+func (v *validator) warn(node *yaml.Node, format string, args ...any) {
+	v.add(node, format, args...)
+	v.diags[len(v.diags)-1].Warning = true
+}
+
+// ValidateReadPlan checks a readplan.yml and returns every problem found.
+// NewReadPlan accepts the plan when all of them are warnings.
+func ValidateReadPlan(raw []byte) []Diagnostic {
+	l := &planLoader{files: make(map[*yaml.Node]string)}
+
+	root, diags := l.parse("", raw, nil)
+	if root == nil {
+		return diags
+	}
+
+	return append(diags, validatePlan(root, l.files)...)
+}
+
+// validatePlan checks a parsed plan, files names the file of every node
+// when it was assembled from several.
+func validatePlan(root *yaml.Node, files map[*yaml.Node]string) []Diagnostic {
+	v := &validator{files: files}
+	v.plan(root)
+
+	slices.SortStableFunc(v.diags, func(a, b Diagnostic) int {
+		if a.File != b.File {
+			return strings.Compare(a.File, b.File)
+		}
+		if a.Line != b.Line {
+			return a.Line - b.Line
+		}
+		if a.Column != b.Column {
+			return a.Column - b.Column
+		}
+		return strings.Compare(a.Message, b.Message)
+	})
+
+	// watches shared by several versions are checked once per version
+	return slices.Compact(v.diags)
+}
+
+func (v *validator) plan(root *yaml.Node) {
+	plan := ReadPlan{}
+	nodes := v.decode(root, reflect.ValueOf(&plan).Elem())
+	if nodes == nil {
+		return
+	}
+
+	if plan.Name == "" {
+		v.add(root, "missing Name")
+	}
+
+	if _, ok := nodes["Watches"]; !ok {
+		v.add(root, "missing Watches")
+	}
+
+	if plan.ReadInterval <= 0 {
+		at := root
+		if n, ok := nodes["ReadInterval"]; ok {
+			at = n
+		}
+		v.add(at, "ReadInterval must be greater than 0")
+	}
+
+	if plan.Platform == "" && plan.ProcessName == "" {
+		v.add(root, "missing Platform")
+	} else if plan.Platform != "" && !slices.Contains(Platforms, plan.Platform) {
+		v.add(nodes["Platform"], "unknown platform %q", plan.Platform)
+	}
+
+	if pattern, ok := nodes["pattern"]; ok {
+		if _, err := ParsePattern(plan.ProcessSignature.Pattern); err != nil {
+			v.add(pattern, "%v", err)
+		}
+		if plan.ProcessName == "" {
+			v.add(pattern, "pattern needs a ProcessName to scan")
+		}
+	}
+
+	if size, ok := nodes["derefSize"]; ok && plan.ProcessSignature.DerefSize != 4 && plan.ProcessSignature.DerefSize != 8 {
+		v.add(size, "derefSize must be 4 or 8")
+	}
+
+	if match, ok := nodes["Match"]; ok {
+		v.matches(plan.Match, match)
+	}
+
+	watches, ok := nodes["Watches"]
+	if !ok || watches.Kind != yaml.SequenceNode {
+		return
+	}
+
+	if len(watches.Content) == 0 {
+		v.add(watches, "no Watches defined")
+		return
+	}
+
+	v.watches(&plan, watches, plan.Watches)
+
+	if versions, ok := nodes["Versions"]; ok && versions.Kind == yaml.SequenceNode {
+		v.versions(&plan, root, versions)
+	}
+}
+
+// matches checks a Match list, of the plan or of a version.
+func (v *validator) matches(rules []GameMatch, node *yaml.Node) {
+	if node.Kind != yaml.SequenceNode {
+		return
+	}
+
+	for i, item := range node.Content {
+		if i >= len(rules) {
+			break
+		}
+		v.match(rules[i], item, fieldMappingNodes(item))
+	}
+}
+
+func (v *validator) match(rule GameMatch, node *yaml.Node, nodes map[string]*yaml.Node) {
+	if rule.Name == "" && rule.File == "" && rule.CRC == nil {
+		v.add(node, "match rule needs a name, file or crc")
+	}
+
+	for key, pattern := range map[string]string{"name": rule.Name, "file": rule.File} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			v.add(nodes[key], "invalid %s pattern %q", key, pattern)
+		}
+	}
+}
+
+func (v *validator) watches(plan *ReadPlan, watches *yaml.Node, specs []ReadSpec) {
+	names := make(map[string]*yaml.Node)
+
+	for i, item := range watches.Content {
+		if item.Kind != yaml.MappingNode || i >= len(specs) {
+			continue
+		}
+		spec := specs[i]
+		specNodes := fieldMappingNodes(item)
+
+		if prev, ok := names[spec.Name]; ok && spec.Name != "" {
+			v.add(item, "duplicate watch name %q, first defined at line %d", spec.Name, prev.Line)
+		} else {
+			names[spec.Name] = item
+		}
+
+		v.watch(plan, spec, item, specNodes)
+	}
+}
+
+// versions checks every Versions entry and the watches it ends up with once
+// its overrides are applied.
+func (v *validator) versions(plan *ReadPlan, root, versions *yaml.Node) {
+	_, hasHeader := RomHeaderLayout(plan)
+
+	names := make(map[string]bool)
+
+	for i, item := range versions.Content {
+		if item.Kind != yaml.MappingNode || i >= len(plan.Versions) {
+			continue
+		}
+		version := plan.Versions[i]
+
+		if version.Name == "" {
+			v.add(item, "version is missing a name")
+		} else if names[version.Name] {
+			v.add(item, "duplicate version name %q", version.Name)
+		}
+		names[version.Name] = true
+
+		if version.Title == "" && version.Checksum == nil && len(version.Match) == 0 {
+			v.add(item, "version %q needs a title, checksum or match", version.Name)
+		} else if !hasHeader && len(version.Match) == 0 {
+			v.add(item, "platform %q has no known rom header, version %q needs match rules", plan.Platform, version.Name)
+		}
+
+		if match, ok := fieldMappingNodes(item)["match"]; ok {
+			v.matches(version.Match, match)
+		}
+
+		// decode errors of the overrides were reported with Versions
+		variant := variantNode(v.files, root, item)
+		watches := mappingValue(variant, "Watches")
+
+		var specs []ReadSpec
+		(&validator{}).decodeList(watches, &specs)
+
+		v.watches(plan, watches, specs)
+	}
+}
+
+func (v *validator) watch(
+	plan *ReadPlan,
+	spec ReadSpec,
+	node *yaml.Node,
+	nodes map[string]*yaml.Node,
+) {
+	at := func(key string) *yaml.Node {
+		if n, ok := nodes[key]; ok {
+			return n
+		}
+		return node
+	}
+
+	if spec.Name == "" {
+		v.add(node, "watch is missing a name")
+	} else if !identPattern.MatchString(spec.Name) {
+		v.warn(at("name"), "watch name %q is not a valid Lua identifier, read it as _G[%q]", spec.Name, spec.Name)
+	}
+
+	if spec.Bank == "" && plan.DefaultBank() == "" {
+		v.add(node, "watch %q has no bank and platform %q has no default", spec.Name, plan.Platform)
+	}
+
+	if spec.Module != "" && spec.Bank != ProcessMemory &&
+		(spec.Bank != "" || plan.DefaultBank() != ProcessMemory) {
+		v.add(at("module"), "module is only valid for process watches")
+	}
+
+	v.bankRange(plan, spec, at)
+
+	if spec.PointerSize < 0 || spec.PointerSize > 8 {
+		v.add(at("pointerSize"), "pointerSize must be between 1 and 8")
+	}
+
+	if spec.Count < 0 {
+		v.add(at("count"), "count must not be negative")
+	}
+
+	if len(spec.Fields) > 0 && spec.Count == 0 {
+		v.add(at("fields"), "fields require a count")
+	}
+
+	if spec.IsArray() && len(spec.Signals) > 0 {
+		v.add(at("signal"), "signal is not supported on array watches")
+	}
+
+	fieldsNode, hasFields := nodes["fields"]
+	if !hasFields {
+		v.value(spec, node, nodes)
+	} else if fieldsNode.Kind == yaml.SequenceNode {
+		names := make(map[string]bool)
+
+		for i, item := range fieldsNode.Content {
+			if i >= len(spec.Fields) {
+				break
+			}
+			field := spec.Fields[i]
+			fieldNodes := fieldMappingNodes(item)
+
+			if !identPattern.MatchString(field.Name) {
+				v.warn(item, "field name %q is not a valid Lua identifier, read it as element[%q]", field.Name, field.Name)
+			} else if names[field.Name] {
+				v.add(item, "duplicate field name %q", field.Name)
+			}
+			names[field.Name] = true
+
+			v.value(field, item, fieldNodes)
+		}
+	}
+
+	if spec.IsArray() && spec.Stride > 0 && len(spec.Fields) == 0 &&
+		int(spec.Stride) < spec.Size() {
+		v.add(at("stride"), "stride %d is smaller than the element size %d", spec.Stride, spec.Size())
+	}
+}
+
+// bankRange checks that the bank exists on the platform and the watch
+// lies inside it. Behind a pointer only the first pointer is checked.
+func (v *validator) bankRange(plan *ReadPlan, spec ReadSpec, at func(string) *yaml.Node) {
+	relativeTo := spec.Bank
+	if relativeTo == "" {
+		relativeTo = plan.DefaultBank()
+	}
+	if addr, ok := bankRelative(plan, relativeTo, int(spec.Address)); ok {
+		v.warn(at("address"), "address $%X is relative to %s %s, write $%X",
+			int(spec.Address),
+			plan.Platform,
+			relativeTo,
+			addr,
+		)
+	}
+
+	if bank, addr, ok := NormalizeAddress(plan, spec.Bank, int(spec.Address)); ok {
+		spec.Bank, spec.Address = bank, HexInt(addr)
+	}
+
+	bank := spec.Bank
+	if bank == "" {
+		bank = plan.DefaultBank()
+	}
+
+	if bank == "" || bank == ProcessMemory {
+		return
+	}
+
+	p, ok := LookupPlatform(plan.Platform)
+	if !ok {
+		return
+	}
+
+	m, ok := LookupBank(plan.Platform, bank)
+	if !ok {
+		v.add(at("bank"), "%s has no %s bank", plan.Platform, bank)
+		return
+	}
+
+	size := spec.Size()
+	switch {
+	case len(spec.Offsets) > 0:
+		size = PointerSize(plan, spec)
+	case spec.IsArray():
+		elem := spec
+		elem.Stride = 0
+		size = (spec.Count-1)*spec.ElementStride() + elem.ElementStride()
+	}
+
+	if target, _, ok := p.BusTarget(plan, uint64(spec.Address)); ok && target != bank &&
+		!m.Contains(int(spec.Address), 1) {
+		v.add(at("address"), "address $%X is in %s %s, not %s", int(spec.Address), plan.Platform, target, bank)
+		return
+	}
+
+	if !m.Contains(int(spec.Address), size) {
+		v.add(at("address"), "address $%X is outside %s %s ($%X-$%X)",
+			int(spec.Address),
+			plan.Platform,
+			bank,
+			m.Start,
+			m.Start+m.Size-1,
+		)
+	}
+}
+
+// value checks the type and size settings of a watch or array field.
+func (v *validator) value(spec ReadSpec, node *yaml.Node, nodes map[string]*yaml.Node) {
+	at := func(key string) *yaml.Node {
+		if n, ok := nodes[key]; ok {
+			return n
+		}
+		return node
+	}
+
+	if spec.Type == "" {
+		v.add(node, "missing type")
+		return
+	}
+
+	if !slices.Contains(ValueTypes, spec.Type) {
+		v.add(at("type"), "unknown type %q", spec.Type)
+		return
+	}
+
+	size := spec.Size()
+
+	switch spec.Type {
+	case String, UTF16LE:
+		if size <= 0 {
+			v.add(node, "%s watch needs stringLength or size", spec.Type)
+		}
+		return
+
+	case Bytes:
+		if size <= 0 {
+			v.add(node, "Bytes watch needs a size")
+		}
+		return
+	}
+
+	if size <= 0 || size > 8 {
+		v.add(at("size"), "size %d is not valid for %s, must be 1 to 8 bytes", size, spec.Type)
+		return
+	}
+
+	if spec.Bit != nil && (*spec.Bit < 0 || *spec.Bit >= size*8) {
+		v.add(at("bit"), "bit %d is outside a %d byte value", *spec.Bit, size)
+	}
+
+	if spec.Shift < 0 || spec.Shift >= size*8 {
+		v.add(at("shift"), "shift %d is outside a %d byte value", spec.Shift, size)
+	}
+
+	if spec.Type == BCD && spec.Digits > size*2 {
+		v.add(at("digits"), "%d digits do not fit in %d bytes", spec.Digits, size)
+	}
+
+	if (spec.Type == Fixed || spec.Type == UFixed) && spec.FracBits >= size*8 {
+		v.add(at("fracBits"), "fracBits %d is outside a %d byte value", spec.FracBits, size)
+	}
+}
+
+// decode decodes a mapping into the struct out one key at a time, so every
+// error is reported at the node that caused it. It returns the value nodes
+// by key, or nil when node is not a mapping.
+func (v *validator) decode(node *yaml.Node, out reflect.Value) map[string]*yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		v.add(node, "expected a mapping")
+		return nil
+	}
+
+	fields := yamlFields(out.Type())
+	nodes := make(map[string]*yaml.Node, len(node.Content)/2)
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		index, ok := fields[key.Value]
+		if !ok {
+			// ignored, a misspelt key or one of a newer version
+			v.warn(key, "unknown field %q", key.Value)
+			continue
+		}
+
+		if _, dup := nodes[key.Value]; dup {
+			v.add(key, "duplicate field %q", key.Value)
+			continue
+		}
+		nodes[key.Value] = value
+
+		field := out.FieldByIndex(index)
+
+		// lists of structs are decoded item by item for positions
+		if field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Struct {
+			if value.Kind != yaml.SequenceNode {
+				v.add(value, "%s must be a list", key.Value)
+				continue
+			}
+
+			v.decodeList(value, field.Addr().Interface())
+			continue
+		}
+
+		if err := value.Decode(field.Addr().Interface()); err != nil {
+			v.add(value, "%s: %s", key.Value, yamlErrorMessage(err))
+		}
+	}
+
+	return nodes
+}
+
+// decodeList decodes a list of mappings into out, a pointer to a slice of
+// structs.
+func (v *validator) decodeList(node *yaml.Node, out any) {
+	slice := reflect.ValueOf(out).Elem()
+	items := reflect.MakeSlice(slice.Type(), len(node.Content), len(node.Content))
+	for j, item := range node.Content {
+		v.decode(item, items.Index(j))
+	}
+	slice.Set(items)
+}
+
+// fieldMappingNodes returns the value nodes of a mapping by key without
+// reporting anything, decode already has.
+func fieldMappingNodes(node *yaml.Node) map[string]*yaml.Node {
+	nodes := make(map[string]*yaml.Node)
+	if node.Kind != yaml.MappingNode {
+		return nodes
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		nodes[node.Content[i].Value] = node.Content[i+1]
+	}
+	return nodes
+}
+
+// yamlFields maps yaml keys to struct field indexes, flattening inline
+// structs.
+func yamlFields(t reflect.Type) map[string][]int {
+	out := make(map[string][]int)
+
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+
+		if strings.Contains(opts, "inline") && f.Type.Kind() == reflect.Struct {
+			for key, index := range yamlFields(f.Type) {
+				out[key] = append([]int{i}, index...)
+			}
+			continue
+		}
+
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		out[name] = []int{i}
+	}
+
+	return out
+}
+
+func yamlErrorMessage(err error) string {
+	if typeErr, ok := err.(*yaml.TypeError); ok && len(typeErr.Errors) > 0 {
+		msg := typeErr.Errors[0]
+		if _, rest, ok := strings.Cut(msg, ": "); ok && strings.HasPrefix(msg, "line ") {
+			return rest
+		}
+		return msg
+	}
+
+	return strings.TrimPrefix(err.Error(), "yaml: ")
+}
@@ -0,0 +1,141 @@
+package emulator
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestValidateWarnings(t *testing.T) {
+	raw := `
+Name: Test
+Platform: SNES
+ReadInterval: 16
+futureKey: true
+Watches:
+  - name: hp-max
+    type: U8
+    address: 0x10
+`
+
+	diags := ValidateReadPlan([]byte(raw))
+	if len(diags) != 2 {
+		t.Fatalf("diagnostics %v, want 2 warnings", diags)
+	}
+	for _, d := range diags {
+		if !d.Warning {
+			t.Errorf("%v is an error, want a warning", d)
+		}
+	}
+	if HasErrors(diags) {
+		t.Errorf("HasErrors reported warnings as errors")
+	}
+
+	plan, err := NewReadPlan(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("plan with warnings did not load: %v", err)
+	}
+	if len(plan.Watches) != 1 || plan.Watches[0].Name != "hp-max" {
+		t.Errorf("watches %+v, want hp-max", plan.Watches)
+	}
+}
+
+func TestValidateErrorsStopLoading(t *testing.T) {
+	raw := `
+Name: Test
+Platform: SNES
+ReadInterval: 0
+futureKey: true
+Watches:
+  - name: hp
+    type: U8
+    address: 0x10
+`
+
+	if _, err := NewReadPlan(strings.NewReader(raw)); err == nil {
+		t.Fatalf("plan with errors loaded")
+	}
+}
+
+func TestBankRelativeGBWRAM(t *testing.T) {
+	raw := `
+Name: Test
+Platform: GB
+ReadInterval: 16
+Watches:
+  - name: coins
+    type: U8
+    bank: WRAM
+    address: 0x0010
+`
+
+	diags := ValidateReadPlan([]byte(raw))
+	if len(diags) != 1 || !diags[0].Warning || !strings.Contains(diags[0].Message, "write $C010") {
+		t.Fatalf("diagnostics %v, want a warning to write $C010", diags)
+	}
+
+	plan, err := NewReadPlan(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := plan.Watches[0]; got.Bank != WRAM || got.Address != 0xC010 {
+		t.Errorf("coins at %s $%X, want WRAM $C010", got.Bank, int(got.Address))
+	}
+}
+
+func TestBankRelativeGBWRAMDefaultBank(t *testing.T) {
+	raw := `
+Name: Test
+Platform: GB
+ReadInterval: 16
+Watches:
+  - name: coins
+    type: U8
+    address: 0x0123
+`
+
+	diags := ValidateReadPlan([]byte(raw))
+	if len(diags) != 1 || !diags[0].Warning || !strings.Contains(diags[0].Message, "write $C123") {
+		t.Fatalf("diagnostics %v, want a warning to write $C123", diags)
+	}
+
+	plan, err := NewReadPlan(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if got := plan.Watches[0]; got.Bank != WRAM || got.Address != 0xC123 {
+		t.Errorf("coins at %s $%X, want WRAM $C123", got.Bank, int(got.Address))
+	}
+
+	compiled := CompileReadPlan(plan, DefaultCapabilities, RetroArch)
+	if len(compiled.Unsupported) != 0 || len(compiled.Regions) != 1 {
+		t.Fatalf("compiled %+v, want one region", compiled)
+	}
+	if got := compiled.Regions[0]; got.Bank != WRAM || got.Start != 0xC123 {
+		t.Errorf("region at %s $%X, want WRAM $C123", got.Bank, got.Start)
+	}
+}
+
+func TestLoadReadPlanWarnings(t *testing.T) {
+	fsys := fstest.MapFS{"smw/readplan.yml": {Data: []byte(`
+Name: Test
+Platform: SNES
+ReadInterval: 16
+futureKey: true
+Watches:
+  - name: hp
+    type: U8
+    address: 0x10
+`)}}
+
+	plan, diags, err := LoadReadPlan(fsys, "smw/readplan.yml")
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if plan.Name != "Test" {
+		t.Errorf("loaded %q, want Test", plan.Name)
+	}
+	if len(diags) != 1 || !diags[0].Warning || diags[0].File != "smw/readplan.yml" {
+		t.Errorf("diagnostics %+v, want the unknown field warning of smw/readplan.yml", diags)
+	}
+}
@@ -1,66 +1,257 @@
 package emulator
 
 import (
+	"bytes"
 	"encoding/binary"
+	"math"
 	"math/bits"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
-// GB/GBC/GBA/SNES/NES/DS/3DS/PSX = little endian
-// Genesis/N64 = big endian
+// DecodeValue decodes raw using the byte order of readSpec.Endian.
+// Word-swapped values must already be restored to the console's order, see
+// unswapWords.
 func DecodeValue(readSpec ReadSpec, raw []byte) *Value {
-	val := Value{
-		Type: readSpec.Type,
-		Name: readSpec.Name,
+	val := Value{}
+	if !decodeValue(readSpec, raw, &val, nil) {
+		return nil
 	}
+	return &val
+}
 
-	need := readSpec.Size()
+// valueBuffer is the memory a watch's Bytes and UTF16LE values are decoded
+// in, kept by the compiled plan so decoding them does not allocate. Bytes
+// alternate between two halves, the engine compares a value with the one
+// of the tick before. A nil valueBuffer allocates.
+type valueBuffer struct {
+	bytes []byte
+	half  int
+	text  []byte
+}
 
-	var u uint64
+// take returns n bytes, not the ones the last call returned.
+func (b *valueBuffer) take(n int) []byte {
+	if b == nil {
+		return make([]byte, n)
+	}
+	if len(b.bytes) < 2*n {
+		b.bytes = make([]byte, 2*n)
+	}
 
-	switch need {
-	case 1:
-		u = uint64(raw[0])
+	at := b.half * n
+	b.half ^= 1
+	return b.bytes[at : at+n : at+n]
+}
 
-	case 2:
-		u = uint64(binary.LittleEndian.Uint16(raw))
+// appendValue decodes raw into the next slot of vals, reusing the slots
+// left over from the previous tick and buf. It reports false when raw
+// cannot be decoded.
+func appendValue(vals []Value, readSpec ReadSpec, raw []byte, buf *valueBuffer) ([]Value, bool) {
+	n := len(vals)
+	if n < cap(vals) {
+		vals = vals[:n+1]
+	} else {
+		vals = append(vals, Value{})
+	}
 
-	case 4:
-		u = uint64(binary.LittleEndian.Uint32(raw))
+	if !decodeValue(readSpec, raw, &vals[n], buf) {
+		return vals[:n], false
+	}
+	return vals, true
+}
 
-	case 8:
-		u = binary.LittleEndian.Uint64(raw)
+// decodeValue overwrites val with raw decoded. A String that did not change
+// since val was last decoded keeps its memory.
+func decodeValue(readSpec ReadSpec, raw []byte, val *Value, buf *valueBuffer) bool {
+	prev := val.String
+	*val = Value{
+		Type:  readSpec.Type,
+		Name:  readSpec.Name,
+		Array: readSpec.Array,
+		Index: readSpec.Index,
+		Field: readSpec.Field,
+	}
 
-	default:
-		return nil
+	switch readSpec.Type {
+	case String:
+		if n := bytes.IndexByte(raw, 0); n >= 0 {
+			raw = raw[:n]
+		}
+		if string(raw) == prev {
+			val.String = prev
+		} else {
+			val.String = string(raw)
+		}
+		return true
+
+	case UTF16LE:
+		var text []byte
+		if buf != nil {
+			text = buf.text[:0]
+		}
+		text = appendUTF16(text, raw)
+		if buf != nil {
+			buf.text = text
+		}
+
+		if string(text) == prev {
+			val.String = prev
+		} else {
+			val.String = string(text)
+		}
+		return true
+
+	case Bytes:
+		// copied, the engine keeps the previous tick's value
+		val.Bytes = buf.take(len(raw))
+		copy(val.Bytes, raw)
+		return true
+	}
+
+	need := readSpec.Size()
+	if need < 1 || need > 8 || len(raw) < need {
+		return false
+	}
+
+	bigEndian := readSpec.Endian.Big()
+
+	var u uint64
+	for i := range need {
+		if bigEndian {
+			u = u<<8 | uint64(raw[i])
+		} else {
+			u |= uint64(raw[i]) << (8 * i)
+		}
 	}
 
 	switch readSpec.Type {
 
-	case I8:
-		val.Signed = int64(int8(raw[0]))
+	case I8, I16, I24, I32, I64:
+		field, width := readSpec.bitfield(u, need*8)
+		val.Signed = signExtend(field, width)
 
-	case I16:
-		val.Signed = int64(int16(binary.LittleEndian.Uint16(raw)))
+	case U8, U16, U24, U32, U64:
+		val.Unsigned, _ = readSpec.bitfield(u, need*8)
 
-	case I32:
-		val.Signed = int64(int32(binary.LittleEndian.Uint32(raw)))
+	case F32:
+		val.Float32 = math.Float32frombits(uint32(u))
 
-	case I64:
-		val.Signed = int64(binary.LittleEndian.Uint64(raw))
+	case F64:
+		val.Float64 = math.Float64frombits(u)
 
-	case U8, U16, U32, U64:
-		val.Unsigned = u
+	case BCD:
+		var ok bool
+		if val.Unsigned, ok = decodeBCD(u, readSpec.Digits); !ok {
+			return false
+		}
+
+	case Fixed:
+		val.Float64 = math.Ldexp(float64(signExtend(u, need*8)), -readSpec.FractionBits())
+
+	case UFixed:
+		val.Float64 = math.Ldexp(float64(u), -readSpec.FractionBits())
 
 	case Bool:
-		val.Bool = u != 0
+		field, _ := readSpec.bitfield(u, need*8)
+		val.Bool = field != 0
 
 	case FlagCount:
-		if readSpec.Mask != 0 {
-			u &= 0x3FFF
+		field, _ := readSpec.bitfield(u, need*8)
+		val.FlagCount = bits.OnesCount64(field)
+	}
+
+	return true
+}
+
+// appendUTF16 appends the UTF-8 of the UTF-16LE text in raw up to its
+// first NUL, unpaired surrogates become U+FFFD as utf16.Decode makes them.
+func appendUTF16(text, raw []byte) []byte {
+	for i := 0; i+1 < len(raw); i += 2 {
+		r := rune(binary.LittleEndian.Uint16(raw[i:]))
+		if r == 0 {
+			break
+		}
+
+		if utf16.IsSurrogate(r) {
+			next := rune(utf8.RuneError)
+			if i+3 < len(raw) {
+				next = rune(binary.LittleEndian.Uint16(raw[i+2:]))
+			}
+			if pair := utf16.DecodeRune(r, next); pair != utf8.RuneError {
+				r = pair
+				i += 2
+			} else {
+				r = utf8.RuneError
+			}
 		}
 
-		val.FlagCount = bits.OnesCount64(u)
+		text = utf8.AppendRune(text, r)
 	}
+	return text
+}
 
-	return &val
+// IsBitfield reports whether the watch is a part of its bytes, read with
+// bit, or mask and shift.
+func (r ReadSpec) IsBitfield() bool {
+	return r.Bit != nil || r.Mask != 0 || r.Shift != 0
+}
+
+// bitfield applies bit, or mask then shift, to a raw integer of width bits.
+// It returns the field and its width for sign extension.
+func (r ReadSpec) bitfield(u uint64, width int) (uint64, int) {
+	if r.Bit != nil {
+		return (u >> *r.Bit) & 1, 1
+	}
+
+	if r.Mask != 0 {
+		u &= uint64(r.Mask)
+		width = bits.Len64(uint64(r.Mask))
+	}
+
+	if r.Shift > 0 {
+		u >>= r.Shift
+		width -= r.Shift
+	}
+
+	return u, max(width, 1)
+}
+
+func signExtend(u uint64, width int) int64 {
+	shift := 64 - width
+	return int64(u<<shift) >> shift
+}
+
+// decodeBCD decodes packed BCD, lowest digit in the lowest nibble.
+// digits limits how many nibbles are used, 0 uses all of them. It reports
+// false when a nibble is not a decimal digit.
+func decodeBCD(u uint64, digits int) (uint64, bool) {
+	if digits <= 0 || digits > 16 {
+		digits = 16
+	}
+
+	var out uint64
+	scale := uint64(1)
+
+	for range digits {
+		digit := u & 0xF
+		if digit > 9 {
+			return 0, false
+		}
+		out += digit * scale
+		scale *= 10
+		u >>= 4
+	}
+
+	return out, true
+}
+
+// unswapWords copies len(dst) bytes at addr out of word-swapped memory that
+// begins at start, restoring big-endian order. buf must cover the 32-bit
+// words around the value.
+func unswapWords(dst, buf []byte, start, addr int) []byte {
+	for i := range dst {
+		dst[i] = buf[((addr+i)^3)-start]
+	}
+	return dst
 }
@@ -0,0 +1,305 @@
+package emulator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestDecodeBCD(t *testing.T) {
+	tests := []struct {
+		raw    []byte
+		digits int
+		want   uint64
+		ok     bool
+	}{
+		{[]byte{0x34, 0x12}, 0, 1234, true},
+		{[]byte{0x99, 0x99}, 0, 9999, true},
+		{[]byte{0x34, 0x12}, 2, 34, true},
+		// only the digits used are checked
+		{[]byte{0x34, 0xF2}, 3, 234, true},
+		{[]byte{0x3A, 0x12}, 0, 0, false},
+		{[]byte{0x34, 0xF2}, 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		spec := ReadSpec{Name: "bcd", Type: BCD, SizeOverride: len(tt.raw), Digits: tt.digits, Endian: LittleEndian}
+
+		val := DecodeValue(spec, tt.raw)
+		if (val != nil) != tt.ok {
+			t.Errorf("% X digits=%d decoded=%v, want %v", tt.raw, tt.digits, val != nil, tt.ok)
+			continue
+		}
+		if val != nil && val.Unsigned != tt.want {
+			t.Errorf("% X digits=%d = %d, want %d", tt.raw, tt.digits, val.Unsigned, tt.want)
+		}
+	}
+}
+
+func TestDecodeBitfield(t *testing.T) {
+	bit := func(n int) *int { return &n }
+
+	tests := []struct {
+		name string
+		spec ReadSpec
+		raw  []byte
+		want Value
+	}{
+		{
+			"U8 mask and shift",
+			ReadSpec{Type: U8, Mask: 0x1C, Shift: 2},
+			[]byte{0b1111_0111},
+			Value{Unsigned: 0b101},
+		},
+		{
+			"U16 mask and shift",
+			ReadSpec{Type: U16, Mask: 0x0FF0, Shift: 4},
+			[]byte{0x4F, 0xF3},
+			Value{Unsigned: 0x34},
+		},
+		{
+			"I8 negative high nibble",
+			ReadSpec{Type: I8, Mask: 0xF0, Shift: 4},
+			[]byte{0xE3},
+			Value{Signed: -2},
+		},
+		{
+			"I8 positive high nibble",
+			ReadSpec{Type: I8, Mask: 0xF0, Shift: 4},
+			[]byte{0x73},
+			Value{Signed: 7},
+		},
+		{
+			"I16 masked without shift",
+			ReadSpec{Type: I16, Mask: 0x0F00},
+			[]byte{0xFF, 0x08},
+			Value{Signed: 0x800 - 0x1000},
+		},
+		{
+			"Bool bit set",
+			ReadSpec{Type: Bool, Bit: bit(3)},
+			[]byte{0x08},
+			Value{Bool: true},
+		},
+		{
+			"Bool bit clear",
+			ReadSpec{Type: Bool, Bit: bit(3)},
+			[]byte{0xF7},
+			Value{Bool: false},
+		},
+		{
+			"Bool bit beyond the first byte",
+			ReadSpec{Type: Bool, Bit: bit(10)},
+			[]byte{0x00, 0x04},
+			Value{Bool: true},
+		},
+		{
+			"Bool bit beyond the first byte, big endian",
+			ReadSpec{Type: Bool, Bit: bit(10), Endian: BigEndian},
+			[]byte{0x04, 0x00},
+			Value{Bool: true},
+		},
+	}
+
+	for _, tt := range tests {
+		if tt.spec.Endian == "" {
+			tt.spec.Endian = LittleEndian
+		}
+		if size := tt.spec.Size(); size != len(tt.raw) {
+			t.Errorf("%s: size %d, want %d", tt.name, size, len(tt.raw))
+			continue
+		}
+
+		val := DecodeValue(tt.spec, tt.raw)
+		if val == nil {
+			t.Errorf("%s: not decoded", tt.name)
+			continue
+		}
+		if val.Unsigned != tt.want.Unsigned || val.Signed != tt.want.Signed || val.Bool != tt.want.Bool {
+			t.Errorf("%s: % X = unsigned %d signed %d bool %v, want %d %d %v", tt.name, tt.raw,
+				val.Unsigned, val.Signed, val.Bool,
+				tt.want.Unsigned, tt.want.Signed, tt.want.Bool,
+			)
+		}
+	}
+}
+
+func TestValueRoundTrip(t *testing.T) {
+	tests := []struct {
+		spec ReadSpec
+		v    Value
+		// the value's bytes little-endian, strings and raw bytes have no
+		// byte order
+		le []byte
+	}{
+		{ReadSpec{Type: U8}, Value{Unsigned: 0xAB}, []byte{0xAB}},
+		{ReadSpec{Type: I8}, Value{Signed: -2}, []byte{0xFE}},
+		{ReadSpec{Type: U16}, Value{Unsigned: 0x1234}, []byte{0x34, 0x12}},
+		{ReadSpec{Type: I16}, Value{Signed: -2}, []byte{0xFE, 0xFF}},
+		{ReadSpec{Type: U24}, Value{Unsigned: 0x123456}, []byte{0x56, 0x34, 0x12}},
+		{ReadSpec{Type: I24}, Value{Signed: -2}, []byte{0xFE, 0xFF, 0xFF}},
+		{ReadSpec{Type: I24}, Value{Signed: -0x800000}, []byte{0x00, 0x00, 0x80}},
+		{ReadSpec{Type: U32}, Value{Unsigned: 0x12345678}, []byte{0x78, 0x56, 0x34, 0x12}},
+		{ReadSpec{Type: I32}, Value{Signed: math.MinInt32}, []byte{0x00, 0x00, 0x00, 0x80}},
+		{ReadSpec{Type: U64}, Value{Unsigned: 0x0102030405060708}, []byte{0x08, 0x07, 0x06, 0x05, 0x04, 0x03, 0x02, 0x01}},
+		{ReadSpec{Type: I64}, Value{Signed: -2}, []byte{0xFE, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}},
+		{ReadSpec{Type: F32}, Value{Float32: 1.5}, []byte{0x00, 0x00, 0xC0, 0x3F}},
+		{ReadSpec{Type: F64}, Value{Float64: -2.5}, []byte{0, 0, 0, 0, 0, 0, 0x04, 0xC0}},
+		{ReadSpec{Type: BCD, Digits: 4}, Value{Unsigned: 1234}, []byte{0x34, 0x12}},
+		{ReadSpec{Type: BCD, Digits: 6}, Value{Unsigned: 123456}, []byte{0x56, 0x34, 0x12}},
+		{ReadSpec{Type: Fixed}, Value{Float64: -1.5}, []byte{0x00, 0x80, 0xFE, 0xFF}},
+		{ReadSpec{Type: Fixed, SizeOverride: 2}, Value{Float64: 1.25}, []byte{0x40, 0x01}},
+		{ReadSpec{Type: UFixed}, Value{Float64: 2.5}, []byte{0x00, 0x80, 0x02, 0x00}},
+		{ReadSpec{Type: UFixed, SizeOverride: 2, FracBits: 4}, Value{Float64: 100.0625}, []byte{0x41, 0x06}},
+		{ReadSpec{Type: Bool}, Value{Bool: true}, []byte{0x01}},
+		{ReadSpec{Type: String, StringLength: 6}, Value{String: "MARIO"}, []byte("MARIO\x00")},
+		{ReadSpec{Type: UTF16LE, StringLength: 4}, Value{String: "Hé"}, []byte{0x48, 0x00, 0xE9, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{ReadSpec{Type: Bytes, SizeOverride: 3}, Value{Bytes: []byte{0x01, 0x02, 0x03}}, []byte{0x01, 0x02, 0x03}},
+	}
+
+	const start, addr = 0x100, 0x101
+
+	var vals []Value
+	for _, tt := range tests {
+		for _, endian := range Endians {
+			spec := tt.spec
+			spec.Name = "v"
+			spec.Endian = endian
+			name := fmt.Sprintf("%s size=%d %s", spec.Type, spec.Size(), endian)
+
+			ordered := spec.Type != String && spec.Type != UTF16LE && spec.Type != Bytes
+			want := tt.le
+			if ordered && endian != LittleEndian {
+				want = slices.Clone(tt.le)
+				slices.Reverse(want)
+			}
+
+			// lay the value out in memory the way the game stores it
+			buf := make([]byte, 16)
+			for i, b := range want {
+				at := addr + i
+				if endian == WordSwapped {
+					at ^= 3
+				}
+				buf[at-start] = b
+			}
+
+			region := &MergedRegion{
+				Start:   start,
+				Size:    len(buf),
+				Buffer:  buf,
+				Watches: []ResolvedWatch{{Spec: spec, Addr: addr, Size: spec.Size(), Offset: addr - start}},
+			}
+
+			// the slots of the previous value are reused
+			vals = DecodeRegion(region, vals[:0])
+			if len(vals) != 1 {
+				t.Errorf("%s: decoded %d values from % X", name, len(vals), buf)
+				continue
+			}
+
+			got := vals[0]
+			expected := tt.v
+			expected.Type, expected.Name = spec.Type, spec.Name
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("%s: decoded % X to %+v, want %+v", name, buf, got, expected)
+				continue
+			}
+
+			// word-swapped values encode big-endian, as they decode
+			data, err := EncodeValue(spec, got)
+			if err != nil {
+				t.Errorf("%s: encode: %v", name, err)
+				continue
+			}
+			if !bytes.Equal(data, want) {
+				t.Errorf("%s: encoded % X, want % X", name, data, want)
+			}
+		}
+	}
+}
+
+func TestDecodeRegionSkipsBadBCD(t *testing.T) {
+	region := &MergedRegion{
+		Start:  0x100,
+		Size:   4,
+		Buffer: []byte{0x05, 0x1A, 0x34, 0x12},
+		Watches: []ResolvedWatch{
+			{Spec: ReadSpec{Name: "lives", Type: U8}, Addr: 0x100, Size: 1, Offset: 0},
+			{Spec: ReadSpec{Name: "timer", Type: BCD, Digits: 2}, Addr: 0x101, Size: 1, Offset: 1},
+			{Spec: ReadSpec{Name: "score", Type: BCD, Digits: 4}, Addr: 0x102, Size: 2, Offset: 2},
+		},
+	}
+
+	// the second tick logs nothing and still decodes the others
+	for range 2 {
+		vals := DecodeRegion(region, nil)
+		if len(vals) != 2 || vals[0].Name != "lives" || vals[0].Unsigned != 5 ||
+			vals[1].Name != "score" || vals[1].Unsigned != 1234 {
+			t.Fatalf("decoded %+v, want lives=5 and score=1234 without timer", vals)
+		}
+	}
+
+	region.Buffer[1] = 0x19
+	if vals := DecodeRegion(region, nil); len(vals) != 3 || vals[1].Unsigned != 19 {
+		t.Fatalf("decoded %+v, want timer=19 once its nibbles are decimal", vals)
+	}
+}
+
+func TestAppendUTF16(t *testing.T) {
+	tests := [][]uint16{
+		{'H', 0xE9},
+		{0xD83D, 0xDE00, '!'},
+		{'a', 0xD83D},
+		{0xDE00, 'b'},
+		{0xD83D, 'c', 0xD83D, 0xD83D, 0xDE00},
+		{'d', 0, 'e'},
+	}
+
+	for _, units := range tests {
+		raw := make([]byte, 2*len(units))
+		for i, u := range units {
+			binary.LittleEndian.PutUint16(raw[2*i:], u)
+		}
+
+		end := slices.Index(units, 0)
+		if end < 0 {
+			end = len(units)
+		}
+		want := string(utf16.Decode(units[:end]))
+
+		if got := string(appendUTF16(nil, raw)); got != want {
+			t.Errorf("% X decoded to %q, want %q", raw, got, want)
+		}
+	}
+}
+
+func TestBytesKeepPreviousTick(t *testing.T) {
+	spec := ReadSpec{Name: "items", Type: Bytes, SizeOverride: 2}
+	var buf valueBuffer
+
+	var last, cur Value
+	decodeValue(spec, []byte{1, 2}, &last, &buf)
+	decodeValue(spec, []byte{3, 4}, &cur, &buf)
+
+	if !bytes.Equal(last.Bytes, []byte{1, 2}) || !bytes.Equal(cur.Bytes, []byte{3, 4}) {
+		t.Fatalf("decoded % X then % X, want 01 02 then 03 04", last.Bytes, cur.Bytes)
+	}
+}
+
+func TestFlagCountNotWritable(t *testing.T) {
+	spec := ReadSpec{Name: "flags", Type: FlagCount, Mask: 0xFF}
+
+	val := DecodeValue(spec, []byte{0b1011_0001})
+	if val == nil || val.FlagCount != 4 {
+		t.Fatalf("decoded %+v, want 4 flags", val)
+	}
+	if _, err := EncodeValue(spec, *val); err == nil {
+		t.Errorf("encoded a FlagCount")
+	}
+}
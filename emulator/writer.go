@@ -0,0 +1,307 @@
+package emulator
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"unicode/utf16"
+)
+
+var ErrWritesDisabled = errors.New("memory writes are disabled")
+
+// MemoryWriter is implemented by backends that can write game memory, it
+// writes v to the memory of a watch of plan.
+type MemoryWriter interface {
+	WriteValue(plan *ReadPlan, spec ReadSpec, v Value) error
+}
+
+// Write is a value ready to be sent to a backend.
+type Write struct {
+	Addr   int
+	Domain string
+	Data   []byte
+}
+
+// ReadBackFunc fills r.Data with the memory at r.Addr in r.Domain, the
+// backend address and domain a Write goes to.
+type ReadBackFunc func(r Write) error
+
+// PrepareWrite encodes v in the watch's byte order and translates its
+// address for backend. A word-swapped value that is not whole words is
+// split into one write per word it touches. A bit field is written over
+// the bytes it lies in as read through readBack, which may be nil for
+// backends that cannot read them. The game may change the other bits
+// between the read and the write.
+func PrepareWrite(
+	plan *ReadPlan,
+	backend Backend,
+	spec ReadSpec,
+	v Value,
+	readBack ReadBackFunc,
+) ([]Write, error) {
+	spec.Endian = WatchEndian(plan, spec)
+
+	if err := writable(spec); err != nil {
+		return nil, err
+	}
+
+	addr, domain, err := Translate(plan, backend, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	if spec.IsBitfield() {
+		if readBack == nil {
+			return nil, fmt.Errorf("%s is a bit field and its bytes cannot be read back", spec.Name)
+		}
+
+		cur, err := readCurrent(spec, addr, domain, readBack)
+		if err != nil {
+			return nil, fmt.Errorf("read %s before writing it: %w", spec.Name, err)
+		}
+		data, err = EncodeField(spec, cur, v)
+		if err != nil {
+			return nil, err
+		}
+	} else if data, err = EncodeValue(spec, v); err != nil {
+		return nil, err
+	}
+
+	if !spec.Endian.Swapped() {
+		return []Write{{Addr: addr, Domain: domain, Data: data}}, nil
+	}
+
+	// byte i of the value is stored at (addr+i)^3, the bytes within a word
+	// are contiguous in reverse
+	var writes []Write
+	for i := 0; i < len(data); {
+		n := min(4-(addr+i)&3, len(data)-i)
+
+		piece := make([]byte, n)
+		for k := range n {
+			piece[n-1-k] = data[i+k]
+		}
+		at := (addr + i + n - 1) ^ 3
+
+		if last := len(writes) - 1; last >= 0 && writes[last].Addr+len(writes[last].Data) == at {
+			writes[last].Data = append(writes[last].Data, piece...)
+		} else {
+			writes = append(writes, Write{Addr: at, Domain: domain, Data: piece})
+		}
+		i += n
+	}
+
+	return writes, nil
+}
+
+// readCurrent reads the bytes a watch lies in at its backend address, in
+// the order DecodeValue takes them.
+func readCurrent(spec ReadSpec, addr int, domain string, readBack ReadBackFunc) ([]byte, error) {
+	size := spec.Size()
+	if !spec.Endian.Swapped() {
+		cur := make([]byte, size)
+		return cur, readBack(Write{Addr: addr, Domain: domain, Data: cur})
+	}
+
+	// the whole words around the value, as they are stored
+	start, end := addr&^3, (addr+size+3)&^3
+	words := make([]byte, end-start)
+	if err := readBack(Write{Addr: start, Domain: domain, Data: words}); err != nil {
+		return nil, err
+	}
+	return unswapWords(make([]byte, size), words, start, addr), nil
+}
+
+// writable reports why a watch cannot be written, nil when it can.
+func writable(spec ReadSpec) error {
+	switch {
+	case len(spec.Offsets) > 0:
+		return fmt.Errorf("%s is behind a pointer and cannot be written", spec.Name)
+	case spec.IsArray():
+		return fmt.Errorf("%s is an array and cannot be written", spec.Name)
+	case spec.Type == FlagCount:
+		return fmt.Errorf("%s is a FlagCount and cannot be written", spec.Name)
+	}
+	return nil
+}
+
+// EncodeField encodes v into cur, the bytes a bit field watch lies in as
+// DecodeValue takes them, keeping the bits outside the field.
+func EncodeField(spec ReadSpec, cur []byte, v Value) ([]byte, error) {
+	if err := writable(spec); err != nil {
+		return nil, err
+	}
+
+	size := spec.Size()
+	if len(cur) != size || size > 8 {
+		return nil, fmt.Errorf("%s lies in %d bytes, got %d", spec.Name, size, len(cur))
+	}
+
+	bigEndian := spec.Endian.Big()
+	width := 8 * size
+
+	var u uint64
+	for i, b := range cur {
+		if bigEndian {
+			u = u<<8 | uint64(b)
+		} else {
+			u |= uint64(b) << (8 * i)
+		}
+	}
+
+	// the bits bitfield reads
+	var mask uint64
+	shift := spec.Shift
+	switch {
+	case spec.Bit != nil:
+		mask, shift = 1<<*spec.Bit, *spec.Bit
+	case spec.Mask != 0:
+		mask = uint64(spec.Mask)
+	default:
+		mask = math.MaxUint64 >> (64 - width) &^ (1<<shift - 1)
+	}
+
+	var field uint64
+	switch spec.Type {
+	case U8, U16, U24, U32, U64:
+		field = v.Unsigned
+	case I8, I16, I24, I32, I64:
+		field = uint64(v.Signed)
+	case Bool:
+		// every bit of the field, any of them reads as true
+		if v.Bool {
+			field = mask >> shift
+		}
+	default:
+		return nil, fmt.Errorf("%s is a %s bit field and cannot be written", spec.Name, spec.Type)
+	}
+
+	u = u&^mask | field<<shift&mask
+
+	// what reads back has to be the value, not cut to the field
+	got, fieldWidth := spec.bitfield(u, width)
+	var fits bool
+	switch spec.Type {
+	case I8, I16, I24, I32, I64:
+		fits = signExtend(got, fieldWidth) == v.Signed
+	case Bool:
+		fits = (got != 0) == v.Bool
+	default:
+		fits = got == field
+	}
+	if !fits {
+		return nil, fmt.Errorf("%s does not fit in its %d bits", spec.Name, bits.OnesCount64(mask))
+	}
+
+	return putUint(make([]byte, size), u, bigEndian), nil
+}
+
+// EncodeValue is the inverse of DecodeValue, the bytes to write so spec
+// reads back as v. Word-swapped values are encoded big-endian, as
+// DecodeValue takes them, PrepareWrite swaps them. Watches that are a part
+// of their bytes (bit, mask, shift) are encoded by EncodeField, those
+// behind a pointer and arrays cannot be written.
+func EncodeValue(spec ReadSpec, v Value) ([]byte, error) {
+	if spec.IsBitfield() {
+		return nil, fmt.Errorf("%s is a bit field, encode it over its bytes", spec.Name)
+	}
+	if err := writable(spec); err != nil {
+		return nil, err
+	}
+
+	size := spec.Size()
+
+	switch spec.Type {
+	case String:
+		out := make([]byte, size)
+		copy(out, v.String)
+		return out, nil
+
+	case UTF16LE:
+		out := make([]byte, size)
+		for i, unit := range utf16.Encode([]rune(v.String)) {
+			if 2*i+1 >= size {
+				break
+			}
+			out[2*i], out[2*i+1] = byte(unit), byte(unit>>8)
+		}
+		return out, nil
+
+	case Bytes:
+		if len(v.Bytes) != size {
+			return nil, fmt.Errorf("%s needs %d bytes, got %d", spec.Name, size, len(v.Bytes))
+		}
+		return append([]byte(nil), v.Bytes...), nil
+	}
+
+	if size < 1 || size > 8 {
+		return nil, fmt.Errorf("unsupported size %d", size)
+	}
+
+	var u uint64
+	switch spec.Type {
+	case I8, I16, I24, I32, I64:
+		u = uint64(v.Signed)
+	case U8, U16, U24, U32, U64:
+		u = v.Unsigned
+	case F32:
+		u = uint64(math.Float32bits(v.Float32))
+	case F64:
+		u = math.Float64bits(v.Float64)
+	case BCD:
+		u = encodeBCD(v.Unsigned)
+	case Fixed:
+		u = uint64(int64(math.Round(math.Ldexp(v.Float64, spec.FractionBits()))))
+	case UFixed:
+		u = uint64(math.Round(math.Ldexp(v.Float64, spec.FractionBits())))
+	case Bool:
+		if v.Bool {
+			u = 1
+		}
+	}
+
+	if !fits(spec.Type, u, size) {
+		return nil, fmt.Errorf("%s does not fit in %d bytes", spec.Name, size)
+	}
+
+	return putUint(make([]byte, size), u, spec.Endian.Big()), nil
+}
+
+// putUint stores the low len(out) bytes of u in out.
+func putUint(out []byte, u uint64, bigEndian bool) []byte {
+	size := len(out)
+	for i := range size {
+		shift := 8 * i
+		if bigEndian {
+			shift = 8 * (size - 1 - i)
+		}
+		out[i] = byte(u >> shift)
+	}
+	return out
+}
+
+// fits reports whether an encoded value survives being cut to size bytes,
+// signed values must sign extend back to themselves.
+func fits(t ValueType, u uint64, size int) bool {
+	if size == 8 || t == F32 || t == F64 || t == Bool {
+		return true
+	}
+
+	bits := 8 * size
+	switch t {
+	case I8, I16, I24, I32, I64, Fixed:
+		return int64(u)<<(64-bits)>>(64-bits) == int64(u)
+	}
+	return u>>bits == 0
+}
+
+func encodeBCD(v uint64) uint64 {
+	var out uint64
+	for shift := 0; v > 0 && shift < 64; shift += 4 {
+		out |= (v % 10) << shift
+		v /= 10
+	}
+	return out
+}
@@ -0,0 +1,206 @@
+package emulator
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPrepareWriteWordSwapped(t *testing.T) {
+	plan := &ReadPlan{Platform: "N64"}
+
+	tests := []struct {
+		typ   ValueType
+		value Value
+	}{
+		{U8, Value{Unsigned: 0xAB}},
+		{U16, Value{Unsigned: 0xABCD}},
+		{Bool, Value{Bool: true}},
+		{U24, Value{Unsigned: 0xABCDEF}},
+		{U32, Value{Unsigned: 0x89ABCDEF}},
+		{U64, Value{Unsigned: 0x0123456789ABCDEF}},
+	}
+
+	for _, tt := range tests {
+		for addr := 0x100; addr < 0x104; addr++ {
+			spec := ReadSpec{Name: "v", Type: tt.typ, Bank: RDRAM, Address: HexInt(addr), Endian: WordSwapped}
+
+			writes, err := PrepareWrite(plan, RetroArch, spec, tt.value, nil)
+			if err != nil {
+				t.Fatalf("%s at 0x%x: %v", tt.typ, addr, err)
+			}
+
+			mem := make([]byte, 0x200)
+			for _, w := range writes {
+				copy(mem[w.Addr:], w.Data)
+			}
+
+			// only the value's own bytes change
+			size := spec.Size()
+			for i := range mem {
+				if i^3 >= addr && i^3 < addr+size {
+					continue
+				}
+				if mem[i] != 0 {
+					t.Fatalf("%s at 0x%x wrote byte 0x%x outside the value", tt.typ, addr, i)
+				}
+			}
+
+			raw := unswapWords(make([]byte, size), mem, 0, addr)
+			got := DecodeValue(spec, raw)
+			if got == nil || got.Unsigned != tt.value.Unsigned || got.Bool != tt.value.Bool {
+				t.Errorf("%s at 0x%x reads back %+v, want %+v", tt.typ, addr, got, tt.value)
+			}
+		}
+	}
+}
+
+func TestPrepareWriteWordSwappedWholeWords(t *testing.T) {
+	plan := &ReadPlan{Platform: "N64"}
+	spec := ReadSpec{Name: "v", Type: U64, Bank: RDRAM, Address: 0x100, Endian: WordSwapped}
+
+	writes, err := PrepareWrite(plan, RetroArch, spec, Value{Unsigned: 0x0123456789ABCDEF}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(writes) != 1 || writes[0].Addr != 0x100 {
+		t.Fatalf("aligned words written as %+v, want one write at 0x100", writes)
+	}
+
+	want := []byte{0x67, 0x45, 0x23, 0x01, 0xEF, 0xCD, 0xAB, 0x89}
+	if string(writes[0].Data) != string(want) {
+		t.Errorf("data % X, want % X", writes[0].Data, want)
+	}
+}
+
+func TestPrepareWriteBitfield(t *testing.T) {
+	bit := func(n int) *int { return &n }
+
+	tests := []struct {
+		name  string
+		plan  string
+		spec  ReadSpec
+		mem   []byte
+		value Value
+		want  []byte
+		err   bool
+	}{
+		{
+			name:  "set a flag",
+			plan:  "SNES",
+			spec:  ReadSpec{Type: Bool, Bit: bit(3)},
+			mem:   []byte{0xF0},
+			value: Value{Bool: true},
+			want:  []byte{0xF8},
+		},
+		{
+			name:  "clear a flag",
+			plan:  "SNES",
+			spec:  ReadSpec{Type: Bool, Bit: bit(3)},
+			mem:   []byte{0xFF},
+			value: Value{Bool: false},
+			want:  []byte{0xF7},
+		},
+		{
+			name:  "flag beyond the first byte",
+			plan:  "SNES",
+			spec:  ReadSpec{Type: Bool, Bit: bit(10)},
+			mem:   []byte{0x12, 0x00},
+			value: Value{Bool: true},
+			want:  []byte{0x12, 0x04},
+		},
+		{
+			name:  "mask and shift",
+			plan:  "SNES",
+			spec:  ReadSpec{Type: U8, Mask: 0x1C, Shift: 2},
+			mem:   []byte{0xFF},
+			value: Value{Unsigned: 0b010},
+			want:  []byte{0xEB},
+		},
+		{
+			name:  "negative nibble",
+			plan:  "SNES",
+			spec:  ReadSpec{Type: I8, Mask: 0xF0, Shift: 4},
+			mem:   []byte{0x03},
+			value: Value{Signed: -2},
+			want:  []byte{0xE3},
+		},
+		{
+			name:  "value wider than the field",
+			plan:  "SNES",
+			spec:  ReadSpec{Type: U8, Mask: 0x0C, Shift: 2},
+			mem:   []byte{0x00},
+			value: Value{Unsigned: 4},
+			err:   true,
+		},
+		{
+			name:  "big endian word",
+			plan:  "SNES",
+			spec:  ReadSpec{Type: U16, Mask: 0x0FF0, Shift: 4, Endian: BigEndian},
+			mem:   []byte{0xA0, 0x0B},
+			value: Value{Unsigned: 0x12},
+			want:  []byte{0xA1, 0x2B},
+		},
+	}
+
+	for _, tt := range tests {
+		plan := &ReadPlan{Platform: tt.plan}
+		tt.spec.Name, tt.spec.Bank, tt.spec.Address = tt.name, WRAM, 0x10
+
+		mem := make([]byte, 0x20)
+		copy(mem[0x10:], tt.mem)
+		readBack := func(r Write) error {
+			copy(r.Data, mem[r.Addr:])
+			return nil
+		}
+
+		writes, err := PrepareWrite(plan, NWA, tt.spec, tt.value, readBack)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: wrote %+v, want an error", tt.name, writes)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if len(writes) != 1 || writes[0].Addr != 0x10 || !slices.Equal(writes[0].Data, tt.want) {
+			t.Errorf("%s: wrote %+v, want % X at 0x10", tt.name, writes, tt.want)
+		}
+	}
+}
+
+func TestPrepareWriteBitfieldWordSwapped(t *testing.T) {
+	plan := &ReadPlan{Platform: "N64"}
+	spec := ReadSpec{Name: "flag", Type: Bool, Bank: RDRAM, Address: 0x101, Bit: new(int), Endian: WordSwapped}
+
+	// the byte at 0x101 is stored at 0x102, its neighbours are kept
+	mem := make([]byte, 0x200)
+	copy(mem[0x100:], []byte{0x11, 0x22, 0x30, 0x44})
+	readBack := func(r Write) error {
+		copy(r.Data, mem[r.Addr:])
+		return nil
+	}
+
+	writes, err := PrepareWrite(plan, RetroArch, spec, Value{Bool: true}, readBack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range writes {
+		copy(mem[w.Addr:], w.Data)
+	}
+
+	if got := mem[0x100:0x104]; !slices.Equal(got, []byte{0x11, 0x22, 0x31, 0x44}) {
+		t.Errorf("memory % X after the write, want 11 22 31 44", got)
+	}
+}
+
+func TestPrepareWriteBitfieldWithoutReadBack(t *testing.T) {
+	plan := &ReadPlan{Platform: "SNES"}
+	spec := ReadSpec{Name: "flag", Type: Bool, Bank: WRAM, Bit: new(int)}
+
+	if _, err := PrepareWrite(plan, NWA, spec, Value{Bool: true}, nil); err == nil {
+		t.Errorf("bit field written without reading its bytes")
+	}
+}
@@ -1,6 +1,8 @@
 import { ChangeEvent, useEffect, useState } from "react";
 import {
+  AllowMemoryWrites,
   GetFactProviders,
+  MemoryWritesAllowed,
   OpenFactProviderFolder,
   SetEmulatorClient,
   SetReadPlan,
@@ -14,7 +16,7 @@ enum EmulatorClient {
   RetroArch = "retroarch",
   NWA = "nwa",
   QUSB2SNES = "qusb2snes",
-  // LinuxMem = "linuxmem",
+  LinuxMem = "linuxmem",
 }
 
 enum ConnectionStatus {
@@ -29,6 +31,23 @@ type ConnectionState = {
   message: string;
 };
 
+type Diagnostic = {
+  file?: string;
+  line: number;
+  column: number;
+  message: string;
+  warning?: boolean;
+};
+
+type GameInfo = {
+  Name: string;
+  File: string;
+  CRC: number;
+};
+
+const formatDiagnostic = (d: Diagnostic) =>
+  `${d.file ? `${d.file}:` : ""}${d.line}:${d.column}: ${d.message}`;
+
 function useWailsEvent<T>(event: string, handler: (payload: T) => void) {
   useEffect(() => {
     return EventsOn(event, handler);
@@ -59,6 +78,12 @@ function App() {
     EmulatorClient.RetroArch,
   );
 
+  const [selectedProvider, setSelectedProvider] = useState<string>("");
+  const [writesAllowed, setWritesAllowed] = useState<boolean>(false);
+  const [diagnostics, setDiagnostics] = useState<Diagnostic[]>([]);
+  const [unmatchedGame, setUnmatchedGame] = useState<GameInfo | null>(null);
+  const [romVersion, setRomVersion] = useState<string>("");
+
   useWailsEvent<ConnectionState>("emulator:connection", setEmulatorConnection);
 
   useWailsEvent<ConnectionState>(
@@ -90,9 +115,38 @@ function App() {
     };
   }, []);
 
+  const selectProvider = async (path: string) => {
+    setSelectedProvider(path);
+    setRomVersion("");
+    setWritesAllowed(path !== "" && (await MemoryWritesAllowed(path)));
+  };
+
+  useWailsEvent<Provider>("provider:selected", (provider) => {
+    setUnmatchedGame(null);
+    selectProvider(provider.FilePath);
+  });
+
+  useWailsEvent<GameInfo>("provider:unmatched", setUnmatchedGame);
+
+  useWailsEvent<string>("readplan:version", setRomVersion);
+
+  useWailsEvent<Diagnostic[] | null>("readplan:diagnostics", (diags) =>
+    setDiagnostics(diags ?? []),
+  );
+
   const changeProvider = async (e: ChangeEvent<HTMLSelectElement>) => {
     try {
       await SetReadPlan(e.target.value);
+      await selectProvider(e.target.value);
+    } catch (err) {
+      console.error(err);
+    }
+  };
+
+  const changeWritesAllowed = async (e: ChangeEvent<HTMLInputElement>) => {
+    try {
+      await AllowMemoryWrites(selectedProvider, e.target.checked);
+      setWritesAllowed(e.target.checked);
     } catch (err) {
       console.error(err);
     }
@@ -130,11 +184,11 @@ function App() {
           <option value={EmulatorClient.RetroArch}>RetroArch</option>
           <option value={EmulatorClient.NWA}>NWA</option>
           <option value={EmulatorClient.QUSB2SNES}>QUSB2SNES</option>
-          {/*<option value={EmulatorClient.LinuxMem}>Linux/Proton/Wine</option>*/}
+          <option value={EmulatorClient.LinuxMem}>Linux/Proton/Wine</option>
         </select>
       </div>
       <div>
-        <select onChange={changeProvider}>
+        <select value={selectedProvider} onChange={changeProvider}>
           <option value="">Select a Fact Provider</option>
           <option value="">---</option>
           {providers.map((provider: Provider) => (
@@ -144,6 +198,46 @@ function App() {
           ))}
         </select>
       </div>
+      {unmatchedGame && (
+        <div style={{ marginTop: "10px", color: "orange" }}>
+          No fact provider matches {unmatchedGame.Name || unmatchedGame.File}
+        </div>
+      )}
+      {romVersion !== "" && (
+        <div style={{ marginTop: "10px" }}>ROM version: {romVersion}</div>
+      )}
+      {diagnostics.length > 0 && (
+        <div
+          style={{
+            marginTop: "10px",
+            textAlign: "left",
+            fontFamily: "monospace",
+            fontSize: "0.8em",
+          }}
+        >
+          {diagnostics.map((d, i) => (
+            <div key={i} style={{ color: d.warning ? "orange" : "red" }}>
+              {formatDiagnostic(d)}
+            </div>
+          ))}
+        </div>
+      )}
+      {selectedProvider !== "" && (
+        <div style={{ marginTop: "10px" }}>
+          <label>
+            <input
+              type="checkbox"
+              checked={writesAllowed}
+              onChange={changeWritesAllowed}
+            />
+            Allow memory writes
+          </label>
+          <div style={{ fontSize: "0.8em", opacity: 0.7 }}>
+            Writes are refused while OpenSplit is connected, as it cannot tell
+            whether a run is being timed.
+          </div>
+        </div>
+      )}
 
       <div style={{ marginTop: "20px" }}>
         <button
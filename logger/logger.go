@@ -50,6 +50,12 @@ func (l *Logger) enabled(level Level) bool {
 	return level >= l.level
 }
 
+// DebugEnabled reports whether Debug logs. Hot paths check it first, the
+// arguments of a message are allocated even when it is dropped.
+func (l *Logger) DebugEnabled() bool {
+	return l.enabled(DebugLevel)
+}
+
 func (l *Logger) Debug(format string, v ...any) {
 	if !l.enabled(DebugLevel) {
 		return
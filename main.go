@@ -1,7 +1,8 @@
 package main
 
 import (
-	// linuxmem "FactFinder/emulator/linux"
+	"FactFinder/emulator"
+	linuxmem "FactFinder/emulator/linux"
 	"FactFinder/emulator/nwa"
 	"FactFinder/emulator/qusb2snes"
 	"FactFinder/emulator/retroarch"
@@ -9,6 +10,8 @@ import (
 	"FactFinder/processing"
 	"FactFinder/repo"
 	"embed"
+	"os"
+	"path/filepath"
 
 	"github.com/wailsapp/wails/v2"
 	"github.com/wailsapp/wails/v2/pkg/options"
@@ -31,18 +34,32 @@ func main() {
 		panic(err)
 	}
 
+	permissions, err := repo.LoadPermissions(paths.AppDir)
+	if err != nil {
+		panic(err)
+	}
+
+	schema, err := emulator.ReadPlanSchema()
+	if err == nil {
+		err = os.WriteFile(filepath.Join(paths.ProviderDir, "readplan.schema.json"), schema, 0644)
+	}
+	if err != nil {
+		println("Warning: failed to write read plan schema:", err.Error())
+	}
+
 	raClient := retroarch.NewClient("localhost", "55355")
 	nwaClient := nwa.NewClient("localhost", "48879")
 	qUSB2SNESClient := qusb2snes.NewClient("localhost", "23074")
-	// linuxProcessClient := linuxmem.NewClient()
+	linuxProcessClient := linuxmem.NewClient()
 	engine, osConnCh := processing.NewEngine()
 
 	app := NewApp(
 		paths.ProviderDir,
+		permissions,
 		raClient,
 		nwaClient,
 		qUSB2SNESClient,
-		// linuxProcessClient,
+		linuxProcessClient,
 		engine,
 		osConnCh,
 	)
@@ -3,10 +3,13 @@ package processing
 import (
 	"FactFinder/emulator"
 	"FactFinder/logger"
+	"bytes"
 	"fmt"
 	"net"
-	"sort"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	lua "github.com/yuin/gopher-lua"
@@ -43,14 +46,29 @@ const (
 )
 
 type Engine struct {
-	L                    *lua.LState
-	m                    sync.Mutex
-	values               map[string]emulator.Value
-	conn                 net.PacketConn
-	osAddr               *net.UDPAddr
-	openSplitConnected   bool
+	// guards the provider's Lua state, from L to stateRows. It is not m,
+	// Lua calls split() and write() which take m.
+	lm                 sync.Mutex
+	L                  *lua.LState
+	m                  sync.Mutex
+	values             map[string]emulator.Value
+	signals            map[string]emulator.Signals
+	arrays             map[string]*lua.LTable
+	conn               net.PacketConn
+	osAddr             *net.UDPAddr
+	openSplitConnected atomic.Bool
+	// a run is being timed, OpenSplit does not report it so it is the run
+	// the factbuilder started with split() and has not reset()
+	runTimed             atomic.Bool
 	opensplitConnectedCh chan bool
 	tickFunc             *lua.LFunction
+	stateRows            [][]string
+
+	// write() goes through writer to the watches of writePlan, only while
+	// the provider is allowed to and no run is being timed
+	writer        emulator.MemoryWriter
+	writePlan     *emulator.ReadPlan
+	writesAllowed bool
 }
 
 func NewEngine() (*Engine, chan bool) {
@@ -78,8 +96,7 @@ func NewEngine() (*Engine, chan bool) {
 
 		for range ticker.C {
 			log.Debug("OpenSplit heartbeat check")
-			e.openSplitConnected = e.Hello()
-			e.updateConnectionStatus(e.openSplitConnected)
+			e.updateConnectionStatus(e.Hello())
 		}
 	}()
 
@@ -89,79 +106,67 @@ func NewEngine() (*Engine, chan bool) {
 
 func (e *Engine) Close() {
 	log.Info("engine shutting down")
+	e.lm.Lock()
 	if e.L != nil {
 		e.L.Close()
 	}
+	e.lm.Unlock()
 	e.updateConnectionStatus(false)
 	_ = e.conn.Close()
 	e.conn = nil
 }
 
 func (e *Engine) OpenSplitConnected() bool {
-	return e.openSplitConnected
+	return e.openSplitConnected.Load()
 }
 
+// LoadFile replaces the provider's Lua state with the factbuilder at path
+// and the watches of plan. Ticks wait until it is loaded.
 func (e *Engine) LoadFile(path string, plan *emulator.ReadPlan) error {
-	L := lua.NewState()
-	e.L = L
+	e.lm.Lock()
+	defer e.lm.Unlock()
 
-	for _, spec := range plan.Watches {
-		if spec.Type == emulator.Bool {
-			e.L.SetGlobal(spec.Name, lua.LBool(false))
-			e.L.SetGlobal(spec.Name+"_last", lua.LBool(false))
-		} else {
-			e.L.SetGlobal(spec.Name, lua.LNumber(0))
-			e.L.SetGlobal(spec.Name+"_last", lua.LNumber(0))
-		}
+	if e.L != nil {
+		e.L.Close()
 	}
 
-	e.L.SetGlobal("split", e.L.NewFunction(func(L *lua.LState) int {
-		packet := buildRCPacket(SPLIT, false)
-
-		e.m.Lock()
-		defer e.m.Unlock()
+	L := lua.NewState()
+	e.L = L
+	e.values = make(map[string]emulator.Value)
+	e.signals = make(map[string]emulator.Signals)
+	e.arrays = make(map[string]*lua.LTable)
+	e.tickFunc = nil
 
-		_, err := e.conn.WriteTo(packet, e.osAddr)
-		if err != nil {
-			log.Error("failed to send %v packet: %v", SPLIT, err)
-			e.updateConnectionStatus(false)
-			return 1
+	for _, spec := range plan.Watches {
+		if spec.IsArray() {
+			e.loadArray(spec)
+			continue
 		}
-		e.updateConnectionStatus(true)
-		return 0
-	}))
 
-	e.L.SetGlobal("reset", e.L.NewFunction(func(L *lua.LState) int {
-		packet := buildRCPacket(RESET, false)
+		zero := luaValue(e.L, emulator.Value{Type: spec.Type})
+		e.L.SetGlobal(spec.Name, zero)
+		e.L.SetGlobal(spec.Name+"_last", zero)
 
-		e.m.Lock()
-		defer e.m.Unlock()
-
-		_, err := e.conn.WriteTo(packet, e.osAddr)
-		if err != nil {
-			e.updateConnectionStatus(false)
-			log.Error("failed to send reset packet: %v", err)
-			return 1
+		if len(spec.Signals) > 0 {
+			e.signals[spec.Name] = spec.Signals
+			e.clearSignals(spec.Name, spec.Signals)
 		}
-		e.updateConnectionStatus(true)
-		return 0
-	}))
-
-	e.L.SetGlobal("pause", e.L.NewFunction(func(L *lua.LState) int {
-		packet := buildRCPacket(PAUSE, false)
+	}
 
-		e.m.Lock()
-		defer e.m.Unlock()
+	for name, command := range map[string]Command{
+		"split": SPLIT,
+		"reset": RESET,
+		"pause": PAUSE,
+	} {
+		e.L.SetGlobal(name, e.L.NewFunction(func(L *lua.LState) int {
+			if err := e.send(command); err != nil {
+				return 1
+			}
+			return 0
+		}))
+	}
 
-		_, err := e.conn.WriteTo(packet, e.osAddr)
-		if err != nil {
-			e.updateConnectionStatus(false)
-			log.Error("failed to send pause packet: %v", err)
-			return 1
-		}
-		e.updateConnectionStatus(true)
-		return 0
-	}))
+	e.L.SetGlobal("write", e.L.NewFunction(e.luaWrite))
 
 	if err := e.L.DoFile(path); err != nil {
 		return fmt.Errorf("lua load error: %w", err)
@@ -177,8 +182,103 @@ func (e *Engine) LoadFile(path string, plan *emulator.ReadPlan) error {
 	return nil
 }
 
+// SetWriter sets the backend write() goes through and the plan whose
+// watches it writes, w is nil when the backend cannot write.
+func (e *Engine) SetWriter(w emulator.MemoryWriter, plan *emulator.ReadPlan) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	e.writer = w
+	e.writePlan = plan
+}
+
+// AllowWrites sets whether the loaded provider may write memory.
+func (e *Engine) AllowWrites(allowed bool) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	e.writesAllowed = allowed
+}
+
+// luaWrite is write(name, value), it writes value to the memory of the
+// watch name and returns true, or nil and the reason it did not.
+func (e *Engine) luaWrite(L *lua.LState) int {
+	name := L.CheckString(1)
+	value := L.CheckAny(2)
+
+	if err := e.write(name, value); err != nil {
+		log.Warn("write %s failed: %v", name, err)
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	L.Push(lua.LTrue)
+	return 1
+}
+
+func (e *Engine) write(name string, lv lua.LValue) error {
+	e.m.Lock()
+	writer, plan, allowed := e.writer, e.writePlan, e.writesAllowed
+	e.m.Unlock()
+
+	switch {
+	case !allowed:
+		return fmt.Errorf("%w for this provider", emulator.ErrWritesDisabled)
+	case e.runTimed.Load():
+		return fmt.Errorf("%w while a run is timed", emulator.ErrWritesDisabled)
+	case writer == nil || plan == nil:
+		return fmt.Errorf("the emulator client cannot write memory")
+	}
+
+	spec, ok := writeSpec(plan, name)
+	if !ok {
+		return fmt.Errorf("no watch named %s", name)
+	}
+
+	// a whole array has no value, the writer rejects it
+	var v emulator.Value
+	if !spec.IsArray() {
+		var err error
+		if v, err = goValue(spec, lv); err != nil {
+			return err
+		}
+	}
+
+	return writer.WriteValue(plan, spec, v)
+}
+
+// writeSpec returns the watch of plan named name, an element of an array
+// watch by the name its values have, as items[2] or items[2].count.
+func writeSpec(plan *emulator.ReadPlan, name string) (emulator.ReadSpec, bool) {
+	for _, spec := range plan.Watches {
+		if spec.Name == name {
+			return spec, true
+		}
+
+		if !spec.IsArray() || !strings.HasPrefix(name, spec.Name+"[") {
+			continue
+		}
+		for _, elem := range spec.Expand() {
+			if elem.Name == name {
+				return elem, true
+			}
+		}
+	}
+
+	return emulator.ReadSpec{}, false
+}
+
+// GetState returns the Lua state table as sorted key/value rows. The rows
+// are reused by the next call.
 func (e *Engine) GetState() [][]string {
-	out := make([][]string, 0)
+	e.lm.Lock()
+	defer e.lm.Unlock()
+
+	out := e.stateRows[:0]
+	if e.L == nil {
+		return out
+	}
 
 	tbl, ok := e.L.GetGlobal("state").(*lua.LTable)
 	if !ok {
@@ -186,52 +286,70 @@ func (e *Engine) GetState() [][]string {
 	}
 
 	tbl.ForEach(func(k, v lua.LValue) {
-		out = append(out, []string{k.String(), v.String()})
+		n := len(out)
+		if n < cap(out) {
+			out = out[:n+1]
+		} else {
+			out = append(out, make([]string, 2))
+		}
+		out[n][0] = k.String()
+		out[n][1] = v.String()
 	})
 
-	sort.Slice(out, func(i, j int) bool {
-		return out[i][0] < out[j][0] // sort by key
+	slices.SortFunc(out, func(a, b []string) int {
+		return strings.Compare(a[0], b[0]) // sort by key
 	})
 
+	e.stateRows = out
 	return out
 }
 
 func (e *Engine) ProcessValues(values []emulator.Value) error {
 	log.Debug("processing %d emulator values", len(values))
+
+	e.lm.Lock()
+	defer e.lm.Unlock()
+
+	if e.L == nil {
+		return nil
+	}
+
+	// Signals only fire on the tick they happen
+	for name, signals := range e.signals {
+		e.clearSignals(name, signals)
+	}
+
 	for _, newValue := range values {
 		name := newValue.Name
-		t := newValue.Type
 
 		// First time we've seen this value, do no processing on it yet.
-		if _, ok := e.values[name]; !ok {
+		lastValue, ok := e.values[name]
+		if !ok {
 			e.values[name] = newValue
 			continue
 		}
 
-		switch t {
-		case emulator.FlagCount:
-			e.L.SetGlobal(name+"_last", lua.LNumber(e.values[name].FlagCount))
-			e.L.SetGlobal(name, lua.LNumber(newValue.FlagCount))
-		case emulator.Bool:
-			e.L.SetGlobal(name+"_last", lua.LBool(e.values[name].Bool))
-			e.L.SetGlobal(name, lua.LBool(newValue.Bool))
-		case emulator.U8, emulator.U16, emulator.U32, emulator.U64:
-			e.L.SetGlobal(name+"_last", lua.LNumber(e.values[name].Unsigned))
-			e.L.SetGlobal(name, lua.LNumber(newValue.Unsigned))
-		case emulator.F32:
-			e.L.SetGlobal(name+"_last", lua.LNumber(e.values[name].Float32))
-			e.L.SetGlobal(name, lua.LNumber(newValue.Float32))
-		case emulator.String:
-			e.L.SetGlobal(name+"_last", lua.LString(e.values[name].String))
-			e.L.SetGlobal(name, lua.LString(newValue.String))
-		default:
-			e.L.SetGlobal(name+"_last", lua.LNumber(e.values[name].Signed))
-			e.L.SetGlobal(name, lua.LNumber(newValue.Signed))
+		if newValue.Array != "" {
+			e.setElement(newValue.Array+"_last", lastValue)
+			e.setElement(newValue.Array, newValue)
+			e.values[name] = newValue
+			continue
+		}
+
+		e.L.SetGlobal(name+"_last", luaValue(e.L, lastValue))
+		e.L.SetGlobal(name, luaValue(e.L, newValue))
+
+		if signals, ok := e.signals[name]; ok {
+			e.setSignals(name, signals, lastValue, newValue)
 		}
 
 		e.values[name] = newValue
 	}
 
+	if e.tickFunc == nil {
+		return nil
+	}
+
 	err := e.L.CallByParam(lua.P{
 		Fn:      e.tickFunc,
 		NRet:    0,
@@ -244,6 +362,220 @@ func (e *Engine) ProcessValues(values []emulator.Value) error {
 	return nil
 }
 
+// loadArray exposes an array watch as a table of values, or a table of
+// tables keyed by field name, along with a matching name_last table.
+func (e *Engine) loadArray(spec emulator.ReadSpec) {
+	for _, name := range []string{spec.Name, spec.Name + "_last"} {
+		tbl := e.L.CreateTable(spec.Count, 0)
+
+		for range spec.Count {
+			if len(spec.Fields) == 0 {
+				tbl.Append(luaValue(e.L, emulator.Value{Type: spec.Type}))
+				continue
+			}
+
+			elem := e.L.CreateTable(0, len(spec.Fields))
+			for _, field := range spec.Fields {
+				elem.RawSetString(field.Name, luaValue(e.L, emulator.Value{Type: field.Type}))
+			}
+			tbl.Append(elem)
+		}
+
+		e.arrays[name] = tbl
+		e.L.SetGlobal(name, tbl)
+	}
+}
+
+func (e *Engine) setElement(array string, v emulator.Value) {
+	tbl, ok := e.arrays[array]
+	if !ok {
+		return
+	}
+
+	if v.Field == "" {
+		tbl.RawSetInt(v.Index+1, luaValue(e.L, v))
+		return
+	}
+
+	elem, ok := tbl.RawGetInt(v.Index + 1).(*lua.LTable)
+	if !ok {
+		return
+	}
+	elem.RawSetString(v.Field, luaValue(e.L, v))
+}
+
+func (e *Engine) clearSignals(name string, signals emulator.Signals) {
+	for _, signal := range signals {
+		if signal == emulator.Delta {
+			e.L.SetGlobal(name+"_delta", lua.LNumber(0))
+			continue
+		}
+		e.L.SetGlobal(name+"_"+string(signal), lua.LFalse)
+	}
+}
+
+// setSignals exposes the edges between two ticks of a watch as
+// name_rising, name_falling, name_delta and name_edge.
+func (e *Engine) setSignals(
+	name string,
+	signals emulator.Signals,
+	last emulator.Value,
+	cur emulator.Value,
+) {
+	lastNum, curNum := numeric(last), numeric(cur)
+
+	for _, signal := range signals {
+		switch signal {
+		case emulator.Rising:
+			e.L.SetGlobal(name+"_rising", lua.LBool(curNum > lastNum))
+		case emulator.Falling:
+			e.L.SetGlobal(name+"_falling", lua.LBool(curNum < lastNum))
+		case emulator.Delta:
+			e.L.SetGlobal(name+"_delta", lua.LNumber(curNum-lastNum))
+		case emulator.Edge:
+			changed := curNum != lastNum ||
+				cur.String != last.String ||
+				!bytes.Equal(cur.Bytes, last.Bytes)
+			e.L.SetGlobal(name+"_edge", lua.LBool(changed))
+		}
+	}
+}
+
+// numeric returns a value as a number for signal comparisons, Bool is 0 or 1
+// and strings are 0.
+func numeric(v emulator.Value) float64 {
+	switch v.Type {
+	case emulator.FlagCount:
+		return float64(v.FlagCount)
+	case emulator.Bool:
+		if v.Bool {
+			return 1
+		}
+		return 0
+	case emulator.U8, emulator.U16, emulator.U24, emulator.U32, emulator.U64, emulator.BCD:
+		return float64(v.Unsigned)
+	case emulator.F32:
+		return float64(v.Float32)
+	case emulator.F64, emulator.Fixed, emulator.UFixed:
+		return v.Float64
+	case emulator.String, emulator.UTF16LE, emulator.Bytes:
+		return 0
+	default:
+		return float64(v.Signed)
+	}
+}
+
+// luaValue converts a decoded value to the matching Lua type.
+// Bytes become a 1-indexed table of numbers.
+func luaValue(L *lua.LState, v emulator.Value) lua.LValue {
+	switch v.Type {
+	case emulator.FlagCount:
+		return lua.LNumber(v.FlagCount)
+	case emulator.Bool:
+		return lua.LBool(v.Bool)
+	case emulator.U8, emulator.U16, emulator.U24, emulator.U32, emulator.U64, emulator.BCD:
+		return lua.LNumber(v.Unsigned)
+	case emulator.F32:
+		return lua.LNumber(v.Float32)
+	case emulator.F64, emulator.Fixed, emulator.UFixed:
+		return lua.LNumber(v.Float64)
+	case emulator.Bytes:
+		tbl := L.CreateTable(len(v.Bytes), 0)
+		for _, b := range v.Bytes {
+			tbl.Append(lua.LNumber(b))
+		}
+		return tbl
+	case emulator.String, emulator.UTF16LE:
+		return lua.LString(v.String)
+	default:
+		return lua.LNumber(v.Signed)
+	}
+}
+
+// goValue converts a Lua value to a value of the watch's type, the inverse
+// of luaValue.
+func goValue(spec emulator.ReadSpec, lv lua.LValue) (emulator.Value, error) {
+	v := emulator.Value{Name: spec.Name, Type: spec.Type}
+
+	switch spec.Type {
+	case emulator.Bool:
+		b, ok := lv.(lua.LBool)
+		if !ok {
+			return v, fmt.Errorf("%s needs a boolean, got %s", spec.Name, lv.Type())
+		}
+		v.Bool = bool(b)
+		return v, nil
+
+	case emulator.String, emulator.UTF16LE:
+		s, ok := lv.(lua.LString)
+		if !ok {
+			return v, fmt.Errorf("%s needs a string, got %s", spec.Name, lv.Type())
+		}
+		v.String = string(s)
+		return v, nil
+
+	case emulator.Bytes:
+		tbl, ok := lv.(*lua.LTable)
+		if !ok {
+			return v, fmt.Errorf("%s needs a table of bytes, got %s", spec.Name, lv.Type())
+		}
+		for i := 1; i <= tbl.Len(); i++ {
+			n, ok := tbl.RawGetInt(i).(lua.LNumber)
+			if !ok || n < 0 || n > 0xFF {
+				return v, fmt.Errorf("%s[%d] is not a byte", spec.Name, i)
+			}
+			v.Bytes = append(v.Bytes, byte(n))
+		}
+		return v, nil
+	}
+
+	n, ok := lv.(lua.LNumber)
+	if !ok {
+		return v, fmt.Errorf("%s needs a number, got %s", spec.Name, lv.Type())
+	}
+
+	switch spec.Type {
+	case emulator.U8, emulator.U16, emulator.U24, emulator.U32, emulator.U64, emulator.BCD:
+		if n < 0 {
+			return v, fmt.Errorf("%s is unsigned, got %v", spec.Name, n)
+		}
+		v.Unsigned = uint64(n)
+	case emulator.F32:
+		v.Float32 = float32(n)
+	case emulator.F64, emulator.Fixed, emulator.UFixed:
+		v.Float64 = float64(n)
+	default:
+		v.Signed = int64(n)
+	}
+
+	return v, nil
+}
+
+// send sends command to OpenSplit and keeps track of the run it times.
+func (e *Engine) send(command Command) error {
+	packet := buildRCPacket(command, false)
+
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	_, err := e.conn.WriteTo(packet, e.osAddr)
+	if err != nil {
+		log.Error("failed to send %v packet: %v", command, err)
+		e.updateConnectionStatus(false)
+		return err
+	}
+	e.updateConnectionStatus(true)
+
+	switch command {
+	case SPLIT:
+		e.runTimed.Store(true)
+	case RESET:
+		e.runTimed.Store(false)
+	}
+
+	return nil
+}
+
 func (e *Engine) Hello() bool {
 	log.Debug("sending OpenSplit HELLO")
 	packet := buildRCPacket(HELLO, true)
@@ -286,12 +618,16 @@ func buildRCPacket(command Command, requestAck bool) []byte {
 }
 
 func (e *Engine) updateConnectionStatus(status bool) {
-	e.openSplitConnected = status
+	e.openSplitConnected.Store(status)
+	// a timer that went away times nothing
+	if !status {
+		e.runTimed.Store(false)
+	}
 
 	log.Debug("OpenSplit connection status changed: %v", status)
 
 	select {
-	case e.opensplitConnectedCh <- e.openSplitConnected:
+	case e.opensplitConnectedCh <- status:
 	default:
 	}
 }
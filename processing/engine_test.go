@@ -0,0 +1,390 @@
+package processing
+
+import (
+	"FactFinder/emulator"
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// memoryWriter keeps the writes a backend would send.
+type memoryWriter struct {
+	writes []emulator.Write
+}
+
+func (w *memoryWriter) WriteValue(plan *emulator.ReadPlan, spec emulator.ReadSpec, v emulator.Value) error {
+	writes, err := emulator.PrepareWrite(plan, emulator.NWA, spec, v, nil)
+	if err != nil {
+		return err
+	}
+	w.writes = append(w.writes, writes...)
+	return nil
+}
+
+func TestWrite(t *testing.T) {
+	plan := &emulator.ReadPlan{
+		Name:     "smw",
+		Platform: "SNES",
+		Watches: []emulator.ReadSpec{
+			{Name: "lives", Type: emulator.U8, Bank: emulator.WRAM, Address: 0x0DBE},
+			{
+				Name:    "items",
+				Bank:    emulator.WRAM,
+				Address: 0x0100,
+				Count:   3,
+				Fields: []emulator.ReadSpec{
+					{Name: "id", Type: emulator.U8},
+					{Name: "count", Type: emulator.U8, Address: 1},
+				},
+			},
+		},
+	}
+
+	w := &memoryWriter{}
+	e := &Engine{}
+	e.SetWriter(w, plan)
+
+	if err := e.write("lives", lua.LNumber(5)); !errors.Is(err, emulator.ErrWritesDisabled) {
+		t.Fatalf("write without permission: %v, want ErrWritesDisabled", err)
+	}
+	e.AllowWrites(true)
+
+	tests := []struct {
+		name string
+		addr int
+		data byte
+		err  string
+	}{
+		{name: "lives", addr: 0x0DBE, data: 5},
+		{name: "items[2].count", addr: 0x0103, data: 5},
+		{name: "items", err: "items is an array and cannot be written"},
+		{name: "items[4].count", err: "no watch named items[4].count"},
+	}
+
+	for _, tt := range tests {
+		w.writes = nil
+		err := e.write(tt.name, lua.LNumber(5))
+
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("write %s: %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("write %s: %v", tt.name, err)
+			continue
+		}
+		if len(w.writes) != 1 || w.writes[0].Addr != tt.addr || !bytes.Equal(w.writes[0].Data, []byte{tt.data}) {
+			t.Errorf("write %s: sent %+v, want %02X at 0x%X", tt.name, w.writes, tt.data, tt.addr)
+		}
+	}
+}
+
+func TestWriteDisabledWhileRunTimed(t *testing.T) {
+	plan := &emulator.ReadPlan{
+		Name:     "smw",
+		Platform: "SNES",
+		Watches:  []emulator.ReadSpec{{Name: "lives", Type: emulator.U8, Bank: emulator.WRAM, Address: 0x0DBE}},
+	}
+
+	// OpenSplit, which takes the commands and answers nothing
+	timer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer timer.Close()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	e := &Engine{conn: conn, osAddr: timer.LocalAddr().(*net.UDPAddr)}
+	e.SetWriter(&memoryWriter{}, plan)
+	e.AllowWrites(true)
+
+	steps := []struct {
+		name    string
+		command Command
+		timed   bool
+	}{
+		{name: "connected and idle", command: HELLO},
+		{name: "after split", command: SPLIT, timed: true},
+		{name: "paused", command: PAUSE, timed: true},
+		{name: "after reset", command: RESET},
+	}
+
+	for _, step := range steps {
+		if err := e.send(step.command); err != nil {
+			t.Fatalf("%s: send: %v", step.name, err)
+		}
+		if !e.OpenSplitConnected() {
+			t.Fatalf("%s: OpenSplit not connected", step.name)
+		}
+
+		err := e.write("lives", lua.LNumber(5))
+		if disabled := errors.Is(err, emulator.ErrWritesDisabled); disabled != step.timed {
+			t.Errorf("%s: write %v, want disabled=%v", step.name, err, step.timed)
+		}
+	}
+
+	// a timer that disconnects mid-run times nothing
+	if err := e.send(SPLIT); err != nil {
+		t.Fatal(err)
+	}
+	e.updateConnectionStatus(false)
+	if err := e.write("lives", lua.LNumber(5)); err != nil {
+		t.Errorf("write after the timer went away: %v", err)
+	}
+}
+
+// loadScript loads script as the factbuilder of plan.
+func loadScript(t *testing.T, plan *emulator.ReadPlan, script string) *Engine {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "factbuilder.lua")
+	if err := os.WriteFile(path, []byte(script), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	e := &Engine{}
+	if err := e.LoadFile(path, plan); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	// the state loaded last, a test may load another
+	t.Cleanup(func() { e.L.Close() })
+
+	return e
+}
+
+// readValues decodes compiled from a flat image of its bank.
+func readValues(compiled *emulator.CompiledReadPlan, mem []byte) []emulator.Value {
+	vals := compiled.Values()
+	for i := range compiled.Regions {
+		region := &compiled.Regions[i]
+		copy(region.Buffer, mem[region.Start:])
+		vals = emulator.DecodeRegion(region, vals)
+	}
+	return vals
+}
+
+func TestSignals(t *testing.T) {
+	plan := &emulator.ReadPlan{
+		Name:     "smw",
+		Platform: "SNES",
+		Watches: []emulator.ReadSpec{{
+			Name:    "lives",
+			Type:    emulator.U8,
+			Bank:    emulator.WRAM,
+			Address: 0x10,
+			Signals: emulator.Signals{emulator.Rising, emulator.Falling, emulator.Delta, emulator.Edge},
+		}},
+	}
+
+	e := loadScript(t, plan, `
+function onTick()
+	seen = {
+		rising = lives_rising,
+		falling = lives_falling,
+		delta = lives_delta,
+		edge = lives_edge,
+	}
+end
+`)
+
+	tests := []struct {
+		lives uint64
+		// the watch was skipped, as one that cannot be decoded
+		skipped bool
+		rising  bool
+		falling bool
+		delta   float64
+		edge    bool
+	}{
+		// the first value has nothing to compare with
+		{lives: 0},
+		{lives: 1, rising: true, delta: 1, edge: true},
+		// held values reset every signal
+		{lives: 1},
+		{lives: 0, falling: true, delta: -1, edge: true},
+		{lives: 1, rising: true, delta: 1, edge: true},
+		// and so does a tick without the value
+		{skipped: true},
+	}
+
+	for i, tt := range tests {
+		vals := []emulator.Value{{Name: "lives", Type: emulator.U8, Unsigned: tt.lives}}
+		if tt.skipped {
+			vals = nil
+		}
+		if err := e.ProcessValues(vals); err != nil {
+			t.Fatalf("tick %d: %v", i, err)
+		}
+
+		seen, ok := e.L.GetGlobal("seen").(*lua.LTable)
+		if !ok {
+			t.Fatalf("tick %d: onTick did not run", i)
+		}
+
+		rising := lua.LVAsBool(seen.RawGetString("rising"))
+		falling := lua.LVAsBool(seen.RawGetString("falling"))
+		delta := float64(lua.LVAsNumber(seen.RawGetString("delta")))
+		edge := lua.LVAsBool(seen.RawGetString("edge"))
+
+		if rising != tt.rising || falling != tt.falling || delta != tt.delta || edge != tt.edge {
+			t.Errorf("tick %d (lives=%d): rising=%v falling=%v delta=%v edge=%v, want %v %v %v %v",
+				i, tt.lives,
+				rising, falling, delta, edge,
+				tt.rising, tt.falling, tt.delta, tt.edge,
+			)
+		}
+	}
+}
+
+func TestArrayTables(t *testing.T) {
+	plan := &emulator.ReadPlan{
+		Name:     "smw",
+		Platform: "SNES",
+		Watches: []emulator.ReadSpec{{
+			Name:    "enemies",
+			Bank:    emulator.WRAM,
+			Address: 0x100,
+			Count:   3,
+			Stride:  4,
+			Fields: []emulator.ReadSpec{
+				{Name: "id", Type: emulator.U8},
+				{Name: "hp", Type: emulator.U16, Address: 2},
+			},
+		}},
+	}
+
+	e := loadScript(t, plan, `
+function onTick()
+	count = #enemies
+	first_id = enemies[1].id
+	third_hp = enemies[3].hp
+	third_hp_last = enemies_last[3].hp
+	zeroth = enemies[0]
+end
+`)
+
+	compiled := emulator.CompileReadPlan(plan, emulator.DefaultCapabilities, emulator.NWA)
+	mem := make([]byte, 0x200)
+
+	// enemy i at 0x100+4i: id, padding, hp
+	for tick, hp := range []byte{0x20, 0x30} {
+		for i := range 3 {
+			mem[0x100+4*i] = byte(0x10 + i)
+			mem[0x102+4*i] = hp + byte(i)
+		}
+
+		if err := e.ProcessValues(readValues(compiled, mem)); err != nil {
+			t.Fatalf("tick %d: %v", tick, err)
+		}
+	}
+
+	tests := []struct {
+		global string
+		want   lua.LValue
+	}{
+		{"count", lua.LNumber(3)},
+		{"first_id", lua.LNumber(0x10)},
+		{"third_hp", lua.LNumber(0x32)},
+		{"third_hp_last", lua.LNumber(0x22)},
+		{"zeroth", lua.LNil},
+	}
+
+	for _, tt := range tests {
+		if got := e.L.GetGlobal(tt.global); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.global, got, tt.want)
+		}
+	}
+}
+
+// TestLoadFileWhileTicking switches providers while ticks run, as the app
+// does from the UI. Run it with -race.
+func TestLoadFileWhileTicking(t *testing.T) {
+	lives := &emulator.ReadPlan{
+		Name:     "lives",
+		Platform: "SNES",
+		Watches: []emulator.ReadSpec{{
+			Name:    "lives",
+			Type:    emulator.U8,
+			Bank:    emulator.WRAM,
+			Address: 0x10,
+			Signals: emulator.Signals{emulator.Delta},
+		}},
+	}
+	enemies := &emulator.ReadPlan{
+		Name:     "enemies",
+		Platform: "SNES",
+		Watches: []emulator.ReadSpec{{
+			Name:    "enemies",
+			Type:    emulator.U8,
+			Bank:    emulator.WRAM,
+			Address: 0x100,
+			Count:   4,
+		}},
+	}
+
+	scripts := map[*emulator.ReadPlan]string{
+		lives:   "state = {}\nfunction onTick() state.lives = lives end\n",
+		enemies: "state = {}\nfunction onTick() state.count = #enemies end\n",
+	}
+	paths := make(map[*emulator.ReadPlan]string)
+	for plan, script := range scripts {
+		paths[plan] = filepath.Join(t.TempDir(), "factbuilder.lua")
+		if err := os.WriteFile(paths[plan], []byte(script), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e := loadScript(t, lives, scripts[lives])
+
+	compiled := []*emulator.CompiledReadPlan{
+		emulator.CompileReadPlan(lives, emulator.DefaultCapabilities, emulator.NWA),
+		emulator.CompileReadPlan(enemies, emulator.DefaultCapabilities, emulator.NWA),
+	}
+	mem := make([]byte, 0x200)
+
+	done := make(chan error)
+	go func() {
+		for i := range 50 {
+			plan := enemies
+			if i%2 == 1 {
+				plan = lives
+			}
+			if err := e.LoadFile(paths[plan], plan); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	for tick := 0; ; tick++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("load: %v", err)
+			}
+			return
+		default:
+		}
+
+		mem[0x10] = byte(tick)
+		// the tick may read the plan of the provider switched away from
+		if err := e.ProcessValues(readValues(compiled[tick%2], mem)); err != nil {
+			t.Fatalf("tick %d: %v", tick, err)
+		}
+		e.GetState()
+	}
+}
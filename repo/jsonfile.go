@@ -1,23 +1,19 @@
 package repo
 
 import (
+	"FactFinder/emulator"
 	"FactFinder/logger"
 	"fmt"
 	"os"
 	"path/filepath"
-
-	"gopkg.in/yaml.v3"
 )
 
 var log = logger.Module("repo/jsonfile").SetLevel(logger.DebugLevel)
 
-type readPlanYAML struct {
-	Name string `yaml:"Name"`
-}
-
 type Provider struct {
 	FilePath string
 	Name     string
+	Match    []emulator.GameMatch `json:"-"`
 }
 
 func ScanReadPlans(providerDir string) ([]Provider, error) {
@@ -48,20 +44,14 @@ func ScanReadPlans(providerDir string) ([]Provider, error) {
 			continue
 		}
 
-		// Parse YAML and extract Name
-		b, err := os.ReadFile(readPlanPath)
+		// Resolve Extends/Include and extract Name and Match
+		summary, err := emulator.SummarizeReadPlan(os.DirFS(providerDir), ent.Name()+"/readplan.yml")
 		if err != nil {
-			log.Error("failed reading %s: %v", readPlanPath, err)
-			return nil, fmt.Errorf("read %q: %w", readPlanPath, err)
-		}
-
-		var rp readPlanYAML
-		if err := yaml.Unmarshal(b, &rp); err != nil {
-			log.Error("failed parsing yaml %s: %v", readPlanPath, err)
-			return nil, fmt.Errorf("parse %q: %w", readPlanPath, err)
+			log.Warn("skipping provider %s: %v", readPlanPath, err)
+			continue
 		}
 
-		if rp.Name == "" {
+		if summary.Name == "" {
 			log.Warn("skipping provider with missing Name: %s", readPlanPath)
 			continue
 		}
@@ -72,11 +62,12 @@ func ScanReadPlans(providerDir string) ([]Provider, error) {
 			return nil, fmt.Errorf("abs path for %q: %w", dirPath, err)
 		}
 
-		log.Debug("loaded provider: %s (%s)", rp.Name, absDir)
+		log.Debug("loaded provider: %s (%s)", summary.Name, absDir)
 
 		out = append(out, Provider{
 			FilePath: absDir,
-			Name:     rp.Name,
+			Name:     summary.Name,
+			Match:    summary.Match,
 		})
 	}
 
@@ -0,0 +1,41 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeProvider writes a provider folder with readplan and an empty
+// factbuilder.
+func writeProvider(t *testing.T, dir, name, readplan string) {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(path, "readplan.yml"), []byte(readplan), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(path, "factbuilder.lua"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScanReadPlansSkipsBrokenProviders(t *testing.T) {
+	dir := t.TempDir()
+
+	writeProvider(t, dir, "smw", "Name: Super Mario World\nPlatform: SNES\n")
+	writeProvider(t, dir, "missing", "Extends: ../base.yml\nName: Missing Base\n")
+	writeProvider(t, dir, "cycle", "Extends: ../cycle/readplan.yml\nName: Cycle\n")
+	writeProvider(t, dir, "yaml", "Name: [unterminated\n")
+
+	providers, err := ScanReadPlans(dir)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if len(providers) != 1 || providers[0].Name != "Super Mario World" {
+		t.Errorf("providers %+v, want Super Mario World only", providers)
+	}
+}
@@ -0,0 +1,77 @@
+package repo
+
+import (
+	"FactFinder/logger"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var permissionsLog = logger.Module("repo/permissions").SetLevel(logger.InfoLevel)
+
+// Permissions are what the user allowed each provider to do, kept in
+// permissions.json next to the providers folder so a provider cannot grant
+// them itself.
+type Permissions struct {
+	m    sync.Mutex
+	path string
+
+	// providers allowed to write game memory, by FilePath
+	Writes map[string]bool `json:"writes"`
+}
+
+func LoadPermissions(appDir string) (*Permissions, error) {
+	p := &Permissions{
+		path:   filepath.Join(appDir, "permissions.json"),
+		Writes: make(map[string]bool),
+	}
+
+	data, err := os.ReadFile(p.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("parse %q: %w", p.path, err)
+	}
+	if p.Writes == nil {
+		p.Writes = make(map[string]bool)
+	}
+
+	return p, nil
+}
+
+// WritesAllowed reports whether the provider at path may write memory.
+func (p *Permissions) WritesAllowed(path string) bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+	return p.Writes[path]
+}
+
+// AllowWrites grants or revokes memory writes for the provider at path and
+// saves the permissions.
+func (p *Permissions) AllowWrites(path string, allowed bool) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if allowed {
+		p.Writes[path] = true
+	} else {
+		delete(p.Writes, path)
+	}
+
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	permissionsLog.Info("memory writes for %s: %v", path, allowed)
+	return os.WriteFile(p.path, data, 0644)
+}